@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// Abbreviations are defined in the config file:
+//
+//	abbrev teh the
+//	abbrev sbx sandbox
+//
+// In editing mode, an abbreviation typed as a whole word is expanded once
+// a character that can't be part of a word follows it. Each expansion is an
+// undo block of its own. Setting the abbrevs buffer-local variable to false,
+// or toggling it with toggleAbbrevs, turns the expansion off for a buffer.
+
+func parseAbbrev(f []string) (string, string, error) {
+	if len(f) != 3 {
+		return "", "", fmt.Errorf("expected abbrev word expansion")
+	}
+	return f[1], f[2], nil
+}
+
+func (file *File) abbrevs() bool {
+	v, ok := file.Var("abbrevs")
+	return !ok || v != "false"
+}
+
+func toggleAbbrevs(med *Med, file *File) {
+	if file.vars == nil {
+		file.vars = make(map[string]string)
+	}
+	if file.abbrevs() {
+		file.vars["abbrevs"] = "false"
+		med.showMessage("abbreviations off")
+	} else {
+		file.vars["abbrevs"] = "true"
+		med.showMessage("abbreviations on")
+	}
+}
+
+// Expand the abbreviation before the character just typed before the point.
+func (med *Med) expandAbbrev(file *File) {
+	off := file.point.Off
+	r, s := utf8.DecodeLastRune(file.text.Slice(0, off))
+	if isIdentRune(r) || len(med.config.abbrevs) == 0 || !file.abbrevs() {
+		return
+	}
+	end := off - s
+	start := end
+	for start > 0 {
+		r, s := utf8.DecodeLastRune(file.text.Slice(0, start))
+		if !isIdentRune(r) {
+			break
+		}
+		start -= s
+	}
+	exp, ok := med.config.abbrevs[string(file.text.Slice(start, end))]
+	if !ok || start == end {
+		return
+	}
+	file.BeginUndoGroup()
+	file.Delete(start, end)
+	file.Insert([]byte(exp))
+	file.Goto(off + len(exp) - (end - start))
+	file.EndUndoGroup()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"github.com/jsynacek/med/buffer"
+	"github.com/jsynacek/med/term"
+	"sort"
+)
+
+// Besides the mark, any number of lines of a buffer can be bookmarked. Every
+// bookmarked line shows a sign in the last column of the view, the one where
+// long lines show their continuation. The bookmarks move with the text as it
+// is edited, a bookmark on a line that is deleted ends up on the line that
+// takes its place.
+
+// Move the bookmarks after an insert of n bytes at off, or a delete of n bytes
+// at off if n is negative. The offsets may end up anywhere in their lines, see
+// bookmarkLines.
+func (file *File) shiftBookmarks(off, n int) {
+	for i, b := range file.bookmarks {
+		switch {
+		case n >= 0 && b >= off:
+			file.bookmarks[i] = b + n
+		case n < 0 && b >= off-n:
+			file.bookmarks[i] = b + n
+		case n < 0 && b > off:
+			file.bookmarks[i] = off
+		}
+	}
+}
+
+// Return the starts of the bookmarked lines, sorted.
+func (file *File) bookmarkLines() []int {
+	var lines []int
+	for _, b := range file.bookmarks {
+		ls := buffer.LineStart(file.text.Bytes(), min(b, file.text.Len()))
+		if i := sort.SearchInts(lines, ls); i == len(lines) || lines[i] != ls {
+			lines = append(lines[:i], append([]int{ls}, lines[i:]...)...)
+		}
+	}
+	file.bookmarks = lines
+	return lines
+}
+
+// Bookmark the line of the point, or remove its bookmark.
+func toggleBookmark(med *Med, file *File) {
+	lines := file.bookmarkLines()
+	ls := buffer.LineStart(file.text.Bytes(), file.point.Off)
+	i := sort.SearchInts(lines, ls)
+	if i < len(lines) && lines[i] == ls {
+		file.bookmarks = append(lines[:i], lines[i+1:]...)
+		return
+	}
+	file.bookmarks = append(lines[:i], append([]int{ls}, lines[i:]...)...)
+}
+
+// Go to the next bookmarked line, wrapping around the end of the buffer.
+func nextBookmark(med *Med, file *File) {
+	med.gotoBookmark(file, 1)
+}
+
+// Go to the previous bookmarked line, wrapping around the start of the buffer.
+func prevBookmark(med *Med, file *File) {
+	med.gotoBookmark(file, -1)
+}
+
+func (med *Med) gotoBookmark(file *File, dir int) {
+	lines := file.bookmarkLines()
+	if len(lines) == 0 {
+		med.pushError(errors.New("no bookmarks"))
+		return
+	}
+	ls := buffer.LineStart(file.text.Bytes(), file.point.Off)
+	i := sort.SearchInts(lines, ls)
+	if dir > 0 {
+		if i < len(lines) && lines[i] == ls {
+			i++
+		}
+	} else {
+		i--
+	}
+	i = (i%len(lines) + len(lines)) % len(lines)
+	file.leaveMark()
+	file.Goto(lines[i])
+}
+
+// Draw the signs of the bookmarked lines of the view.
+func (view *View) displayBookmarks(t *term.Term, text []byte) {
+	if len(view.bookmarks) == 0 {
+		return
+	}
+	rows, offs := view.lineRows(text)
+	theme["bookmark"].Out(t)
+	for i, off := range offs {
+		if j := sort.SearchInts(view.bookmarks, off); j < len(view.bookmarks) && view.bookmarks[j] == off {
+			t.MoveTo(view.top+rows[i], view.left+view.width)
+			t.Write([]byte(string(view.visual.bookmarkChar)))
+		}
+	}
+	theme["normal"].Out(t)
+}
@@ -0,0 +1,437 @@
+package buffer
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestLines(t *testing.T) {
+	text := []byte("ab\n\ncd")
+	tests := []struct {
+		off, start, end int
+	}{
+		{0, 0, 2},
+		{2, 0, 2},
+		{3, 3, 3},
+		{4, 4, 6},
+		{6, 4, 6},
+	}
+	for _, test := range tests {
+		if s, e := LineStart(text, test.off), LineEnd(text, test.off); s != test.start || e != test.end {
+			t.Errorf("line at %d: got %d,%d, want %d,%d", test.off, s, e, test.start, test.end)
+		}
+	}
+}
+
+func TestLineChunk(t *testing.T) {
+	long := make([]byte, 3*MaxLineScan)
+	for i := range long {
+		long[i] = 'x'
+	}
+	text := append([]byte("short\n"), long...)
+	if s, _ := LineChunk(text, 3); s != 0 {
+		t.Errorf("chunk of a short line: got start %d, want 0", s)
+	}
+	// Lines are never broken in the first MaxLineScan bytes.
+	if s, _ := LineChunk(text, 6+MaxLineScan); s != 6 {
+		t.Errorf("first chunk: got start %d, want 6", s)
+	}
+	if s, _ := LineChunk(text, len(text)); s != 3*MaxLineScan {
+		t.Errorf("last chunk: got start %d, want %d", s, 3*MaxLineScan)
+	}
+	if s, _ := LineChunk(text, -1); s != 0 {
+		t.Errorf("chunk before the text: got start %d, want 0", s)
+	}
+}
+
+func TestVisualLines(t *testing.T) {
+	text := []byte("abcdef\n\tx")
+	if end, next := VisualLineEnd(text, 0, 8, 4); end != 3 || next != 4 {
+		t.Errorf("wrapped line end: got %d,%d, want 3,4", end, next)
+	}
+	if start, _ := VisualLineStart(text, 5, 8, 4); start != 4 {
+		t.Errorf("wrapped line start: got %d, want 4", start)
+	}
+	if end, next := VisualLineEnd(text, 4, 8, 10); end != 6 || next != 7 {
+		t.Errorf("line end: got %d,%d, want 6,7", end, next)
+	}
+}
+
+func TestInsertDelete(t *testing.T) {
+	text := Insert([]byte("ad"), 1, []byte("bc"))
+	if string(text) != "abcd" {
+		t.Errorf("insert: got %q", text)
+	}
+	text, what := Delete(text, 1, 3)
+	if string(text) != "ad" || string(what) != "bc" {
+		t.Errorf("delete: got %q, %q", text, what)
+	}
+}
+
+func TestPoint(t *testing.T) {
+	text := []byte("a\tb\nxy\n")
+	var p Point
+	p.Goto(text, 2, 8)
+	if p.Col != 8 || p.Line != 0 {
+		t.Errorf("goto: got column %d, line %d, want 8, 0", p.Col, p.Line)
+	}
+	p.Down(text, 8, true)
+	if p.Off != 6 || p.Line != 1 {
+		t.Errorf("down: got offset %d, line %d, want 6, 1", p.Off, p.Line)
+	}
+	p.Up(text, 8, true)
+	if p.Off != 2 {
+		t.Errorf("up: got offset %d, want 2", p.Off)
+	}
+	p.TextEnd(text, 8)
+	if p.Off != len(text) || p.Line != 2 {
+		t.Errorf("text end: got offset %d, line %d", p.Off, p.Line)
+	}
+}
+
+func FuzzInsertDelete(f *testing.F) {
+	f.Add([]byte("hello\nworld"), 3, []byte("ü\n"))
+	f.Add([]byte(""), 0, []byte("x"))
+	ops := TextOps{Check: true}
+	f.Fuzz(func(t *testing.T, text []byte, off int, what []byte) {
+		if off < 0 || off > len(text) || off < len(text) && !utf8.RuneStart(text[off]) || !utf8.Valid(what) {
+			return
+		}
+		orig := append([]byte(nil), text...)
+		// Leave spare capacity, so the operations can corrupt shared arrays.
+		text = append(make([]byte, 0, 2*len(text)+len(what)), text...)
+		text = ops.Insert(text, off, what)
+		text, deleted := ops.Delete(text, off, off+len(what))
+		if !bytes.Equal(text, orig) || !bytes.Equal(deleted, what) {
+			t.Errorf("insert and delete of %q at %d: got %q, want %q", what, off, text, orig)
+		}
+	})
+}
+
+func TestTextNoAliasing(t *testing.T) {
+	what := make([]byte, 1, 10)
+	what[0] = 'x'
+	spare := what[:2]
+	text := NewText([]byte("abc"))
+	text.Insert(1, what)
+	if string(text.Bytes()) != "axbc" || spare[1] != 0 {
+		t.Errorf("insert: got %q, spare capacity %q", text.Bytes(), spare)
+	}
+	// Inserting a part of the text itself.
+	text = NewText([]byte("abcd"))
+	text.Insert(1, text.Bytes()[2:4])
+	if string(text.Bytes()) != "acdbcd" {
+		t.Errorf("insert of own part: got %q", text.Bytes())
+	}
+	deleted := text.Delete(0, 2)
+	text.Insert(0, []byte("zz"))
+	if string(deleted) != "ac" {
+		t.Errorf("delete: deleted text changed to %q", deleted)
+	}
+	// Appending to the text must not write into the gap.
+	b := append(text.Bytes(), 'q')
+	text.Insert(text.Len(), []byte("y"))
+	if string(b) != "zzdbcdq" || string(text.Bytes()) != "zzdbcdy" {
+		t.Errorf("append to bytes: got %q and %q", b, text.Bytes())
+	}
+}
+
+// Random edits of a gap buffer and of a flat slice must give the same text,
+// and reading the gap buffer must not depend on where its gap is.
+func TestText(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	var text Text
+	var flat []byte
+	alphabet := []byte("ab \n\té_")
+	for i := 0; i < 2000; i++ {
+		// Stay at rune starts.
+		off := r.Intn(len(flat) + 1)
+		for off < len(flat) && !utf8.RuneStart(flat[off]) {
+			off++
+		}
+		if r.Intn(3) == 0 {
+			to := off + r.Intn(10)
+			for to < len(flat) && !utf8.RuneStart(flat[to]) {
+				to++
+			}
+			to = min(to, len(flat))
+			want := string(flat[off:to])
+			flat = append(flat[:off], flat[to:]...)
+			if got := text.Delete(off, to); string(got) != want {
+				t.Fatalf("delete %d,%d: deleted %q, want %q", off, to, got, want)
+			}
+		} else {
+			var what []byte
+			for n := r.Intn(20); n > 0; n-- {
+				c := r.Intn(len(alphabet))
+				for !utf8.RuneStart(alphabet[c]) {
+					c--
+				}
+				_, s := utf8.DecodeRune(alphabet[c:])
+				what = append(what, alphabet[c:c+s]...)
+			}
+			flat = append(flat[:off], append(what, flat[off:]...)...)
+			text.Insert(off, what)
+		}
+		if text.Len() != len(flat) {
+			t.Fatalf("Len() = %d, want %d", text.Len(), len(flat))
+		}
+		off = r.Intn(len(flat) + 1)
+		end := off + r.Intn(len(flat)-off+1)
+		p := Point{Off: off}
+		switch {
+		case text.LineStart(off) != LineStart(flat, off):
+			t.Fatalf("LineStart(%d) = %d, want %d", off, text.LineStart(off), LineStart(flat, off))
+		case text.LineEnd(off) != LineEnd(flat, off):
+			t.Fatalf("LineEnd(%d) = %d, want %d", off, text.LineEnd(off), LineEnd(flat, off))
+		case text.Newlines(off, end) != bytes.Count(flat[off:end], nl):
+			t.Fatalf("Newlines(%d, %d) = %d", off, end, text.Newlines(off, end))
+		case text.Column(off, 8) != p.Column(flat, 8):
+			t.Fatalf("Column(%d) = %d, want %d", off, text.Column(off, 8), p.Column(flat, 8))
+		case string(text.Slice(off, end)) != string(flat[off:end]):
+			t.Fatalf("Slice(%d, %d) = %q", off, end, text.Slice(off, end))
+		}
+	}
+	if !bytes.Equal(text.Bytes(), flat) {
+		t.Errorf("Bytes() = %q, want %q", text.Bytes(), flat)
+	}
+}
+
+// Edits close to each other take the same time, however large the text is.
+// Typing into the middle of a flat slice moves all the text after the point
+// with every key.
+func BenchmarkTextEdit(b *testing.B) {
+	line := []byte("http            80/tcp          www www-http\n")
+	for _, lines := range []int{1000, 100000, 1000000} {
+		b.Run(fmt.Sprint(len(line)*lines), func(b *testing.B) {
+			text := NewText(bytes.Repeat(line, lines))
+			off := text.Len() / 2
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// Type a word, then take back its last letter.
+				if i%8 == 7 {
+					off--
+					text.Delete(off, off+1)
+				} else {
+					text.Insert(off, []byte("x"))
+					off++
+				}
+			}
+		})
+	}
+}
+
+func TestWordIndex(t *testing.T) {
+	text := NewText([]byte("alpha beta\nalpha_2 gamma"))
+	wi := NewWordIndex(text.Bytes())
+	edit := func(off, end int, what string) {
+		wi.Before(&text, off, end)
+		text.Delete(off, end)
+		text.Insert(off, []byte(what))
+		wi.After(&text, off, off+len(what))
+	}
+	edit(5, 6, "")         // "alphabeta"
+	edit(0, 0, "x ")       // "x alphabeta"
+	edit(11, 11, " alpha") // "x alphabeta alpha\n..."
+	edit(text.Len(), text.Len(), " éta")
+	// Words longer than the part of the text read at first.
+	edit(2, 2, strings.Repeat("é", 100))
+	edit(text.Len()-2, text.Len()-2, strings.Repeat("b", 100))
+	edit(50, 150, "")
+	want := NewWordIndex(text.Bytes())
+	if len(wi.counts) != len(want.counts) {
+		t.Fatalf("counts = %v, want %v", wi.counts, want.counts)
+	}
+	for w, n := range want.counts {
+		if wi.counts[w] != n {
+			t.Errorf("count of %q = %d, want %d", w, wi.counts[w], n)
+		}
+	}
+	if got := strings.Join(wi.Words("alp"), " "); got != "alpha alpha_2" {
+		t.Errorf("Words(alp) = %q", got)
+	}
+}
+
+func TestSearchRegexp(t *testing.T) {
+	text := []byte("ab1\ncd22\nab3")
+	re := regexp.MustCompile(`(?m)^ab\d`)
+	tests := []struct {
+		off, start, end int
+	}{
+		{0, 0, 3},
+		{1, 9, 12},
+		{9, 9, 12},
+		{10, -1, -1},
+	}
+	for _, test := range tests {
+		if start, end := SearchRegexp(text, re, test.off); start != test.start || end != test.end {
+			t.Errorf("SearchRegexp(%d) = %d, %d, want %d, %d", test.off, start, end, test.start, test.end)
+		}
+	}
+	if start, end := SearchRegexp(text, regexp.MustCompile(`\d+`), 7); start != 7 || end != 8 {
+		t.Errorf("SearchRegexp inside a match = %d, %d, want 7, 8", start, end)
+	}
+}
+
+func TestSearchRegexpBackward(t *testing.T) {
+	text := []byte("ab1\ncd22\nab3")
+	re := regexp.MustCompile(`(?m)^ab\d`)
+	tests := []struct {
+		off, start, end int
+	}{
+		{12, 9, 12},
+		{9, 0, 3},
+		{5, 0, 3},
+		{0, -1, -1},
+	}
+	for _, test := range tests {
+		if start, end := SearchRegexpBackward(text, re, test.off); start != test.start || end != test.end {
+			t.Errorf("SearchRegexpBackward(%d) = %d, %d, want %d, %d", test.off, start, end, test.start, test.end)
+		}
+	}
+	if start, end := SearchRegexpBackward(text, regexp.MustCompile(`\d`), 8); start != 7 || end != 8 {
+		t.Errorf("SearchRegexpBackward(8) = %d, %d, want 7, 8", start, end)
+	}
+}
+
+func TestInvisible(t *testing.T) {
+	for _, r := range "\u202e\u2066\u200b\u200d\ufeff\u00ad\x00\x1b\u0085\U000e0041" {
+		if !Invisible(r) {
+			t.Errorf("Invisible(%U) = false, want true", r)
+		}
+	}
+	for _, r := range "a \t\né\u0301\u3042" {
+		if Invisible(r) {
+			t.Errorf("Invisible(%U) = true, want false", r)
+		}
+	}
+}
+
+func TestLineIndex(t *testing.T) {
+	text := []byte("ab\ncd\n\nef")
+	li := NewLineIndex(text)
+	edits := []struct {
+		off, del int
+		ins      string
+	}{
+		{0, 0, "x\ny\n"},
+		{3, 2, ""},
+		{len("x\nyab\ncd\n"), 1, "\n\n"},
+		{1, 6, "z"},
+		{0, 0, ""},
+		{2, 3, "\n"},
+	}
+	for _, e := range edits {
+		var what []byte
+		text, what = Delete(text, e.off, e.off+e.del)
+		li.Delete(e.off, len(what))
+		text = Insert(text, e.off, []byte(e.ins))
+		li.Insert(e.off, []byte(e.ins))
+		want := NewLineIndex(text)
+		if li.Lines() != want.Lines() {
+			t.Fatalf("%q: Lines() = %d, want %d", text, li.Lines(), want.Lines())
+		}
+		for l := 0; l < want.Lines(); l++ {
+			if li.Start(l) != want.Start(l) {
+				t.Errorf("%q: Start(%d) = %d, want %d", text, l, li.Start(l), want.Start(l))
+			}
+		}
+		for off := 0; off <= len(text); off++ {
+			if l := bytes.Count(text[:off], nl); li.Line(off) != l {
+				t.Errorf("%q: Line(%d) = %d, want %d", text, off, li.Line(off), l)
+			}
+		}
+	}
+}
+
+// Apply hunks of a diff of a and b to a, which must give b, and count the
+// changed lines.
+func applyHunks(t *testing.T, a, b []string, hunks []Hunk) (changed int) {
+	var res []string
+	i, j := 0, 0
+	for _, h := range hunks {
+		if h.A0-i != h.B0-j {
+			t.Fatalf("%q -> %q: hunk %v misaligned", a, b, h)
+		}
+		res = append(res, a[i:h.A0]...)
+		res = append(res, b[h.B0:h.B1]...)
+		changed += h.A1 - h.A0 + h.B1 - h.B0
+		i, j = h.A1, h.B1
+	}
+	res = append(res, a[i:]...)
+	if strings.Join(res, "") != strings.Join(b, "") {
+		t.Fatalf("%q -> %q: hunks %v give %q", a, b, hunks, res)
+	}
+	return
+}
+
+// Length of the longest common subsequence, by dynamic programming.
+func lcs(a, b []string) int {
+	l := make([][]int, len(a)+1)
+	for i := range l {
+		l[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				l[i][j] = l[i+1][j+1] + 1
+			} else {
+				l[i][j] = max(l[i+1][j], l[i][j+1])
+			}
+		}
+	}
+	return l[0][0]
+}
+
+func byteLines(lines []string) (res [][]byte) {
+	for _, l := range lines {
+		res = append(res, []byte(l))
+	}
+	return
+}
+
+func TestDiffLines(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	random := func() (lines []string) {
+		for n := rnd.Intn(12); n > 0; n-- {
+			lines = append(lines, string(rune('a'+rnd.Intn(4)))+"\n")
+		}
+		return
+	}
+	for i := 0; i < 2000; i++ {
+		a, b := random(), random()
+		changed := applyHunks(t, a, b, DiffLines(byteLines(a), byteLines(b)))
+		if want := len(a) + len(b) - 2*lcs(a, b); changed != want {
+			t.Fatalf("%q -> %q: %d lines changed, want %d", a, b, changed, want)
+		}
+	}
+}
+
+// Diffing texts with nothing in common must not take memory quadratic in
+// their length.
+func TestDiffLinesLarge(t *testing.T) {
+	var a, b []string
+	for i := 0; i < 4000; i++ {
+		a = append(a, fmt.Sprintf("a%d\n", i))
+		b = append(b, fmt.Sprintf("b%d\n", i))
+	}
+	// Some lines in common, too.
+	for i := 0; i < 4000; i += 7 {
+		b[i] = a[i]
+	}
+	al, bl := byteLines(a), byteLines(b)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	hunks := DiffLines(al, bl)
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 8<<20 {
+		t.Errorf("diff of %d lines allocated %d bytes", len(a), n)
+	}
+	applyHunks(t, a, b, hunks)
+}
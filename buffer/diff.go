@@ -0,0 +1,188 @@
+package buffer
+
+import (
+	"bytes"
+)
+
+// Hunk is a difference between two texts. Lines [A0, A1) of the first text
+// correspond to lines [B0, B1) of the second one. Lines are numbered from 0.
+type Hunk struct {
+	A0, A1 int
+	B0, B1 int
+}
+
+// DiffLines computes the differences between lines a and b using Myers'
+// algorithm in linear space: both are split at the middle of a shortest edit
+// script, found by searching from both ends at once, and the halves are
+// diffed the same way.
+func DiffLines(a, b [][]byte) (hunks []Hunk) {
+	n, m := len(a), len(b)
+	// Skip the common prefix and suffix, which is what most edits leave.
+	pre := 0
+	for pre < n && pre < m && bytes.Equal(a[pre], b[pre]) {
+		pre++
+	}
+	suf := 0
+	for suf < n-pre && suf < m-pre && bytes.Equal(a[n-1-suf], b[m-1-suf]) {
+		suf++
+	}
+	achanged := make([]bool, n)
+	bchanged := make([]bool, m)
+	ai, bi := lineIDs(a[pre:n-suf], b[pre:m-suf])
+	d := &differ{a: ai, b: bi, achanged: achanged[pre : n-suf], bchanged: bchanged[pre : m-suf]}
+	d.v1 = make([]int, len(ai)+len(bi)+3)
+	d.v2 = make([]int, len(d.v1))
+	d.diff(0, len(ai), 0, len(bi))
+	// Unchanged lines of both texts correspond to each other in order.
+	for i, j := 0, 0; i < n || j < m; {
+		if i < n && j < m && !achanged[i] && !bchanged[j] {
+			i++
+			j++
+			continue
+		}
+		h := Hunk{A0: i, B0: j}
+		for i < n && achanged[i] {
+			i++
+		}
+		for j < m && bchanged[j] {
+			j++
+		}
+		h.A1, h.B1 = i, j
+		hunks = append(hunks, h)
+	}
+	return
+}
+
+// Number the lines of a and b, so equal lines get equal numbers and compare
+// fast.
+func lineIDs(a, b [][]byte) (ai, bi []int) {
+	ids := make(map[string]int)
+	number := func(lines [][]byte) []int {
+		res := make([]int, len(lines))
+		for i, l := range lines {
+			id, ok := ids[string(l)]
+			if !ok {
+				id = len(ids)
+				ids[string(l)] = id
+			}
+			res[i] = id
+		}
+		return res
+	}
+	return number(a), number(b)
+}
+
+type differ struct {
+	a, b               []int
+	achanged, bchanged []bool
+	// Furthest x reached on each diagonal, searching forward and backward.
+	// The search of every split reuses them.
+	v1, v2 []int
+}
+
+// Mark lines [a0, a1) of a that were deleted and lines [b0, b1) of b that
+// were inserted.
+func (d *differ) diff(a0, a1, b0, b1 int) {
+	for a0 < a1 && b0 < b1 && d.a[a0] == d.b[b0] {
+		a0++
+		b0++
+	}
+	for a0 < a1 && b0 < b1 && d.a[a1-1] == d.b[b1-1] {
+		a1--
+		b1--
+	}
+	if a0 == a1 || b0 == b1 {
+		for i := a0; i < a1; i++ {
+			d.achanged[i] = true
+		}
+		for j := b0; j < b1; j++ {
+			d.bchanged[j] = true
+		}
+		return
+	}
+	x, y, ok := d.split(d.a[a0:a1], d.b[b0:b1])
+	if !ok {
+		// Nothing in common.
+		d.diff(a1, a1, b0, b1)
+		d.diff(a0, a1, b1, b1)
+		return
+	}
+	d.diff(a0, a0+x, b0, b0+y)
+	d.diff(a0+x, a1, b0+y, b1)
+}
+
+// Find where the forward and backward searches for the shortest edit script
+// of a and b meet, which splits it in two halves. The searches follow the
+// diagonals k = x - y, the backward one counting x and y from the ends.
+func (d *differ) split(a, b []int) (x, y int, ok bool) {
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	off := maxD
+	v1, v2 := d.v1[:2*maxD+2], d.v2[:2*maxD+2]
+	for i := range v1 {
+		v1[i], v2[i] = -1, -1
+	}
+	v1[off+1], v2[off+1] = 0, 0
+	delta := n - m
+	// With an odd delta, the forward search is the one to meet the backward
+	// one, otherwise it's the other way around.
+	front := delta%2 != 0
+	// Diagonals that left the edit graph aren't searched anymore.
+	k1start, k1end, k2start, k2end := 0, 0, 0, 0
+	for e := 0; e < maxD; e++ {
+		for k1 := -e + k1start; k1 <= e-k1end; k1 += 2 {
+			i := off + k1
+			var x1 int
+			if k1 == -e || k1 != e && v1[i-1] < v1[i+1] {
+				x1 = v1[i+1]
+			} else {
+				x1 = v1[i-1] + 1
+			}
+			y1 := x1 - k1
+			for x1 < n && y1 < m && a[x1] == b[y1] {
+				x1++
+				y1++
+			}
+			v1[i] = x1
+			switch {
+			case x1 > n:
+				k1end += 2
+			case y1 > m:
+				k1start += 2
+			case front:
+				if j := off + delta - k1; j >= 0 && j < len(v2) && v2[j] != -1 && x1 >= n-v2[j] {
+					return x1, y1, true
+				}
+			}
+		}
+		for k2 := -e + k2start; k2 <= e-k2end; k2 += 2 {
+			i := off + k2
+			var x2 int
+			if k2 == -e || k2 != e && v2[i-1] < v2[i+1] {
+				x2 = v2[i+1]
+			} else {
+				x2 = v2[i-1] + 1
+			}
+			y2 := x2 - k2
+			for x2 < n && y2 < m && a[n-x2-1] == b[m-y2-1] {
+				x2++
+				y2++
+			}
+			v2[i] = x2
+			switch {
+			case x2 > n:
+				k2end += 2
+			case y2 > m:
+				k2start += 2
+			case !front:
+				if j := off + delta - k2; j >= 0 && j < len(v1) && v1[j] != -1 {
+					x1 := v1[j]
+					if y1 := off + x1 - j; x1 >= n-x2 {
+						return x1, y1, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
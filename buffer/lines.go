@@ -0,0 +1,71 @@
+package buffer
+
+import (
+	"sort"
+)
+
+// LineIndex keeps the offsets of the starts of all lines of a text, so that
+// lines can be found by number and numbers by offset without scanning the
+// text. It is kept up to date by telling it about every edit.
+type LineIndex struct {
+	starts []int
+}
+
+func NewLineIndex(text []byte) *LineIndex {
+	li := &LineIndex{starts: []int{0}}
+	li.Insert(0, text)
+	return li
+}
+
+// Number of lines, which is one more than the number of newlines.
+func (li *LineIndex) Lines() int {
+	return len(li.starts)
+}
+
+// Line of the offset, starting at 0.
+func (li *LineIndex) Line(off int) int {
+	return sort.SearchInts(li.starts, off+1) - 1
+}
+
+// Offset of the start of line l, starting at 0. Lines past the end start at
+// the start of the last line.
+func (li *LineIndex) Start(l int) int {
+	return li.starts[max(0, min(l, len(li.starts)-1))]
+}
+
+// Tell the index that what was inserted at off.
+func (li *LineIndex) Insert(off int, what []byte) {
+	if len(what) == 0 {
+		return
+	}
+	i := li.Line(off) + 1
+	var starts []int
+	for j, c := range what {
+		if c == '\n' {
+			starts = append(starts, off+j+1)
+		}
+	}
+	for j := i; j < len(li.starts); j++ {
+		li.starts[j] += len(what)
+	}
+	if len(starts) > 0 {
+		li.starts = append(li.starts[:i], append(starts, li.starts[i:]...)...)
+	}
+}
+
+// Tell the index that n bytes were deleted at off.
+func (li *LineIndex) Delete(off, n int) {
+	if n == 0 {
+		return
+	}
+	// Lines starting in the deleted text are gone.
+	i := li.Line(off) + 1
+	j := i
+	for j < len(li.starts) && li.starts[j] <= off+n {
+		j++
+	}
+	li.starts = append(li.starts[:i], li.starts[j:]...)
+	for k := i; k < len(li.starts); k++ {
+		li.starts[k] -= n
+	}
+}
@@ -0,0 +1,79 @@
+package buffer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"unicode/utf8"
+)
+
+// TextOps performs the basic text operations. With Check set, it verifies
+// their arguments and results and panics when they are wrong. That is too
+// slow for everyday editing, but catches offsets outside of the text, offsets
+// in the middle of UTF-8 sequences, and text corrupted by slices sharing
+// their backing arrays, when fuzzing or testing.
+type TextOps struct {
+	Check bool
+}
+
+// Ops is used by the editor. Checks are enabled by setting MED_CHECK_TEXT
+// in the environment.
+var Ops = TextOps{Check: os.Getenv("MED_CHECK_TEXT") != ""}
+
+func (o TextOps) checkOffset(text []byte, off int, what string) {
+	switch {
+	case off < 0 || off > len(text):
+		panic(fmt.Sprintf("%s: offset %d out of range [0,%d]", what, off, len(text)))
+	case off < len(text) && !utf8.RuneStart(text[off]):
+		panic(fmt.Sprintf("%s: offset %d in the middle of a UTF-8 sequence", what, off))
+	}
+}
+
+func (o TextOps) Insert(text []byte, off int, what []byte) []byte {
+	if !o.Check {
+		return Insert(text, off, what)
+	}
+	o.checkOffset(text, off, "insert")
+	want := append(append(append([]byte(nil), text[:off]...), what...), text[off:]...)
+	saved := append([]byte(nil), what...)
+	text = Insert(text, off, what)
+	if !bytes.Equal(what, saved) {
+		panic("insert: inserted text was modified")
+	}
+	if !bytes.Equal(text, want) {
+		panic("insert: wrong result")
+	}
+	return text
+}
+
+func (o TextOps) Delete(text []byte, off, to int) ([]byte, []byte) {
+	if !o.Check {
+		return Delete(text, off, to)
+	}
+	o.checkOffset(text, off, "delete")
+	o.checkOffset(text, min(to, len(text)), "delete")
+	if to < off {
+		panic(fmt.Sprintf("delete: range %d,%d is reversed", off, to))
+	}
+	want := append(append([]byte(nil), text[:off]...), text[min(to, len(text)):]...)
+	deleted := append([]byte(nil), text[off:min(to, len(text))]...)
+	text, what := Delete(text, off, to)
+	if !bytes.Equal(text, want) || !bytes.Equal(what, deleted) {
+		panic("delete: wrong result")
+	}
+	return text, what
+}
+
+func (o TextOps) LineStart(text []byte, off int) int {
+	if o.Check {
+		o.checkOffset(text, off, "line start")
+	}
+	return LineStart(text, off)
+}
+
+func (o TextOps) LineEnd(text []byte, off int) int {
+	if o.Check {
+		o.checkOffset(text, off, "line end")
+	}
+	return LineEnd(text, off)
+}
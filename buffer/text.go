@@ -0,0 +1,429 @@
+// Package buffer implements operations on text and a point moving through it.
+// Most take the text as a byte slice, Text keeps the text of a buffer while it
+// is edited. Offsets are in bytes, columns in screen cells.
+package buffer
+
+import (
+	"bytes"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+)
+
+var nl = []byte("\n")
+
+func min(x, y int) int {
+	if x < y {
+		return x
+	}
+	return y
+}
+
+func max(x, y int) int {
+	if x > y {
+		return x
+	}
+	return y
+}
+
+func WordNext(text []byte, point int) int {
+	for point < len(text) {
+		r, s := utf8.DecodeRune(text[point:])
+		if unicode.IsLetter(r) {
+			break
+		}
+		point += s
+	}
+	for point < len(text) {
+		r, s := utf8.DecodeRune(text[point:])
+		if !unicode.IsLetter(r) {
+			break
+		}
+		point += s
+	}
+	return point
+}
+
+func WordPrev(text []byte, point int) int {
+	for point > 0 {
+		r, s := utf8.DecodeLastRune(text[:point])
+		if unicode.IsLetter(r) {
+			break
+		}
+		point -= s
+	}
+	for point > 0 {
+		r, s := utf8.DecodeLastRune(text[:point])
+		if !unicode.IsLetter(r) {
+			break
+		}
+		point -= s
+	}
+	return point
+}
+
+func ParagraphNext(text []byte, point int) int {
+	i := bytes.Index(text[point:], []byte("\n\n"))
+	if i >= 0 {
+		return point + i + 2
+	}
+	return len(text)
+}
+
+func ParagraphPrev(text []byte, point int) int {
+	i := bytes.LastIndex(text[:point], []byte("\n\n"))
+	if i < 0 {
+		return 0
+	}
+	// If already at beginning of paragraph, move to previous one.
+	if i+2 == point && point > 0 {
+		i = bytes.LastIndex(text[:point-1], []byte("\n\n"))
+		if i < 0 {
+			return 0
+		}
+	}
+	return i + 2
+}
+
+// Long lines are scanned and displayed in chunks, as if they were broken at
+// chunk boundaries. Otherwise a single line of a few megabytes (minified code,
+// logs) makes every redraw and every column computation crawl.
+// Chunk boundaries are at multiples of MaxLineScan that are at least
+// MaxLineScan bytes past the start of the line, so shorter lines are never
+// broken and finding the chunk of an offset never scans more than twice that.
+const MaxLineScan = 1 << 14
+
+// Return the start of the line chunk containing off and the offset of the next
+// chunk boundary. Chunk starts are moved forward to the nearest rune start.
+func LineChunk(text []byte, off int) (start, bound int) {
+	off = max(0, min(off, len(text)))
+	b := off - off%MaxLineScan
+	if i := bytes.LastIndexByte(text[b:off], '\n'); i >= 0 {
+		start = b + i + 1
+	} else if b == 0 {
+		start = 0
+	} else if i := bytes.LastIndexByte(text[b-MaxLineScan:b], '\n'); i >= 0 {
+		start = b - MaxLineScan + i + 1
+	} else {
+		for start = b; start < off && !utf8.RuneStart(text[start]); start++ {
+		}
+		return start, b + MaxLineScan
+	}
+	return start, ChunkBound(start)
+}
+
+// First chunk boundary of a line starting at ls.
+func ChunkBound(ls int) int {
+	return (ls+MaxLineScan-1)/MaxLineScan*MaxLineScan + MaxLineScan
+}
+
+// Whether r is invisible or changes how the text around it is displayed,
+// like bidirectional overrides, zero-width spaces and control characters other
+// than tab and newline. They make the text look different from what it is.
+func Invisible(r rune) bool {
+	switch {
+	case r == '\t' || r == '\n':
+		return false
+	case unicode.Is(unicode.Cc, r),
+		r == 0xad, r == 0x61c, r == 0x180e,
+		r >= 0x200b && r <= 0x200f,
+		r >= 0x202a && r <= 0x202e,
+		r >= 0x2060 && r <= 0x2069,
+		r == 0xfeff,
+		r >= 0xfff9 && r <= 0xfffb,
+		r >= 0xe0000 && r <= 0xe007f:
+		return true
+	}
+	return false
+}
+
+// Number of screen cells taken by r. East Asian wide and fullwidth
+// characters and most emoji take two.
+func RuneWidth(r rune) int {
+	switch {
+	case r >= 0x1100 && r <= 0x115f,
+		r >= 0x2e80 && r <= 0x303e,
+		r >= 0x3041 && r <= 0x33ff,
+		r >= 0x3400 && r <= 0x4dbf,
+		r >= 0x4e00 && r <= 0x9fff,
+		r >= 0xa000 && r <= 0xa4cf,
+		r >= 0xac00 && r <= 0xd7a3,
+		r >= 0xf900 && r <= 0xfaff,
+		r >= 0xfe30 && r <= 0xfe4f,
+		r >= 0xff00 && r <= 0xff60,
+		r >= 0xffe0 && r <= 0xffe6,
+		r >= 0x1f300 && r <= 0x1f64f,
+		r >= 0x1f900 && r <= 0x1f9ff,
+		r >= 0x20000 && r <= 0x3fffd:
+		return 2
+	}
+	return 1
+}
+
+func VisualLineEnd(text []byte, off int, tabStop int, width int) (end, next int) {
+	p, bound := LineChunk(text, off)
+	for col := 0; p < len(text); {
+		if p >= bound {
+			if col > 0 && p > off {
+				return p - 1, p
+			}
+			col = 0
+			bound += MaxLineScan
+		}
+		r, s := utf8.DecodeRune(text[p:])
+		if r == '\t' {
+			col += tabStop - col%tabStop
+		} else {
+			col += RuneWidth(r)
+		}
+		if col >= width {
+			if p > off {
+				return p, p + s
+			}
+			col = 0
+		} else if r == '\n' {
+			return p, p + 1
+		}
+		p += s
+	}
+	return len(text), len(text)
+}
+
+func VisualLineStart(text []byte, off int, tabStop int, width int) (start, prev int) {
+	start, _ = LineChunk(text, off)
+	prev = max(0, start-1)
+	for p, col := start, 0; p < off && p < len(text); {
+		r, s := utf8.DecodeRune(text[p:])
+		if r == '\t' {
+			col += tabStop - col%tabStop
+		} else {
+			col += RuneWidth(r)
+		}
+		switch {
+		case col >= width:
+			start, prev = p+s, p
+			col = 0
+		case r == '\n':
+			start, prev = p+1, p
+		}
+		p += s
+	}
+	return
+}
+
+func LineEnd(text []byte, off int) int {
+	if off >= len(text) {
+		return len(text)
+	}
+	i := bytes.Index(text[off:], nl)
+	if i < 0 {
+		return len(text)
+	}
+	return off + i
+}
+
+func LineStart(text []byte, off int) int {
+	if off <= 0 {
+		return 0
+	}
+	i := bytes.LastIndex(text[:off], nl)
+	return i + 1
+}
+
+// Range of the line containing off. If newline is true, the trailing newline
+// is included, if there is one.
+func LineRange(text []byte, off int, newline bool) (start, end int) {
+	start, end = LineStart(text, off), LineEnd(text, off)
+	if newline && end < len(text) {
+		end++
+	}
+	return
+}
+
+func LineIndent(text []byte, off int) (ls int, i int) {
+	ls, le := LineStart(text, off), LineEnd(text, off)
+	off = ls
+	for i := ls; i < le && (text[i] == ' ' || text[i] == '\t'); i++ {
+		off++
+	}
+	return ls, off
+}
+
+func LineIndentText(text []byte, off int) []byte {
+	ls, off := LineIndent(text, off)
+	return append([]byte(nil), text[ls:off]...)
+}
+
+// Reindent lines of text, so that the first non-blank line starts with indent.
+// Other lines are shifted by the same amount. Lines indented less than
+// the first one lose all of their indentation.
+func Reindent(text []byte, indent []byte) []byte {
+	var old []byte
+	for p := 0; p < len(text); p = LineEnd(text, p) + 1 {
+		ls, i := LineIndent(text, p)
+		if i < LineEnd(text, p) {
+			old = text[ls:i]
+			break
+		}
+	}
+	var res []byte
+	for p := 0; p < len(text); {
+		ls, i := LineIndent(text, p)
+		le := LineEnd(text, p)
+		if i < le {
+			res = append(res, indent...)
+			if bytes.HasPrefix(text[ls:], old) {
+				res = append(res, text[ls+len(old):i]...)
+			}
+		}
+		res = append(res, text[i:le]...)
+		if le < len(text) {
+			res = append(res, '\n')
+		}
+		p = le + 1
+	}
+	return res
+}
+
+func Search(text []byte, what []byte, off int, forward bool) int {
+	if what == nil || len(what) == 0 {
+		return -1
+	}
+	if forward {
+		if off >= len(text) {
+			return -1
+		}
+		i := bytes.Index(text[off:], what)
+		if i >= 0 {
+			return off + i
+		}
+	} else {
+		off = min(len(text), off+len(what))
+		i := bytes.LastIndex(text[:off], what)
+		if i >= 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// Insert what into text at off. The text might be modified in place, what
+// never is.
+func Insert(text []byte, off int, what []byte) []byte {
+	// What might be a part of the text, which is about to move.
+	what = append([]byte(nil), what...)
+	text = append(text, what...)
+	copy(text[off+len(what):], text[off:len(text)-len(what)])
+	copy(text[off:], what)
+	return text
+}
+
+// Delete text between off and to. The text might be modified in place, the
+// deleted text is returned as a copy.
+func Delete(text []byte, off int, to int) ([]byte, []byte) {
+	if to >= len(text) {
+		c := append([]byte(nil), text[off:]...)
+		return text[:off], c
+	}
+	c := append([]byte(nil), text[off:to]...)
+	return append(text[:off], text[to:]...), c
+}
+
+// SearchRegexp returns the range of the first match of re starting at or after
+// off, or -1, -1. The search starts at the beginning of the line, so that ^
+// only matches at line starts, if re is compiled with the (?m) flag. Matches
+// that start before off are skipped, and the search resumes right after their
+// start, which is then treated as the beginning of the text.
+func SearchRegexp(text []byte, re *regexp.Regexp, off int) (int, int) {
+	if off > len(text) {
+		return -1, -1
+	}
+	for p := LineStart(text, off); p <= len(text); {
+		loc := re.FindIndex(text[p:])
+		if loc == nil {
+			break
+		}
+		if p+loc[0] >= off {
+			return p + loc[0], p + loc[1]
+		}
+		p += loc[0]
+		if p == len(text) {
+			break
+		}
+		_, s := utf8.DecodeRune(text[p:])
+		p += s
+	}
+	return -1, -1
+}
+
+// SearchRegexpBackward returns the range of the last match of re starting
+// before off, or -1, -1. Lines are searched backwards from the line of off,
+// each from its beginning, which makes ^ work like in SearchRegexp. Matches
+// can't continue past the end of the line of off.
+func SearchRegexpBackward(text []byte, re *regexp.Regexp, off int) (int, int) {
+	off = min(off, len(text))
+	limit := LineEnd(text, off)
+	for ls, hi := LineStart(text, off), off; ; ls, hi = LineStart(text, ls-1), ls {
+		start, end := -1, -1
+		for p := ls; p < hi; {
+			loc := re.FindIndex(text[p:limit])
+			if loc == nil || p+loc[0] >= hi {
+				break
+			}
+			start, end = p+loc[0], p+loc[1]
+			if start == limit {
+				break
+			}
+			// Matches don't overlap, like in a forward search.
+			p = end
+			if end == start {
+				_, s := utf8.DecodeRune(text[start:])
+				p += s
+			}
+		}
+		if start >= 0 {
+			return start, end
+		}
+		if ls == 0 {
+			return -1, -1
+		}
+	}
+}
+
+func MatchingBracket(text []byte, off int, left string, right string) (i int, ok bool) {
+	if off < 0 || off >= len(text) {
+		return
+	}
+	switch {
+	case bytes.HasPrefix(text[off:], []byte(left)):
+		for p, nest := off+len(left), 0; p < len(text); {
+			_, s := utf8.DecodeRune(text[p:])
+			switch {
+			case bytes.HasPrefix(text[p:], []byte(left)):
+				nest++
+			case bytes.HasPrefix(text[p:], []byte(right)):
+				if nest == 0 {
+					return p, true
+				}
+				nest--
+			}
+			p += s
+		}
+	case bytes.HasPrefix(text[off:], []byte(right)):
+		// off-1 might be in the middle of UTF-8 sequence, but that's ok in this case.
+		for p, nest := off-1, 0; p >= 0; {
+			_, s := utf8.DecodeLastRune(text[:p])
+			switch {
+			case bytes.HasPrefix(text[p:], []byte(right)):
+				nest++
+			case bytes.HasPrefix(text[p:], []byte(left)):
+				if nest == 0 {
+					return p, true
+				}
+				nest--
+			}
+			p -= s
+		}
+	}
+	return
+}
@@ -0,0 +1,176 @@
+package buffer
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// Text is the text of a buffer, kept in a gap buffer. The text before the gap
+// and the text after it share one array with free space in between, and edits
+// move the gap to where they are made. A series of edits close to each other,
+// like typing or replacing all matches in a part of the text, then only moves
+// the text between them, instead of all the text after every one of them.
+//
+// Edits don't read the rest of the text, and neither do Len, Slice, Column,
+// Newlines, LineStart and LineEnd. Bytes returns the whole text as a slice,
+// which closes the gap by moving it to the end. Slices returned by Bytes and
+// Slice are only valid until the next edit and must not be modified. Slices
+// of the text kept elsewhere, like clips and undo records, must be copies.
+//
+// The zero Text is empty.
+type Text struct {
+	buf []byte
+	// The gap is buf[gap:end].
+	gap, end int
+}
+
+// NewText returns a text with a copy of text.
+func NewText(text []byte) Text {
+	buf := append([]byte(nil), text...)
+	return Text{buf: buf, gap: len(buf), end: len(buf)}
+}
+
+// Len returns the length of the text in bytes.
+func (t *Text) Len() int {
+	return len(t.buf) - (t.end - t.gap)
+}
+
+// Move the gap to off.
+func (t *Text) moveGap(off int) {
+	if off < t.gap {
+		n := copy(t.buf[t.end-(t.gap-off):], t.buf[off:t.gap])
+		t.gap, t.end = off, t.end-n
+	} else if off > t.gap {
+		n := copy(t.buf[t.gap:], t.buf[t.end:t.end+(off-t.gap)])
+		t.gap, t.end = off, t.end+n
+	}
+}
+
+// Make room for at least n bytes in the gap. The gap grows with the text, so
+// that inserting takes amortized constant time per byte.
+func (t *Text) grow(n int) {
+	if t.end-t.gap >= n {
+		return
+	}
+	size := t.Len() + n
+	buf := make([]byte, size+size/4+64)
+	copy(buf, t.buf[:t.gap])
+	end := len(buf) - (len(t.buf) - t.end)
+	copy(buf[end:], t.buf[t.end:])
+	t.buf, t.end = buf, end
+}
+
+// Insert a copy of what at off.
+func (t *Text) Insert(off int, what []byte) {
+	if Ops.Check {
+		want := Ops.Insert(append([]byte(nil), t.Bytes()...), off, what)
+		defer t.check(want, "insert")
+	}
+	// What might be a part of the text, which is about to move.
+	what = append([]byte(nil), what...)
+	t.grow(len(what))
+	t.moveGap(off)
+	t.gap += copy(t.buf[t.gap:], what)
+}
+
+// Delete the range between off and to and return a copy of the deleted text.
+func (t *Text) Delete(off, to int) []byte {
+	if Ops.Check {
+		want, _ := Ops.Delete(append([]byte(nil), t.Bytes()...), off, to)
+		defer t.check(want, "delete")
+	}
+	to = min(to, t.Len())
+	t.moveGap(off)
+	what := append([]byte(nil), t.buf[t.end:t.end+(to-off)]...)
+	t.end += to - off
+	return what
+}
+
+func (t *Text) check(want []byte, op string) {
+	if !bytes.Equal(t.Bytes(), want) {
+		panic(op + ": wrong result")
+	}
+}
+
+// Bytes returns the whole text. The capacity of the slice is its length, so
+// appending to it never writes into the text.
+func (t *Text) Bytes() []byte {
+	t.moveGap(t.Len())
+	return t.buf[:t.gap:t.gap]
+}
+
+// Slice returns text[start:end]. If the range spans the gap, the gap moves to
+// the nearer end of the range, so only the range is read.
+func (t *Text) Slice(start, end int) []byte {
+	n := t.end - t.gap
+	switch {
+	case end <= t.gap:
+		return t.buf[start:end:end]
+	case start >= t.gap:
+		return t.buf[start+n : end+n : end+n]
+	case t.gap-start < end-t.gap:
+		t.moveGap(start)
+	default:
+		t.moveGap(end)
+	}
+	return t.Slice(start, end)
+}
+
+// Newlines returns the number of newlines between start and end.
+func (t *Text) Newlines(start, end int) int {
+	n := t.end - t.gap
+	if end <= t.gap {
+		return bytes.Count(t.buf[start:end], nl)
+	}
+	if start >= t.gap {
+		return bytes.Count(t.buf[start+n:end+n], nl)
+	}
+	return bytes.Count(t.buf[start:t.gap], nl) + bytes.Count(t.buf[t.end:end+n], nl)
+}
+
+// LineStart is like the function LineStart.
+func (t *Text) LineStart(off int) int {
+	if off > t.gap {
+		if i := bytes.LastIndexByte(t.buf[t.end:t.end+(off-t.gap)], '\n'); i >= 0 {
+			return t.gap + i + 1
+		}
+		off = t.gap
+	}
+	return LineStart(t.buf[:t.gap], off)
+}
+
+// LineEnd is like the function LineEnd.
+func (t *Text) LineEnd(off int) int {
+	if off < t.gap {
+		if i := bytes.IndexByte(t.buf[off:t.gap], '\n'); i >= 0 {
+			return off + i
+		}
+		off = t.gap
+	}
+	return t.gap + LineEnd(t.buf[t.end:], off-t.gap)
+}
+
+// Column returns the visual column of off, like Point.Column does. It only
+// reads the line chunk of off, see LineChunk.
+func (t *Text) Column(off, tabWidth int) int {
+	// The chunk starts at most MaxLineScan bytes before the chunk boundary
+	// preceding off. Starting at a boundary keeps the boundaries in place.
+	s := max(0, off-off%MaxLineScan-MaxLineScan)
+	p := Point{Off: off - s}
+	return p.Column(t.Slice(s, off), tabWidth)
+}
+
+// Return the range around start and end extended to whole words, reading only
+// as much of the text as needed.
+func (t *Text) wordRange(start, end int) (int, int) {
+	for n := 64; ; n *= 2 {
+		s, e := max(0, start-n), min(t.Len(), end+n)
+		w := t.Slice(s, e)
+		ws, we := wordStart(w, start-s), wordEnd(w, end-s)
+		// A rune cut by the edges of the slice isn't a word rune, so words
+		// must end far enough from them.
+		if (s == 0 || ws >= utf8.UTFMax) && (e == t.Len() || we <= len(w)-utf8.UTFMax) {
+			return s + ws, s + we
+		}
+	}
+}
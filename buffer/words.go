@@ -0,0 +1,90 @@
+package buffer
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// WordIndex counts the words of a text, so that words can be completed
+// without scanning the text. It is kept up to date by telling it about every
+// edit, which only rescans the words around the edited range.
+type WordIndex struct {
+	counts map[string]int
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func wordStart(text []byte, off int) int {
+	for off > 0 {
+		r, s := utf8.DecodeLastRune(text[:off])
+		if !isWordRune(r) {
+			break
+		}
+		off -= s
+	}
+	return off
+}
+
+func wordEnd(text []byte, off int) int {
+	for off < len(text) {
+		r, s := utf8.DecodeRune(text[off:])
+		if !isWordRune(r) {
+			break
+		}
+		off += s
+	}
+	return off
+}
+
+func NewWordIndex(text []byte) *WordIndex {
+	wi := &WordIndex{counts: make(map[string]int)}
+	wi.count(text, 1)
+	return wi
+}
+
+// Add inc to the counts of the words in text.
+func (wi *WordIndex) count(text []byte, inc int) {
+	for off := 0; off < len(text); {
+		end := wordEnd(text, off)
+		if end == off {
+			_, s := utf8.DecodeRune(text[off:])
+			off += s
+			continue
+		}
+		w := string(text[off:end])
+		if wi.counts[w] += inc; wi.counts[w] <= 0 {
+			delete(wi.counts, w)
+		}
+		off = end
+	}
+}
+
+// Call before text[start:end] is deleted, or text is inserted at start,
+// which equals end.
+func (wi *WordIndex) Before(text *Text, start, end int) {
+	wi.count(text.Slice(text.wordRange(start, end)), -1)
+}
+
+// Call after the edit, with the range of the inserted text, or an empty
+// range where the text was deleted.
+func (wi *WordIndex) After(text *Text, start, end int) {
+	wi.count(text.Slice(text.wordRange(start, end)), 1)
+}
+
+// Return words longer than prefix starting with it, the most frequent first.
+func (wi *WordIndex) Words(prefix string) (words []string) {
+	for w := range wi.counts {
+		if len(w) > len(prefix) && strings.HasPrefix(w, prefix) {
+			words = append(words, w)
+		}
+	}
+	sort.Slice(words, func(i, j int) bool {
+		ci, cj := wi.counts[words[i]], wi.counts[words[j]]
+		return ci > cj || ci == cj && words[i] < words[j]
+	})
+	return
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"github.com/jsynacek/med/buffer"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Saved Go buffers are checked with go build and go vet in the background, if
+// checkOnSave is set. The problems found are marked in all open buffers of the
+// package and become the locations, so they can be stepped through. The text
+// of a problem is shown once the point rests on its line.
+
+// Build and vet the package in dir, returning both outputs.
+func goCheck(dir string) ([]byte, error) {
+	build := exec.Command("go", "build", "-gcflags=-e", "-o", "/dev/null", ".")
+	build.Dir = dir
+	out, err := build.CombinedOutput()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return out, err
+		}
+	}
+	vet := exec.Command("go", "vet", ".")
+	vet.Dir = dir
+	vout, verr := vet.CombinedOutput()
+	if _, ok := verr.(*exec.ExitError); verr != nil && !ok {
+		return out, verr
+	}
+	return append(out, vout...), nil
+}
+
+// Parse problems reported by goCheck in dir. The same problem may be reported
+// by both commands.
+func parseDiagnostics(dir string, out []byte) (locs []Location) {
+	seen := make(map[Location]bool)
+	for _, loc := range parseLocations(dir, out) {
+		loc.path = filepath.Clean(loc.path)
+		if strings.HasSuffix(loc.path, ".go") && !seen[loc] {
+			seen[loc] = true
+			locs = append(locs, loc)
+		}
+	}
+	return
+}
+
+func (med *Med) checkGo(file *File) {
+	if med.jobRunning("go check") {
+		return
+	}
+	dir := file.dir()
+	done := func(out []byte, err error) {
+		if err != nil {
+			med.pushError(err)
+			return
+		}
+		locs := parseDiagnostics(dir, out)
+		med.markDiagnostics(locs, func(f *File) bool { return f.dir() == dir })
+		med.setLocations(locs)
+		if len(locs) == 0 {
+			med.showMessage("go check: ok")
+		} else {
+			med.showMessage("go check: %d problems", len(locs))
+		}
+	}
+	med.startJob("go check", func() ([]byte, error) { return goCheck(dir) }, done)
+}
+
+// Make locs the problems of the open files for which in returns true.
+func (med *Med) markDiagnostics(locs []Location, in func(f *File) bool) {
+	for e := med.files.Front(); e != nil; e = e.Next() {
+		f := e.Value.(*File)
+		if f.path == "" || !in(f) {
+			continue
+		}
+		f.diagnostics = nil
+		for _, loc := range locs {
+			if loc.path == absPath(f.path) {
+				f.diagnostics = append(f.diagnostics, loc)
+			}
+		}
+	}
+}
+
+// Lines with problems. The lines are as they were when the file was checked.
+func (file *File) diagnosticHighlights() (res []Highlight) {
+	for _, d := range file.diagnostics {
+		off := lineOffset(file.text.Bytes(), d.line, 1)
+		res = append(res, Highlight{off, buffer.LineEnd(file.text.Bytes(), off), theme["diagnostic"]})
+	}
+	return
+}
+
+// Show the problems on the line of the point.
+func diagnosticsTask(med *Med) {
+	file := med.file.Value.(*File)
+	if med.message != "" || len(file.diagnostics) == 0 {
+		return
+	}
+	var texts []string
+	for _, d := range file.diagnostics {
+		if d.line == file.point.Line+1 {
+			texts = append(texts, d.text)
+		}
+	}
+	if len(texts) > 0 {
+		med.showMessage("%s", strings.Join(texts, "; "))
+	}
+}
+
+// Check the package of the buffer now.
+func checkBuffer(med *Med, file *File) {
+	if fileType(file.path) != "go" {
+		med.pushError(errors.New("not a Go file"))
+		return
+	}
+	med.checkGo(file)
+}
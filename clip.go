@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// The clip remembers how its text was taken, so that pasting never depends
+// on state left over from an earlier copy or from another buffer.
+
+const (
+	ClipChars = iota // Pasted at the point.
+	ClipLines        // Whole lines, pasted above the line of the point.
+)
+
+type Clip struct {
+	text []byte
+	kind int
+}
+
+// Replace the clip. Every command that fills the clip goes through here.
+func (med *Med) setClip(text []byte, kind int) {
+	med.clip = Clip{text, kind}
+}
+
+// Kind of a clip taken from a selection of type sel.
+func clipKind(sel int) int {
+	if sel == LineSelection {
+		return ClipLines
+	}
+	return ClipChars
+}
+
+// Short indicator of the clip for the status line.
+func (clip Clip) String() string {
+	if clip.text == nil {
+		return ""
+	}
+	k := "c"
+	if clip.kind == ClipLines {
+		k = "l"
+	}
+	return fmt.Sprintf("%s:%d", k, utf8.RuneCount(clip.text))
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"container/list"
+	"testing"
+)
+
+func TestClipPasteLines(t *testing.T) {
+	defer func(r bool) { reindentPaste = r }(reindentPaste)
+	for _, reindent := range []bool{false, true} {
+		reindentPaste = reindent
+		med := &Med{
+			files:  list.New(),
+			errors: list.New(),
+			config: &Config{},
+		}
+		file := NewFile("test", "", []byte("aaa\nbbbb\n"))
+		med.file = med.files.PushBack(file)
+		// Copy the first line and paste it from the middle of the second.
+		for _, keys := range []string{"c", "k", "l", "l", "v"} {
+			med.handleKey([]byte(keys))
+		}
+		if got, want := string(file.text.Bytes()), "aaa\naaa\nbbbb\n"; got != want {
+			t.Errorf("reindentPaste %v: pasted %q, want %q", reindent, got, want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jsynacek/med/buffer"
+	"strconv"
+	"strings"
+)
+
+// Column editing treats every line a selection touches as a cursor placed in
+// the column the selection starts at. Line selections start in the first
+// column.
+
+// Offsets of the cursors from the column of start down to the line of end,
+// one per line. The column is visual, like the one the point keeps moving
+// down, so tabs and wide characters count for the cells they take. Lines
+// shorter than the column get their cursor at the line end.
+func columnCursors(text []byte, start, end, tabStop int) (offs []int) {
+	p := buffer.Point{Off: start}
+	p.Col = p.Column(text, tabStop)
+	last := buffer.LineStart(text, max(start, end))
+	for {
+		offs = append(offs, p.Off)
+		if le := buffer.LineEnd(text, p.Off); le >= last || le == len(text) {
+			return
+		}
+		p.Down(text, tabStop, true)
+	}
+}
+
+// Format the n-th number of a sequence. A start with leading zeros pads all
+// numbers to its width.
+func sequenceNumber(start string, first, step, n int) string {
+	num := fmt.Sprint(first + n*step)
+	if strings.HasPrefix(start, "0") && len(start) > len(num) {
+		num = strings.Repeat("0", len(start)-len(num)) + num
+	}
+	return num
+}
+
+// Insert an incrementing number at every cursor of the selection. The start
+// and the step are asked for, both default to 1.
+func insertSequence(med *Med, file *File) {
+	if med.mode != SelectionMode {
+		med.pushError(errors.New("no selection"))
+		return
+	}
+	off, end := med.selectionRange(file)
+	if med.selection.sel == LineSelection {
+		// Don't count the line after the trailing newline.
+		end--
+	}
+	offs := columnCursors(file.text.Bytes(), off, end, file.tabStop)
+	commandMode(med, file)
+	update := func() {}
+	finish := func(cancel bool) {
+		if cancel {
+			return
+		}
+		f := strings.Fields(string(med.dialog.file.text.Bytes()))
+		first, step := 1, 1
+		start := "1"
+		if len(f) > 0 {
+			start = f[0]
+			var err error
+			if first, err = strconv.Atoi(f[0]); err != nil {
+				med.pushError(fmt.Errorf("bad start: %s", f[0]))
+				return
+			}
+		}
+		if len(f) > 1 {
+			var err error
+			if step, err = strconv.Atoi(f[1]); err != nil {
+				med.pushError(fmt.Errorf("bad step: %s", f[1]))
+				return
+			}
+		}
+		// Insert from the end, so the offsets stay valid.
+		for i := len(offs) - 1; i >= 0; i-- {
+			file.Goto(offs[i])
+			file.Insert([]byte(sequenceNumber(start, first, step, i)))
+		}
+	}
+	med.startDialog("sequence (start step)", update, finish, Helm{})
+	med.dialog.file.Insert([]byte("1 1"))
+}
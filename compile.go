@@ -0,0 +1,56 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Compile runs buildCommand, like go build ./... or go vet ./..., as a job in
+// the project root, or the directory of the file if it's not in a project.
+// Its output streams into the compile buffer, which is shown next to the
+// active window. Once it's done, the errors in the output become the
+// locations, stepped through with nextLocation and prevLocation, and their
+// lines are marked in the open buffers like the problems found by
+// checkBuffer. The files are built as they are saved.
+
+func compile(med *Med, file *File) {
+	if med.jobRunning("compile") {
+		med.pushError(errors.New("already compiling"))
+		return
+	}
+	command := file.Option("buildCommand")
+	if command == "" {
+		med.pushError(errors.New("no buildCommand"))
+		return
+	}
+	dir := file.project
+	if dir == "" {
+		dir = file.dir()
+	}
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = dir
+	var e *list.Element
+	e = med.startStreamJob("compile", cmd, func(err error) {
+		var locs []Location
+		for _, loc := range parseLocations(dir, e.Value.(*File).text.Bytes()) {
+			loc.path = filepath.Clean(loc.path)
+			locs = append(locs, loc)
+		}
+		med.markDiagnostics(locs, func(f *File) bool {
+			return strings.HasPrefix(f.dir()+"/", dir+"/")
+		})
+		med.setLocations(locs)
+		switch {
+		case len(locs) > 0:
+			med.showMessage("compile: %d errors", len(locs))
+		case err != nil:
+			med.showMessage("compile: %v", err)
+		default:
+			med.showMessage("compile: ok")
+		}
+	})
+	med.showBuffer(e)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"github.com/jsynacek/med/buffer"
+	"sort"
+	"unicode/utf8"
+)
+
+// With autoComplete set, typing a word in editing mode pops up words starting
+// with it. The words near the point come first, followed by the other words
+// of the active buffer and then of the other buffers, taken from their word
+// indexes. Up and Down pick a word, Tab
+// completes it, and any other key closes the popup.
+
+type Completion struct {
+	// Start of the typed prefix.
+	start int
+	words []string
+	popup *Popup
+}
+
+// Minimum length of the typed prefix to pop up completions.
+const completeMinLength = 3
+
+// Maximum number of completions.
+const completeMax = 50
+
+// Bytes around the point searched for the nearest words.
+const completeNear = 2000
+
+// Start of the word ending at off.
+func wordStart(text []byte, off int) int {
+	for off > 0 {
+		r, s := utf8.DecodeLastRune(text[:off])
+		if !isIdentRune(r) {
+			break
+		}
+		off -= s
+	}
+	return off
+}
+
+// Return words of text starting with prefix near off, the closest first. Only
+// a few lines around off are looked at, the rest comes from the word index.
+// The word at off itself is skipped.
+func nearWords(text []byte, off int, prefix string) []string {
+	type near struct {
+		word string
+		dist int
+	}
+	var ws []near
+	from, to := max(0, off-completeNear), min(len(text), off+completeNear)
+	for p := wordStart(text, from); p < to; {
+		r, s := utf8.DecodeRune(text[p:])
+		if !isIdentRune(r) {
+			p += s
+			continue
+		}
+		_, end, _ := identAt(text, p)
+		if w := string(text[p:end]); len(w) > len(prefix) && w[:len(prefix)] == prefix && p != off-len(prefix) {
+			ws = append(ws, near{w, max(p-off, off-p)})
+		}
+		p = end
+	}
+	sort.SliceStable(ws, func(i, j int) bool { return ws[i].dist < ws[j].dist })
+	res := make([]string, len(ws))
+	for i, w := range ws {
+		res[i] = w.word
+	}
+	return res
+}
+
+// Word index of the file, built on first use and then kept up to date by
+// every edit.
+func (file *File) wordIndex() *buffer.WordIndex {
+	if file.words == nil {
+		file.words = buffer.NewWordIndex(file.text.Bytes())
+	}
+	return file.words
+}
+
+// Completions of the word before the point in file.
+func (med *Med) completions(file *File) (start int, words []string) {
+	start = wordStart(file.text.Bytes(), file.point.Off)
+	prefix := string(file.text.Slice(start, file.point.Off))
+	if utf8.RuneCountInString(prefix) < completeMinLength {
+		return start, nil
+	}
+	seen := make(map[string]bool)
+	add := func(ws []string) {
+		for _, w := range ws {
+			if !seen[w] && len(words) < completeMax {
+				seen[w] = true
+				words = append(words, w)
+			}
+		}
+	}
+	add(nearWords(file.text.Bytes(), file.point.Off, prefix))
+	// The word being typed is in the index too, but Words leaves out the
+	// prefix itself.
+	add(file.wordIndex().Words(prefix))
+	for e := med.files.Front(); e != nil; e = e.Next() {
+		if f := e.Value.(*File); f != file {
+			add(f.wordIndex().Words(prefix))
+		}
+	}
+	return
+}
+
+// Pop up completions of the word just typed.
+func (med *Med) popupCompletions(file *File) {
+	med.completion = nil
+	if !autoComplete || file.large {
+		return
+	}
+	start, words := med.completions(file)
+	if len(words) == 0 {
+		return
+	}
+	med.completion = &Completion{start: start, words: words, popup: NewPointPopup(file, words)}
+	med.popup = med.completion.popup
+}
+
+// Handle a key while the completions c are popped up. Returns false if the
+// key closes the popup and should be handled as usual.
+func (med *Med) completionKey(file *File, c *Completion, key string) bool {
+	switch key {
+	case kDown:
+		c.popup.Move(1)
+	case kUp:
+		c.popup.Move(-1)
+	case kTab:
+		w := c.words[max(0, c.popup.index)]
+		file.BeginUndoGroup()
+		file.Delete(c.start, file.point.Off)
+		file.Insert([]byte(w))
+		file.EndUndoGroup()
+		return true
+	default:
+		return false
+	}
+	med.completion, med.popup = c, c.popup
+	return true
+}
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Configuration lives in ~/.config/med (or $XDG_CONFIG_HOME/med) and consists
+// of two optional files.
+//
+// The config file sets options and binds keys, one per line:
+//
+//	tabStop 4
+//	bind command "tk" duplicateBelow
+//	bind command "ZZ" samCommand ",x/ +$/d"
+//
+// Arguments after the name of a command are passed to it. Commands that ask
+// for something, like samCommand or gotoLine, take it from the arguments
+// instead.
+//
+// It also sets buffer-local variables and hooks, see hooks.go, and trusts
+// projects to set commands, see project.go.
+//
+// The theme file overrides theme attributes. Colors are either palette names,
+// #rrggbb values or "-" for no color:
+//
+//	point base3 #268bd2
+//	comment - -
+//
+// Empty lines and lines starting with '#' are ignored in both files.
+// Both files are watched and reloaded whenever they change.
+
+// Options that can be set from the config file.
+var options = map[string]interface{}{
+	"tabStop":          &tabStop,
+	"keepVisualColumn": &keepVisualColumn,
+	"keepIndent":       &keepIndent,
+	"smartLineStart":   &smartLineStart,
+	"showVisuals":      &showVisuals,
+	"showSyntax":       &showSyntax,
+	"reindentPaste":    &reindentPaste,
+	"replaceLinewise":  &replaceLinewise,
+	"flashUndo":        &flashUndo,
+	"recenterUndo":     &recenterUndo,
+	"keepHistory":      &keepHistory,
+	"promptScratch":    &promptScratch,
+	"useMouse":         &useMouse,
+	"showWhichKey":     &showWhichKey,
+	"buildCommand":     &buildCommand,
+	"formatCommand":    &formatCommand,
+	"keepPositions":    &keepPositions,
+	"interpreter":      &interpreter,
+	"idleTime":         &idleTime,
+	"autoSave":         &autoSave,
+	"checkOnSave":      &checkOnSave,
+	"smartQuotes":      &smartQuotes,
+	"autoComplete":     &autoComplete,
+	"indentGuides":     &indentGuides,
+	"pageOverlap":      &pageOverlap,
+	"wheelLines":       &wheelLines,
+	"hyperlinks":       &hyperlinks,
+	"inline":           &inline,
+	"inlineRows":       &inlineRows,
+	"maxFps":           &maxFps,
+	"showFrameStats":   &showFrameStats,
+	"normalizeUnicode": &normalizeUnicode,
+	"undoBytes":        &undoBytes,
+	"timeFormats":      &timeFormats,
+	"previewSam":       &previewSam,
+	"autoFill":         &autoFill,
+	"fillColumn":       &fillColumn,
+	"largeFileSize":    &largeFileSize,
+	"largeLineLength":  &largeLineLength,
+}
+
+// Commands that can be bound to keys from the config file.
+var commands = map[string]func(*Med, *File){
+	"pointRight":          wMoveSelection(pointRight),
+	"pointLeft":           wMoveSelection(pointLeft),
+	"pointDown":           wMoveSelection(pointDown),
+	"pointUp":             wMoveSelection(pointUp),
+	"pointLineEnd":        wMoveSelection(pointLineEnd),
+	"pointLineStart":      wMoveSelection(pointLineStart),
+	"pointWordRight":      wMoveSelection(pointWordRight),
+	"pointWordLeft":       wMoveSelection(pointWordLeft),
+	"pointParagraphRight": wMoveSelection(pointParagraphRight),
+	"pointParagraphLeft":  wMoveSelection(pointParagraphLeft),
+	"pointTextStart":      wMoveSelection(pointTextStart),
+	"pointTextEnd":        wMoveSelection(pointTextEnd),
+	"pageDown":            wMoveSelection(pageDown),
+	"pageUp":              wMoveSelection(pageUp),
+	"halfPageDown":        wMoveSelection(halfPageDown),
+	"halfPageUp":          wMoveSelection(halfPageUp),
+	"scrollPageDown":      scrollPageDown,
+	"scrollPageUp":        scrollPageUp,
+	"gotoMatchingBracket": wMoveSelection(gotoMatchingBracket),
+	"searchForward":       searchForward,
+	"searchBackward":      searchBackward,
+	"searchNextForward":   wMoveSelection(searchNextForward),
+	"searchNextBackward":  wMoveSelection(searchNextBackward),
+	"searchCurrentWord":   searchCurrentWord,
+	"gotoLine":            gotoLine,
+	"gotoAddress":         gotoAddress,
+	"insertNewline":       insertNewline,
+	"backspace":           backspace,
+	"deleteChar":          deleteChar,
+	"undo":                undo,
+	"redo":                redo,
+	"openBelow":           openBelow,
+	"openAbove":           openAbove,
+	"duplicateBelow":      duplicateBelow,
+	"duplicateAbove":      duplicateAbove,
+	"changeLineEnd":       changeLineEnd,
+	"changeLineStart":     changeLineStart,
+	"changeLine":          changeLine,
+	"leaveMark":           leaveMark,
+	"gotoMark":            gotoMark,
+	"toggleBookmark":      toggleBookmark,
+	"nextBookmark":        nextBookmark,
+	"prevBookmark":        prevBookmark,
+	"goComment":           goComment,
+	"goUncomment":         goUncomment,
+	"goIndent":            goIndent,
+	"goUnindent":          goUnindent,
+	"godoc":               godoc,
+	"loadFile":            loadFile,
+	"saveFile":            saveFile,
+	"switchVisuals":       switchVisuals,
+	"switchSyntax":        switchSyntax,
+	"pointToViewTop":      pointToViewTop,
+	"pointToViewMiddle":   pointToViewMiddle,
+	"pointToViewBottom":   pointToViewBottom,
+	"viewToPointTop":      viewToPointTop,
+	"viewToPointMiddle":   viewToPointMiddle,
+	"viewToPointBottom":   viewToPointBottom,
+	"samCommand":          samCommand,
+	"samPreview":          samPreview,
+	"commandMode":         commandMode,
+	"editingMode":         editingMode,
+	"switchBuffer":        switchBuffer,
+	"closeBuffer":         closeBuffer,
+	"nextBuffer":          nextBuffer,
+	"prevBuffer":          prevBuffer,
+	"selectionMode":       selectionMode,
+	"selectionSwapEnd":    selectionSwapEnd,
+	"selectionSearch":     selectionSearch,
+	"selectionChange":     selectionChange,
+	"selectWord":          selectWord,
+	"selectString":        selectString,
+	"selectBlock":         selectBlock,
+	"selectLine":          selectLine,
+	"selectLineExclusive": selectLineExclusive,
+	"selectNextLine":      selectNextLine,
+	"selectPrevLine":      selectPrevLine,
+	"clipCopy":            clipCopy,
+	"clipPaste":           clipPaste,
+	"clipReplace":         clipReplace,
+	"clipCut":             clipCut,
+	"clipChange":          clipChange,
+	"exportToHTML":        exportToHTML,
+	"exportToANSI":        exportToANSI,
+	"pagerLineDown":       pagerLineDown,
+	"pagerLineUp":         pagerLineUp,
+	"pagerQuit":           pagerQuit,
+	"manPage":             manPage,
+	"saveAll":             saveAll,
+	"quit":                quit,
+	"scratchBuffer":       scratchBuffer,
+	"loadProjectFile":     loadProjectFile,
+	"stripTrailingSpace":  stripTrailingSpace,
+	"formatBuffer":        formatBuffer,
+	"helmResume":          helmResume,
+	"manNextSection":      manNextSection,
+	"manPrevSection":      manPrevSection,
+	"splitWindow":         splitWindow,
+	"closeWindow":         closeWindow,
+	"otherWindow":         otherWindow,
+	"balanceWindows":      balanceWindows,
+	"growWindow":          growWindow,
+	"shrinkWindow":        shrinkWindow,
+	"toggleStacking":      toggleStacking,
+	"rotateWindows":       rotateWindows,
+	"toggleFollow":        toggleFollow,
+	"toggleOutline":       toggleOutline,
+	"outlineJump":         outlineJump,
+	"grep":                grep,
+	"goTestAll":           goTestAll,
+	"goTestPackage":       goTestPackage,
+	"goTestFunc":          goTestFunc,
+	"nextLocation":        nextLocation,
+	"prevLocation":        prevLocation,
+	"listLocations":       listLocations,
+	"compile":             compile,
+	"runBuffer":           runBuffer,
+	"shellBuffer":         shellBuffer,
+	"renameWord":          renameWord,
+	"checkBuffer":         checkBuffer,
+	"highlightDuplicates": highlightDuplicates,
+	"insertSequence":      insertSequence,
+	"toggleSmartQuotes":   toggleSmartQuotes,
+	"toggleAbbrevs":       toggleAbbrevs,
+	"completeGoMember":    completeGoMember,
+	"completeGopls":       completeGopls,
+	"gotoDefinition":      gotoDefinition,
+	"listReferences":      listReferences,
+	"jumpBack":            jumpBack,
+	"saveSession":         saveSession,
+	"restoreSession":      restoreSession,
+	"diffBuffers":         diffBuffers,
+	"diffNext":            diffNext,
+	"diffPrev":            diffPrev,
+	"diffTakeLeft":        diffTakeLeft,
+	"diffTakeRight":       diffTakeRight,
+	"diffQuit":            diffQuit,
+	"historyBrowse":       historyBrowse,
+	"historyRestore":      historyRestore,
+
+	// Regular expression search.
+	"searchRegexpForward":  searchRegexpForward,
+	"searchRegexpBackward": searchRegexpBackward,
+	// Folding.
+	"foldSam":   foldSam,
+	"unfold":    unfold,
+	"unfoldAll": unfoldAll,
+	// Undo tree.
+	"undoBranchNext": undoBranchNext,
+	"undoBranchPrev": undoBranchPrev,
+	// Unicode.
+	"normalizeNFC":   normalizeNFC,
+	"stripInvisible": stripInvisible,
+	// Data.
+	"selectionStats": selectionStats,
+	// Text.
+	"insertTimestamp": insertTimestamp,
+	"insertDigraph":   insertDigraph,
+	"insertHeader":    insertHeader,
+	"updateHeader":    updateHeader,
+	// Rendering.
+	"toggleFrameStats": toggleFrameStats,
+	// Dialogs.
+	"dialogCancel":         dialogCancel,
+	"dialogFinish":         dialogFinish,
+	"dialogPointRight":     dialogPointRight,
+	"dialogPointLeft":      dialogPointLeft,
+	"dialogPointLineEnd":   dialogPointLineEnd,
+	"dialogPointLineStart": dialogPointLineStart,
+	"dialogDeleteChar":     wDialogUpdate(dialogDeleteChar),
+	"dialogBackspace":      wDialogUpdate(dialogBackspace),
+	"dialogClear":          wDialogUpdate(dialogClear),
+	"dialogHelmNext":       dialogHelmNext,
+	"dialogHelmPrev":       dialogHelmPrev,
+	"dialogTab":            dialogTab,
+	// Keys that only need to be caught.
+	"nothing": nothing,
+}
+
+// Join fields, the name of a command followed by its arguments, into a
+// command line for runCommand, quoting arguments where needed.
+func commandLine(fields []string) (string, error) {
+	if _, ok := commands[fields[0]]; !ok {
+		return "", fmt.Errorf("unknown command: %s", fields[0])
+	}
+	line := fields[0]
+	for _, f := range fields[1:] {
+		if q := strconv.Quote(f); f == "" || q[1:len(q)-1] != f || strings.ContainsAny(f, " \t") {
+			f = q
+		}
+		line += " " + f
+	}
+	return line, nil
+}
+
+// Mode names used by the bind directive.
+var modeNames = map[string]int{
+	"command":   CommandMode,
+	"editing":   EditingMode,
+	"selection": SelectionMode,
+	"dialog":    DialogMode,
+	"pager":     PagerMode,
+}
+
+func configDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "med")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".config", "med")
+}
+
+// Call fn for every significant line of the file at path, split into fields.
+// Fields may be quoted using Go syntax. A missing file is not an error.
+func readConfigLines(path string, fn func([]string) error) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields, err := splitConfigLine(line)
+		if err == nil {
+			err = fn(fields)
+		}
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+	}
+	return s.Err()
+}
+
+func splitConfigLine(line string) (fields []string, err error) {
+	for line != "" {
+		var f string
+		if line[0] == '"' {
+			f, err = strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, err
+			}
+			line = line[len(f):]
+			f, _ = strconv.Unquote(f)
+		} else {
+			i := strings.IndexAny(line, " \t")
+			if i < 0 {
+				i = len(line)
+			}
+			f, line = line[:i], line[i:]
+		}
+		fields = append(fields, f)
+		line = strings.TrimLeft(line, " \t")
+	}
+	return
+}
+
+func setOption(name, value string) (err error) {
+	switch o := options[name].(type) {
+	case *int:
+		*o, err = strconv.Atoi(value)
+	case *bool:
+		*o, err = strconv.ParseBool(value)
+	case *string:
+		*o = value
+	default:
+		err = fmt.Errorf("unknown option: %s", name)
+	}
+	return
+}
+
+func parseColor(s string) (*color.RGBA, error) {
+	if s == "-" {
+		return nil, nil
+	}
+	if c, ok := solarizedPalette[s]; ok {
+		return c, nil
+	}
+	var r, g, b uint8
+	if _, err := fmt.Sscanf(s, "#%02x%02x%02x", &r, &g, &b); err != nil {
+		return nil, fmt.Errorf("invalid color: %s", s)
+	}
+	return &color.RGBA{r, g, b, 0}, nil
+}
+
+// Config holds the state needed to reapply the configuration from scratch.
+type Config struct {
+	dir      string
+	mtimes   map[string]time.Time
+	defaults map[string]string
+	// Options as set by the config file when it was last loaded.
+	values   map[string]string
+	keymaps  map[int][]Keybind
+	hooks    []Hook
+	locals   []Local
+	abbrevs  map[string]string
+	digraphs map[string]string
+	// Project roots whose configs may set commands, see project.go.
+	trusted []string
+}
+
+func NewConfig(dir string) *Config {
+	c := &Config{
+		dir:      dir,
+		mtimes:   make(map[string]time.Time),
+		defaults: make(map[string]string),
+		values:   make(map[string]string),
+		keymaps:  make(map[int][]Keybind),
+	}
+	for name, o := range options {
+		switch o := o.(type) {
+		case *int:
+			c.defaults[name] = strconv.Itoa(*o)
+		case *bool:
+			c.defaults[name] = strconv.FormatBool(*o)
+		case *string:
+			c.defaults[name] = *o
+		}
+	}
+	for mode, keymap := range editorKeymaps {
+		c.keymaps[mode] = keymap
+	}
+	// Only later changes are reported.
+	c.changed()
+	return c
+}
+
+// Report whether any of the configuration files changed since the last call.
+func (c *Config) changed() bool {
+	changed := false
+	for _, name := range []string{"config", "theme"} {
+		path := filepath.Join(c.dir, name)
+		var mtime time.Time
+		if st, err := os.Stat(path); err == nil {
+			mtime = st.ModTime()
+		}
+		if !mtime.Equal(c.mtimes[path]) {
+			c.mtimes[path] = mtime
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Apply the configuration files, returning the names of options that changed.
+// Only options whose value in the config file changed since the last load are
+// set, or reset to their defaults if they were removed, so options changed
+// while editing stay as they are. Keymaps, hooks and the theme are rebuilt.
+func (c *Config) Load() (changed []string, err error) {
+	values := make(map[string]string)
+	keymaps := make(map[int][]Keybind)
+	for mode, keymap := range c.keymaps {
+		keymaps[mode] = keymap
+	}
+	c.hooks, c.locals, c.trusted = nil, nil, nil
+	c.abbrevs = make(map[string]string)
+	c.digraphs = make(map[string]string)
+	err = readConfigLines(filepath.Join(c.dir, "config"), func(f []string) error {
+		switch f[0] {
+		case "hook":
+			h, err := parseHook(f)
+			c.hooks = append(c.hooks, h)
+			return err
+		case "local":
+			l, err := parseLocal(f)
+			c.locals = append(c.locals, l)
+			return err
+		case "abbrev":
+			word, exp, err := parseAbbrev(f)
+			c.abbrevs[word] = exp
+			return err
+		case "digraph":
+			d, s, err := parseDigraph(f)
+			c.digraphs[d] = s
+			return err
+		case "trust":
+			if len(f) != 2 {
+				return fmt.Errorf("expected trust directory")
+			}
+			c.trusted = append(c.trusted, absPath(expandHome(f[1])))
+			return nil
+		}
+		if f[0] != "bind" {
+			if len(f) != 2 {
+				return fmt.Errorf("expected option and value")
+			}
+			values[f[0]] = f[1]
+			if old, ok := c.values[f[0]]; ok && old == f[1] {
+				return nil
+			}
+			changed = append(changed, f[0])
+			return setOption(f[0], f[1])
+		}
+		if len(f) < 4 {
+			return fmt.Errorf("expected bind mode keys command")
+		}
+		mode, ok := modeNames[f[1]]
+		if !ok {
+			return fmt.Errorf("unknown mode: %s", f[1])
+		}
+		command, err := commandLine(f[3:])
+		if err != nil {
+			return err
+		}
+		// User bindings go first, so they take precedence.
+		keymaps[mode] = joinKeybinds(Keybind{f[2], command}, keymaps[mode])
+		return nil
+	})
+	for name, value := range c.values {
+		if _, ok := values[name]; ok {
+			continue
+		}
+		// Options after an error weren't read, so they are kept.
+		if err != nil {
+			values[name] = value
+			continue
+		}
+		changed = append(changed, name)
+		setOption(name, c.defaults[name])
+	}
+	c.values = values
+	editorKeymaps = keymaps
+	if err != nil {
+		return changed, err
+	}
+	t := make(Theme)
+	for name, attr := range solarizedTheme {
+		t[name] = attr
+	}
+	err = readConfigLines(filepath.Join(c.dir, "theme"), func(f []string) error {
+		if len(f) != 3 {
+			return fmt.Errorf("expected name, foreground and background")
+		}
+		fg, err := parseColor(f[1])
+		if err != nil {
+			return err
+		}
+		bg, err := parseColor(f[2])
+		if err != nil {
+			return err
+		}
+		t[f[0]] = Attribute{fg, bg}
+		return nil
+	})
+	theme = t
+	return changed, err
+}
+
+// Reload the configuration. The watcher sends a ConfigChangedEvent whenever
+// one of the files changes.
+func (med *Med) reloadConfig() {
+	changed, err := med.config.Load()
+	if err != nil {
+		med.pushError(err)
+	}
+	visuals := false
+	for _, name := range changed {
+		visuals = visuals || name == "showVisuals"
+	}
+	for f := med.files.Front(); f != nil; f = f.Next() {
+		file := f.Value.(*File)
+		if visuals {
+			file.view.visual = NewVisual(showVisuals)
+		}
+		med.applyLocals(file)
+	}
+}
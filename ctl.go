@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/jsynacek/med/ctl"
+	"github.com/jsynacek/med/sam"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// A running editor can be driven by medctl, or anything else that can write
+// a line to a Unix socket, like scripts testing the editor:
+//
+//	medctl open main.go
+//	medctl sam ',x/Println/c/Printf/'
+//	medctl dot /func main/
+//	medctl insert 'hello, world
+//	'
+//	medctl save
+//
+// The first editor started listens on the socket, see package ctl for where
+// it is and what goes over it. Requests are handled in the main loop, one at
+// a time like keys, and work on the current buffer:
+//
+//	buffers         list the buffers, the current one marked with *, the
+//	                modified ones with +
+//	open PATH       make the buffer of the file current, loading it if needed
+//	text            print the text
+//	dot             print the dot as a sam address, like #10,#15
+//	dot ADDRESS     set the dot to a sam address
+//	sam COMMANDS    run sam commands and print what they print
+//	insert TEXT     insert TEXT at the point
+//	save            save the buffer
+
+// A request from the socket.
+type CtlEvent struct {
+	request string
+	reply   chan<- string
+}
+
+// Listen for requests on the socket, unless another editor does already.
+// Closing the listener removes the socket.
+func (med *Med) listenCtl() net.Listener {
+	path := ctl.SocketPath()
+	if c, err := net.Dial("unix", path); err == nil {
+		c.Close()
+		return nil
+	}
+	l, err := ctl.Listen(path)
+	if err != nil {
+		med.pushError(err)
+		return nil
+	}
+	go serveCtl(l, med.events)
+	return l
+}
+
+func serveCtl(l net.Listener, events chan<- Event) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer c.Close()
+			// Only the user running the editor may drive it.
+			if uid, err := ctl.PeerUID(c); err != nil || uid != os.Getuid() {
+				return
+			}
+			req, err := bufio.NewReader(c).ReadString('\n')
+			if err != nil && req == "" {
+				return
+			}
+			reply := make(chan string, 1)
+			events <- CtlEvent{strings.TrimSuffix(req, "\n"), reply}
+			io.WriteString(c, <-reply)
+		}()
+	}
+}
+
+func (med *Med) handleCtl(ev CtlEvent) {
+	out, err := med.ctlRequest(ev.request)
+	if err != nil {
+		ev.reply <- "error: " + err.Error() + "\n"
+		return
+	}
+	ev.reply <- "ok\n" + out
+}
+
+func (med *Med) ctlRequest(req string) (string, error) {
+	name, arg, err := ctl.ParseRequest(req)
+	if err != nil {
+		return "", err
+	}
+	file := med.file.Value.(*File)
+	switch name {
+	case "buffers":
+		var b strings.Builder
+		for e := med.files.Front(); e != nil; e = e.Next() {
+			f := e.Value.(*File)
+			cur, mod := ' ', ' '
+			if e == med.file {
+				cur = '*'
+			}
+			if f.modified {
+				mod = '+'
+			}
+			fmt.Fprintf(&b, "%c%c %s\n", cur, mod, f.name)
+		}
+		return b.String(), nil
+	case "open":
+		if arg == "" {
+			return "", errors.New("no file")
+		}
+		if e := med.findFile(absPath(arg)); e != nil {
+			med.file = e
+			return "", nil
+		}
+		f, err := LoadFile(arg)
+		if err != nil {
+			return "", err
+		}
+		med.addFile(f)
+		return "", nil
+	case "text":
+		return string(file.text.Bytes()), nil
+	case "dot":
+		if arg == "" {
+			dot := med.samDot(file, nil)
+			return fmt.Sprintf("#%d,#%d\n", dot.start, dot.end), nil
+		}
+		fallthrough
+	case "sam":
+		var p sam.Parser
+		p.Init([]byte(arg))
+		addr, cmdList, err := p.Parse()
+		if err != nil {
+			return "", err
+		}
+		if name == "dot" && len(cmdList) > 0 {
+			return "", errors.New("not an address: " + arg)
+		}
+		var out bytes.Buffer
+		med.ctlOutput = &out
+		err = med.samExecute(file, addr, cmdList)
+		med.ctlOutput = nil
+		file.UndoBlock()
+		return out.String(), err
+	case "insert":
+		if file.readOnly {
+			return "", errors.New("read-only buffer")
+		}
+		file.Insert([]byte(arg))
+		file.UndoBlock()
+		return "", nil
+	case "save":
+		return "", med.saveBuffer(file)
+	}
+	return "", errors.New("unknown request: " + name)
+}
@@ -0,0 +1,123 @@
+// Package ctl is what med and medctl share to talk over a Unix socket.
+//
+// A connection carries one request, a line with the name of the request and,
+// if it has an argument, a space and the argument as a Go string literal, so
+// it can hold any text, newlines included. The reply is "ok" or "error: " and
+// the message on the first line, followed by the output, up to the end of the
+// connection.
+//
+// The socket is $MED_SOCKET, or med.sock in $XDG_RUNTIME_DIR, or in
+// /tmp/med-UID if that isn't set. Requests can make the editor run shell
+// commands, so only the user running the editor may connect: the socket is
+// made readable and writable only by them, the directory in /tmp has to be
+// theirs and closed to others, and the user at the other end of every
+// connection is checked.
+package ctl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Path of the socket.
+func SocketPath() string {
+	if path := os.Getenv("MED_SOCKET"); path != "" {
+		return path
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "med.sock")
+	}
+	return filepath.Join(tempDir(), "med.sock")
+}
+
+func tempDir() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("med-%d", os.Getuid()))
+}
+
+// Check that dir is a directory of the user closed to everyone else.
+func privateDir(dir string) error {
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !fi.IsDir() || !ok || int(st.Uid) != os.Getuid() || fi.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%s is not a private directory", dir)
+	}
+	return nil
+}
+
+// Listen on the socket at path, replacing a socket left behind.
+func Listen(path string) (net.Listener, error) {
+	if dir := filepath.Dir(path); dir == tempDir() {
+		if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+			return nil, err
+		}
+		if err := privateDir(dir); err != nil {
+			return nil, err
+		}
+	}
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Return the user ID of the process at the other end of c.
+func PeerUID(c net.Conn) (int, error) {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return -1, errors.New("not a Unix socket")
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	var cred *syscall.Ucred
+	var cerr error
+	err = raw.Control(func(fd uintptr) {
+		cred, cerr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return -1, err
+	}
+	return int(cred.Uid), nil
+}
+
+// Request returns the line of the request name with the argument arg, without
+// the newline. An empty argument is left out.
+func Request(name, arg string) string {
+	if arg == "" {
+		return name
+	}
+	return name + " " + strconv.Quote(arg)
+}
+
+// ParseRequest returns the name and the argument of the request line, without
+// the newline.
+func ParseRequest(line string) (name, arg string, err error) {
+	i := strings.IndexByte(line, ' ')
+	if i < 0 {
+		return line, "", nil
+	}
+	arg, err = strconv.Unquote(line[i+1:])
+	if err != nil {
+		return "", "", fmt.Errorf("bad argument: %s", line[i+1:])
+	}
+	return line[:i], arg, nil
+}
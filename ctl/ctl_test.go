@@ -0,0 +1,80 @@
+package ctl
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRequest(t *testing.T) {
+	tests := []struct {
+		name, arg string
+	}{
+		{"text", ""},
+		{"sam", ",x/foo/c/bar/"},
+		{"sam", ",x/a/ {\n\tc/b/\n\ti/c/\n}\n"},
+		{"insert", `a "quoted" \n and a backslash \`},
+		{"insert", "line one\nline two\r\n\ttab"},
+		{"open", "a file with spaces and % signs"},
+	}
+	for _, test := range tests {
+		line := Request(test.name, test.arg)
+		if strings.Contains(line, "\n") {
+			t.Errorf("Request(%q, %q) = %q spans lines", test.name, test.arg, line)
+		}
+		name, arg, err := ParseRequest(line)
+		if err != nil || name != test.name || arg != test.arg {
+			t.Errorf("ParseRequest(%q) = %q, %q, %v, want %q, %q", line, name, arg, err, test.name, test.arg)
+		}
+	}
+	if _, _, err := ParseRequest("sam ,x/unquoted/"); err == nil {
+		t.Errorf("ParseRequest accepted an unquoted argument")
+	}
+}
+
+func TestListen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "med.sock")
+	l, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("socket permissions %o, want 600", perm)
+	}
+	go func() {
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if uid, err := PeerUID(c); err != nil || uid != os.Getuid() {
+		t.Errorf("PeerUID = %d, %v, want %d", uid, err, os.Getuid())
+	}
+}
+
+func TestPrivateDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := privateDir(dir); err != nil {
+		t.Errorf("privateDir(0700) = %v", err)
+	}
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := privateDir(dir); err == nil {
+		t.Errorf("privateDir(0755) accepted a directory open to others")
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"github.com/jsynacek/med/buffer"
+	"strings"
+)
+
+// Split text into lines, keeping the newlines.
+func textLines(text []byte) [][]byte {
+	lines := bytes.SplitAfter(text, NL)
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Offsets of line starts in text. The returned slice has one more element
+// than there are lines, equal to len(text).
+func lineOffsets(lines [][]byte) []int {
+	offs := make([]int, len(lines)+1)
+	for i, l := range lines {
+		offs[i+1] = offs[i] + len(l)
+	}
+	return offs
+}
+
+// Diff of two buffers displayed side by side.
+type Diff struct {
+	a, b  *list.Element
+	hunks []buffer.Hunk
+	// Line offsets of both buffers.
+	aoffs, boffs []int
+}
+
+func (d *Diff) update() {
+	alines := textLines(d.a.Value.(*File).text.Bytes())
+	blines := textLines(d.b.Value.(*File).text.Bytes())
+	d.hunks = buffer.DiffLines(alines, blines)
+	d.aoffs, d.boffs = lineOffsets(alines), lineOffsets(blines)
+}
+
+func (d *Diff) shows(file *File) bool {
+	return d.a.Value.(*File) == file || d.b.Value.(*File) == file
+}
+
+func (d *Diff) highlights(file *File) (res []Highlight) {
+	for _, h := range d.hunks {
+		if d.a.Value.(*File) == file && h.A0 < h.A1 {
+			attr := theme["diffChanged"]
+			if h.B0 == h.B1 {
+				attr = theme["diffRemoved"]
+			}
+			res = append(res, Highlight{d.aoffs[h.A0], d.aoffs[h.A1], attr})
+		} else if d.b.Value.(*File) == file && h.B0 < h.B1 {
+			attr := theme["diffChanged"]
+			if h.A0 == h.A1 {
+				attr = theme["diffAdded"]
+			}
+			res = append(res, Highlight{d.boffs[h.B0], d.boffs[h.B1], attr})
+		}
+	}
+	return
+}
+
+// A side of the diff, as seen from one of the buffers.
+type diffSide struct {
+	file  *File
+	offs  []int
+	start func(buffer.Hunk) int
+	end   func(buffer.Hunk) int
+}
+
+func (d *Diff) sides(file *File) (this, other diffSide, ok bool) {
+	a := diffSide{d.a.Value.(*File), d.aoffs, func(h buffer.Hunk) int { return h.A0 }, func(h buffer.Hunk) int { return h.A1 }}
+	b := diffSide{d.b.Value.(*File), d.boffs, func(h buffer.Hunk) int { return h.B0 }, func(h buffer.Hunk) int { return h.B1 }}
+	switch file {
+	case a.file:
+		return a, b, true
+	case b.file:
+		return b, a, true
+	}
+	return
+}
+
+// Move points of both buffers to hunk h and align it in both views.
+func (d *Diff) gotoHunk(this, other diffSide, h buffer.Hunk) {
+	row := this.file.view.height / 3
+	for _, s := range []diffSide{this, other} {
+		s.file.Goto(s.offs[s.start(h)])
+		s.file.view.ToPoint(s.file.text.Bytes(), s.file.point.Off, row)
+	}
+}
+
+// Return the hunk at line in the given side.
+func (d *Diff) hunkAt(side diffSide, line int) (buffer.Hunk, bool) {
+	for _, h := range d.hunks {
+		if side.start(h) <= line && line < side.end(h) || side.start(h) == line {
+			return h, true
+		}
+	}
+	return buffer.Hunk{}, false
+}
+
+func diffBuffers(med *Med, file *File) {
+	update := func() {}
+	finish := func(cancel bool) {
+		if cancel {
+			return
+		}
+		name := string(med.dialog.file.text.Bytes())
+		for f := med.files.Front(); f != nil; f = f.Next() {
+			if f != med.file && f.Value.(*File).name == name {
+				med.diff = &Diff{a: med.file, b: f}
+				med.windows = []*list.Element{med.file, f}
+				med.window = 0
+				med.diff.update()
+				return
+			}
+		}
+		med.pushError(errors.New("buffer not found: " + name))
+	}
+	complete := func() {
+		var data []string
+		for f := med.files.Front(); f != nil; f = f.Next() {
+			name := f.Value.(*File).name
+			if f != med.file && strings.Contains(name, string(med.dialog.file.text.Bytes())) {
+				data = append(data, name)
+			}
+		}
+		med.dialog.helm.data = data
+	}
+	med.startDialog("diff with", update, finish, NewHelm(complete))
+}
+
+func (med *Med) diffMove(file *File, forward bool) {
+	if med.diff == nil {
+		return
+	}
+	d := med.diff
+	d.update()
+	this, other, ok := d.sides(file)
+	if !ok {
+		return
+	}
+	line := file.point.Line
+	if forward {
+		for _, h := range d.hunks {
+			if this.start(h) > line {
+				d.gotoHunk(this, other, h)
+				return
+			}
+		}
+	} else {
+		for i := len(d.hunks) - 1; i >= 0; i-- {
+			if this.start(d.hunks[i]) < line {
+				d.gotoHunk(this, other, d.hunks[i])
+				return
+			}
+		}
+	}
+}
+
+func diffNext(med *Med, file *File) {
+	med.diffMove(file, true)
+}
+func diffPrev(med *Med, file *File) {
+	med.diffMove(file, false)
+}
+
+// Replace the hunk at the point in one buffer by its version from the other one.
+func (med *Med) diffTake(file *File, left bool) {
+	if med.diff == nil {
+		return
+	}
+	d := med.diff
+	d.update()
+	this, other, ok := d.sides(file)
+	if !ok {
+		return
+	}
+	h, ok := d.hunkAt(this, file.point.Line)
+	if !ok {
+		med.pushError(errors.New("no difference at point"))
+		return
+	}
+	src, dst := this, other
+	if (d.a.Value.(*File) == file) != left {
+		src, dst = other, this
+	}
+	what := append([]byte(nil), src.file.text.Bytes()[src.offs[src.start(h)]:src.offs[src.end(h)]]...)
+	off := dst.offs[dst.start(h)]
+	dst.file.Delete(off, dst.offs[dst.end(h)])
+	dst.file.Goto(off)
+	dst.file.Insert(what)
+	dst.file.UndoBlock()
+	d.update()
+}
+
+// Take the left version of the difference at the point.
+func diffTakeLeft(med *Med, file *File) {
+	med.diffTake(file, true)
+}
+
+// Take the right version of the difference at the point.
+func diffTakeRight(med *Med, file *File) {
+	med.diffTake(file, false)
+}
+
+func diffQuit(med *Med, file *File) {
+	med.diff = nil
+}
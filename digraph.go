@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/text/unicode/norm"
+	"unicode/utf8"
+)
+
+// In editing mode, C-k followed by two characters inserts the character they
+// stand for, like a ' for á or - > for →. Letters take accents from the
+// second character, which may also come first:
+//
+//	'  acute       !  grave       >  circumflex  :  diaeresis
+//	?  tilde       ,  cedilla     <  caron       -  macron
+//	(  breve       .  dot above   0  ring        "  double acute
+//	;  ogonek
+//
+// Other digraphs follow RFC 1345. More of them are defined in the config
+// file, where they also override the built-in ones:
+//
+//	digraph ok ✓
+
+// Combining marks by the character that adds them.
+var digraphAccents = map[rune]rune{
+	'\'': '\u0301',
+	'!':  '\u0300',
+	'>':  '\u0302',
+	':':  '\u0308',
+	'?':  '\u0303',
+	',':  '\u0327',
+	'<':  '\u030c',
+	'-':  '\u0304',
+	'(':  '\u0306',
+	'.':  '\u0307',
+	'0':  '\u030a',
+	'"':  '\u030b',
+	';':  '\u0328',
+}
+
+var digraphs = map[string]string{
+	"->": "→", "<-": "←", "-!": "↑", "-v": "↓", "<>": "↔", "=>": "⇒", "==": "⇔",
+	"!=": "≠", "=<": "≤", ">=": "≥", "?2": "≈", "+-": "±", "*X": "×", "-:": "÷",
+	"00": "∞", "FA": "∀", "TE": "∃", "(-": "∈", "OK": "✓", "XX": "✗",
+	"<<": "«", ">>": "»", "'6": "‘", "'9": "’", "\"6": "“", "\"9": "”",
+	"-N": "–", "-M": "—", ".M": "·", "Sb": "∙", ",.": "…",
+	"ss": "ß", "ae": "æ", "AE": "Æ", "o/": "ø", "O/": "Ø", "oe": "œ", "OE": "Œ",
+	"DG": "°", "Co": "©", "Rg": "®", "TM": "™", "SE": "§", "PI": "¶",
+	"Eu": "€", "Pd": "£", "Ye": "¥", "Ct": "¢",
+	"a*": "α", "b*": "β", "g*": "γ", "d*": "δ", "e*": "ε", "l*": "λ", "m*": "μ",
+	"p*": "π", "s*": "σ", "t*": "τ", "f*": "φ", "w*": "ω", "D*": "Δ", "S*": "Σ", "W*": "Ω",
+	"12": "½", "14": "¼", "34": "¾", "1S": "¹", "2S": "²", "3S": "³",
+}
+
+func parseDigraph(f []string) (string, string, error) {
+	if len(f) != 3 || utf8.RuneCountInString(f[1]) != 2 {
+		return "", "", fmt.Errorf("expected digraph chars character")
+	}
+	return f[1], f[2], nil
+}
+
+// Return the character digraph d stands for.
+func (med *Med) digraph(d string) (string, bool) {
+	if s, ok := med.config.digraphs[d]; ok {
+		return s, true
+	}
+	if s, ok := digraphs[d]; ok {
+		return s, true
+	}
+	r := []rune(d)
+	for _, p := range [][2]rune{{r[0], r[1]}, {r[1], r[0]}} {
+		if mark, ok := digraphAccents[p[1]]; ok {
+			// Only letters with a precomposed form count.
+			s := norm.NFC.String(string([]rune{p[0], mark}))
+			if utf8.RuneCountInString(s) == 1 {
+				return s, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Read a digraph and insert the character it stands for.
+func insertDigraph(med *Med, file *File) {
+	update := func() {
+		if utf8.RuneCount(med.dialog.file.text.Bytes()) == 2 {
+			med.dialog.finish(false)
+		}
+	}
+	finish := func(cancel bool) {
+		med.mode = EditingMode
+		d := string(med.dialog.file.text.Bytes())
+		if cancel || utf8.RuneCountInString(d) != 2 {
+			return
+		}
+		if s, ok := med.digraph(d); ok {
+			file.Insert([]byte(s))
+		} else {
+			med.showMessage("unknown digraph %s", d)
+		}
+	}
+	med.startDialog("digraph", update, finish, Helm{})
+}
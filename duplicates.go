@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"github.com/jsynacek/med/buffer"
+)
+
+// Return ranges of lines whose text, without leading and trailing whitespace,
+// appears on more than one line. Blank lines are not duplicates.
+func duplicateLines(text []byte) (res []Highlight) {
+	type line struct{ start, end int }
+	lines := make(map[string][]line)
+	var keys []string
+	for off := 0; off <= len(text); off = buffer.LineEnd(text, off) + 1 {
+		end := buffer.LineEnd(text, off)
+		key := string(bytes.TrimSpace(text[off:end]))
+		if key != "" {
+			if lines[key] == nil {
+				keys = append(keys, key)
+			}
+			lines[key] = append(lines[key], line{off, end})
+		}
+	}
+	for _, key := range keys {
+		if ls := lines[key]; len(ls) > 1 {
+			for _, l := range ls {
+				res = append(res, Highlight{l.start, l.end, theme["duplicate"]})
+			}
+		}
+	}
+	return
+}
+
+// Toggle highlighting of duplicate lines in the buffer.
+func highlightDuplicates(med *Med, file *File) {
+	file.duplicates = !file.duplicates
+	if file.duplicates {
+		med.showMessage("%d duplicate lines", len(duplicateLines(file.text.Bytes())))
+	}
+}
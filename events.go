@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The main loop waits for events from several sources and dispatches them
+// one at a time, redrawing the screen after each. Everything that touches the
+// editor state happens in the main loop, the sources only send events.
+//
+// Besides the types below, finished jobs (*Job), output of streaming jobs
+// (JobOutput) and requests from the socket (CtlEvent) are events too.
+
+type Event interface{}
+
+// Keys read from the terminal. Nil means the input was closed.
+type KeyEvent []byte
+
+// The terminal was resized.
+type ResizeEvent struct{}
+
+// A watched file was modified by someone else.
+type FileChangedEvent struct {
+	path string
+}
+
+// The config or theme file changed, see reloadConfig.
+type ConfigChangedEvent struct{}
+
+// The time to flash text is over, see flashRange.
+type FlashEndEvent struct {
+	flash *Dot
+}
+
+// A message to show, see showMessage.
+type MessageEvent string
+
+// No key was pressed for idleTime milliseconds.
+type IdleEvent struct{}
+
+// The terminal gained or lost focus.
+type FocusEvent struct {
+	in bool
+}
+
+// Read keys from the terminal.
+func readKeys(events chan<- Event) {
+	for {
+		// Enough for keys and mouse reports.
+		b := make([]byte, 32)
+		n, err := os.Stdin.Read(b)
+		if err != nil {
+			events <- KeyEvent(nil)
+			return
+		}
+		switch string(b[:n]) {
+		case kFocusIn:
+			events <- FocusEvent{true}
+		case kFocusOut:
+			events <- FocusEvent{false}
+		default:
+			events <- KeyEvent(b[:n])
+		}
+	}
+}
+
+func watchResize(events chan<- Event) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGWINCH)
+	for range c {
+		events <- ResizeEvent{}
+	}
+}
+
+// A fileWatcher polls modification times of files, unless it's paused.
+type fileWatcher struct {
+	mu     sync.Mutex
+	times  map[string]time.Time
+	paused bool
+}
+
+func newFileWatcher() *fileWatcher {
+	return &fileWatcher{times: make(map[string]time.Time)}
+}
+
+// Start watching the file at path, or note its current modification time
+// if it's already watched.
+func (w *fileWatcher) watch(path string) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	w.mu.Lock()
+	w.times[absPath(path)] = fi.ModTime()
+	w.mu.Unlock()
+}
+
+func (w *fileWatcher) unwatch(path string) {
+	w.mu.Lock()
+	delete(w.times, absPath(path))
+	w.mu.Unlock()
+}
+
+func (w *fileWatcher) pause(paused bool) {
+	w.mu.Lock()
+	w.paused = paused
+	w.mu.Unlock()
+}
+
+// Return events for the watched files that changed since the last poll.
+func (w *fileWatcher) poll() (changed []Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for path, t := range w.times {
+		fi, err := os.Stat(path)
+		switch {
+		case os.IsNotExist(err):
+			delete(w.times, path)
+			changed = append(changed, MessageEvent(relPath(path)+" was removed"))
+		case err == nil && !fi.ModTime().Equal(t):
+			w.times[path] = fi.ModTime()
+			changed = append(changed, FileChangedEvent{path})
+		}
+	}
+	return
+}
+
+// Send events for changed files, including the configuration files, every
+// second.
+func (w *fileWatcher) run(events chan<- Event, config *Config) {
+	for range time.Tick(time.Second) {
+		w.mu.Lock()
+		paused := w.paused
+		w.mu.Unlock()
+		if paused {
+			continue
+		}
+		for _, ev := range w.poll() {
+			events <- ev
+		}
+		if config.changed() {
+			events <- ConfigChangedEvent{}
+		}
+	}
+}
+
+// Background work pauses while the terminal is not focused. Once it's focused
+// again, files changed in the meantime are checked right away.
+func (med *Med) focusChanged(in bool) {
+	med.unfocused = !in
+	med.watcher.pause(!in)
+	if in {
+		for _, ev := range med.watcher.poll() {
+			med.dispatch(ev)
+		}
+	}
+}
+
+// Reload a buffer whose file changed on disk, unless it has unsaved changes.
+func (med *Med) fileChanged(path string) {
+	e := med.findFile(path)
+	if e == nil {
+		return
+	}
+	file := e.Value.(*File)
+	if file.modified {
+		med.showMessage("%s changed on disk", file.name)
+		return
+	}
+	text, err := ioutil.ReadFile(path)
+	if err != nil || bytes.Equal(text, file.text.Bytes()) {
+		return
+	}
+	readOnly, start := file.readOnly, file.view.start
+	file.readOnly = false
+	file.ReplaceRange(0, file.text.Len(), text)
+	file.UndoBlock()
+	file.checkLarge()
+	file.readOnly, file.modified = readOnly, false
+	file.view.start = min(start, file.text.Len())
+	med.showMessage("reloaded %s", file.name)
+}
+
+func (med *Med) dispatch(ev Event) {
+	switch ev := ev.(type) {
+	case KeyEvent:
+		if ev == nil {
+			med.quit = true
+			return
+		}
+		med.keyPressed()
+		med.handleKey(ev)
+	case ResizeEvent:
+		// Nothing to do, the screen is redrawn after every event.
+	case FileChangedEvent:
+		med.fileChanged(ev.path)
+	case ConfigChangedEvent:
+		med.reloadConfig()
+	case FlashEndEvent:
+		// Unless something else flashes by now.
+		if med.flash == ev.flash {
+			med.flash = nil
+		}
+	case MessageEvent:
+		med.showMessage("%s", ev)
+	case CtlEvent:
+		med.handleCtl(ev)
+	case IdleEvent:
+		med.runIdleTasks()
+	case FocusEvent:
+		med.focusChanged(ev.in)
+	case *Job:
+		med.finishJob(ev)
+	case JobOutput:
+		med.appendOutput(ev)
+	}
+}
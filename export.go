@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"image/color"
+)
+
+// Split text between start and end into segments with the same attribute
+// and call fn for each of them. Highlights must be sorted and must not overlap.
+func exportSegments(text []byte, start, end int, highlights []Highlight, fn func([]byte, Attribute)) {
+	p := start
+	for _, hi := range highlights {
+		if hi.end <= p || hi.start == hi.end {
+			continue
+		}
+		if hi.start >= end {
+			break
+		}
+		if hi.start > p {
+			fn(text[p:hi.start], theme["normal"])
+			p = hi.start
+		}
+		e := min(end, hi.end)
+		fn(text[p:e], hi.attr)
+		p = e
+	}
+	if p < end {
+		fn(text[p:end], theme["normal"])
+	}
+}
+
+func exportHighlights(file *File, start, end int) []Highlight {
+	if !showSyntax {
+		return nil
+	}
+	return file.syntax(start, bytes.Count(file.text.Slice(start, end), NL)+1)
+}
+
+func cssColor(c *color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// Render text of file between start and end into a standalone HTML document.
+func exportHTML(file *File, start, end int) []byte {
+	text := file.text.Bytes()
+	var b bytes.Buffer
+	normal := theme["normal"]
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n</head>\n")
+	// Without a color in the theme, the one of the browser is used.
+	var body string
+	if normal.fg != nil {
+		body += "color: " + cssColor(normal.fg) + ";"
+	}
+	if normal.bg != nil {
+		body += "background: " + cssColor(normal.bg) + ";"
+	}
+	fmt.Fprintf(&b, "<body style=\"%s\">\n<pre>", body)
+	exportSegments(text, start, end, exportHighlights(file, start, end), func(seg []byte, attr Attribute) {
+		var style string
+		if attr.fg != nil && attr.fg != normal.fg {
+			style += "color: " + cssColor(attr.fg) + ";"
+		}
+		if attr.bg != nil && attr.bg != normal.bg {
+			style += "background: " + cssColor(attr.bg) + ";"
+		}
+		if style == "" {
+			b.WriteString(html.EscapeString(string(seg)))
+		} else {
+			fmt.Fprintf(&b, "<span style=\"%s\">%s</span>", style, html.EscapeString(string(seg)))
+		}
+	})
+	b.WriteString("</pre>\n</body>\n</html>\n")
+	return b.Bytes()
+}
+
+// Render text of file between start and end using ANSI escape sequences.
+func exportANSI(file *File, start, end int) []byte {
+	text := file.text.Bytes()
+	var b bytes.Buffer
+	out := func(attr Attribute) {
+		if attr.fg != nil {
+			fmt.Fprintf(&b, "\033[38;2;%d;%d;%dm", attr.fg.R, attr.fg.G, attr.fg.B)
+		}
+		if attr.bg != nil {
+			fmt.Fprintf(&b, "\033[48;2;%d;%d;%dm", attr.bg.R, attr.bg.G, attr.bg.B)
+		}
+	}
+	exportSegments(text, start, end, exportHighlights(file, start, end), func(seg []byte, attr Attribute) {
+		out(theme["normal"])
+		out(attr)
+		// Reset attributes before newlines, so the background doesn't spill
+		// to the end of the line in the terminal.
+		for len(seg) > 0 {
+			i := bytes.IndexByte(seg, '\n')
+			if i < 0 {
+				b.Write(seg)
+				break
+			}
+			b.Write(seg[:i])
+			b.WriteString("\033[0m\n")
+			out(theme["normal"])
+			out(attr)
+			seg = seg[i+1:]
+		}
+	})
+	b.WriteString("\033[0m")
+	return b.Bytes()
+}
+
+func (med *Med) export(file *File, ext string, render func(*File, int, int) []byte) {
+	start, end := 0, file.text.Len()
+	if med.mode == SelectionMode {
+		start, end = med.selectionRange(file)
+		commandMode(med, file)
+	}
+	name := file.name
+	if name == "" {
+		name = "export"
+	}
+	med.NewScratchBuffer(name+ext, render(file, start, end))
+}
+
+func exportToHTML(med *Med, file *File) {
+	med.export(file, ".html", exportHTML)
+}
+
+func exportToANSI(med *Med, file *File) {
+	med.export(file, ".ansi", exportANSI)
+}
@@ -2,50 +2,57 @@ package main
 
 import (
 	"bytes"
-	"container/list"
+	"errors"
+	"fmt"
+	"github.com/jsynacek/med/buffer"
 	"github.com/jsynacek/med/sam"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"regexp"
+	"sort"
 	"strconv"
 	"unicode"
 	"unicode/utf8"
 )
 
-// Undo record.
-//
-// After every insert/delete operation, an undo record is pushed onto the
-// undo stack. If undo is called, the undo record is applied, then removed
-// from the undo stack and pushed onto the redo stack. Then, it can be re-done
-// with the redo operation. The redo stack is only valid until next insert/delete,
-// which will clear it.
-//
-// When creating one, first the point should be moved, then the point offset
-// saved, then the operation performed and inserted/deleted text copied.
-//
-// Currently, undo records are created for every insert/delete operation, which
-// will probably result in clogging of the memory over time. Let's leave it
-// unrestricted and see, if it's going to be a real problem.
-type Undo struct {
-	// Offset of the change. It is always at the beginning of the change.
-	off int
-	// Copy of the changed text.
-	text []byte
-	// True if text was inserted during the change, false if deleted.
-	isInsert bool
-}
-
 // File represents a real file loaded into memory.
 type File struct {
 	name     string
 	path     string
 	modified bool
-	point    Point
+	readOnly bool
+	scratch  bool // Not backed by a file.
+	pager    bool // Shown in the pager mode, like a man page.
+	point    buffer.Point
 	view     View
-	undos    *list.List
-	redos    *list.List
-	mark     Point
-	text     []byte
+	undos    *UndoTree
+	mark     buffer.Point
+	text     buffer.Text
+	// Fixed highlights, used instead of syntax highlighting.
+	highlights []Highlight
+	// Buffer-local variables, see hooks.go.
+	vars map[string]string
+	// Root of the project the file belongs to, see project.go.
+	project string
+	// Shell the buffer talks to, see shell.go.
+	shell *Shell
+	// Problems found by the last check, see check.go.
+	diagnostics []Location
+	// Whether duplicate lines are highlighted.
+	duplicates bool
+	// Hidden lines, see fold.go.
+	folds []Dot
+	// Bookmarked lines, see bookmark.go.
+	bookmarks []int
+	// Whether the file is edited in large file mode, see large.go.
+	large bool
+	// Output of the sam > and ! commands, shown once the commands are done.
+	samOutput []byte
+	// Words of the text for completion, built when first needed.
+	words *buffer.WordIndex
+	// Starts of lines, built when first needed.
+	lines *buffer.LineIndex
 	// TODO: Turn these into Options struct and pass it around from main to functions as needed.
 	// Options.
 	tabStop int
@@ -53,12 +60,12 @@ type File struct {
 
 func NewFile(name, path string, text []byte) (file *File) {
 	file = &File{
-		name:  name,
-		path:  path,
-		view:  NewView(false),
-		undos: list.New(),
-		redos: list.New(),
-		text:  text,
+		name:    name,
+		path:    path,
+		view:    NewView(false),
+		undos:   NewUndoTree(),
+		text:    buffer.NewText(text),
+		tabStop: tabStop,
 	}
 	return
 }
@@ -77,144 +84,199 @@ func LoadFile(path string) (*File, error) {
 		path:     path,
 		modified: false,
 		view:     NewView(false),
-		undos:    list.New(),
-		redos:    list.New(),
-		text:     text,
+		undos:    NewUndoTree(),
+		text:     buffer.NewText(text),
 	}, nil
 }
 
 func SaveFile(path string, data []byte) error {
-	return ioutil.WriteFile(path, data, 0644)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	if keepHistory && !isLarge(data) {
+		// Failing to keep the history must not fail the save itself.
+		historySave(path, data)
+	}
+	return nil
+}
+
+// Return the screen row and column where the point is displayed.
+func (file *File) DotPosition() (row, col int, ok bool) {
+	row, col, ok = file.view.LocateOffset(file.text.Bytes(), file.point.Off)
+	return row + file.view.top, col + file.view.left, ok
 }
 
+// Move the point to off. Only the text between the point and off is read,
+// so that edits, which move the point to them, don't close the gap of the
+// text, see buffer.Text.
 func (file *File) Goto(off int) {
-	file.point.Goto(file.text, off, file.tabStop)
+	p := &file.point
+	if off < 0 || off > file.text.Len() {
+		return
+	}
+	if off > p.Off {
+		p.Line += file.text.Newlines(p.Off, off)
+	} else {
+		p.Line -= file.text.Newlines(off, p.Off)
+	}
+	p.Off = off
+	p.Col = file.text.Column(off, file.tabStop)
 }
 
+// Line numbering is 1-based.
 func (file *File) GotoLine(l int) {
-	file.point.GotoLine(file.text, l)
+	li := file.lineIndex()
+	l = max(0, min(l-1, li.Lines()-1))
+	file.point = buffer.Point{Off: li.Start(l), Line: l}
+}
+
+func (file *File) lineIndex() *buffer.LineIndex {
+	if file.lines == nil {
+		file.lines = buffer.NewLineIndex(file.text.Bytes())
+	}
+	return file.lines
 }
 
 func (file *File) SearchNext(what []byte, forward bool) {
 	var off int
 	if forward {
-		off = file.point.off + 1
+		off = file.point.Off + 1
 	} else {
-		off = max(0, file.point.off-1)
+		off = max(0, file.point.Off-1)
 	}
-	if i := textSearch(file.text, what, off, forward); i >= 0 {
+	if i := buffer.Search(file.text.Bytes(), what, off, forward); i >= 0 {
 		file.Goto(i)
 	}
 }
 
-func (file *File) leaveMark() {
-	file.mark = file.point
+// Move the point to the next or previous match of the regular expression
+// pattern and return the range of the match. Forward search starts after the
+// point, backward search before it, so that repeated searches find the
+// following or preceding matches. The match is not a dot as in
+// sam, it's only returned, so the caller can highlight or select it.
+func (file *File) SearchRegexp(pattern string, forward bool) (start, end int, err error) {
+	re, err := compileSearch(pattern)
+	if err != nil {
+		return -1, -1, err
+	}
+	off := file.point.Off
+	if forward {
+		off++
+	}
+	start, end = file.searchRegexp(re, off, forward)
+	return start, end, nil
 }
 
-func (file *File) gotoMark() {
-	file.point = file.mark
+// Search for re from off and move the point to the match, if there is one.
+func (file *File) searchRegexp(re *regexp.Regexp, off int, forward bool) (start, end int) {
+	if forward {
+		start, end = buffer.SearchRegexp(file.text.Bytes(), re, min(off, file.text.Len()))
+	} else {
+		start, end = buffer.SearchRegexpBackward(file.text.Bytes(), re, max(0, off))
+	}
+	if start >= 0 {
+		file.Goto(start)
+	}
+	return
 }
 
-func (file *File) pushUndo(what []byte, off int, isInsert bool) {
-	// Mini file (dialogs) doesn't use the undo stack.
-	if file.undos == nil {
-		return
-	}
-	u := Undo{off, append([]byte(nil), what...), isInsert}
-	file.undos.PushFront(u)
-	file.redos.Init()
+// Compile a search pattern, where ^ and $ match at line boundaries.
+func compileSearch(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("(?m)" + pattern)
 }
 
-func (file *File) Undo() {
-	e := file.undos.Front()
-	if e == nil {
-		return
-	}
-	u := file.undos.Remove(e).(Undo)
-	file.Goto(u.off)
-	if u.isInsert {
-		file.delete(u.off, u.off+len(u.text))
-	} else {
-		// Use insert() so the undo record is not recreated.
-		file.insert(u.text)
-	}
-	file.redos.PushFront(u)
+func (file *File) leaveMark() {
+	file.mark = file.point
 }
 
-func (file *File) Redo() {
-	e := file.redos.Front()
-	if e == nil {
-		return
-	}
-	u := file.redos.Remove(e).(Undo)
-	file.Goto(u.off)
-	if u.isInsert {
-		file.insert(u.text)
-	} else {
-		file.delete(u.off, u.off+len(u.text))
-	}
-	file.undos.PushFront(u)
+func (file *File) gotoMark() {
+	file.point = file.mark
 }
 
 // Insert the byte slice what in the current point position.
 // Does not create an undo record.
 func (file *File) insert(what []byte) {
-	file.text = textInsert(file.text, file.point.off, what)
+	if file.words != nil {
+		file.words.Before(&file.text, file.point.Off, file.point.Off)
+	}
+	file.text.Insert(file.point.Off, what)
+	if file.lines != nil {
+		file.lines.Insert(file.point.Off, what)
+	}
+	if file.words != nil {
+		file.words.After(&file.text, file.point.Off, file.point.Off+len(what))
+	}
 	l := len(what)
 	nl := bytes.Count(what, NL)
 	// Fix the mark.
-	if file.mark.off >= file.point.off {
-		file.mark.off += l
-		file.mark.line += nl
+	if file.mark.Off >= file.point.Off {
+		file.mark.Off += l
+		file.mark.Line += nl
 		// This might be a performance hog when there are more marks...
-		file.mark.col = file.mark.Column(file.text, file.tabStop)
+		file.mark.Col = file.text.Column(file.mark.Off, file.tabStop)
 	}
 	// Fix the view, as the edit could have potentially been done in front of it.
-	if file.point.off < file.view.start {
+	if file.point.Off < file.view.start {
 		file.view.start += l
 	}
-	file.point.off += l
-	file.point.line += nl
-	file.point.col = file.point.Column(file.text, file.tabStop)
+	file.shiftFolds(file.point.Off, l)
+	file.shiftBookmarks(file.point.Off, l)
+	file.point.Off += l
+	file.point.Line += nl
+	file.point.Col = file.text.Column(file.point.Off, file.tabStop)
 	file.modified = true
 }
 
 // Insert the byte slice what in the current point position.
 // Insert is to be called from the main editor.
 func (file *File) Insert(what []byte) {
-	if len(what) == 0 {
+	if len(what) == 0 || file.readOnly {
 		return
 	}
-	if what[0] == '\r' {
-		what[0] = '\n'
+	file.pushUndo(what, file.point.Off, true)
+	file.insert(what)
+}
+
+// Insert what was typed. Keys that aren't text, like the escape sequences of
+// keys not bound to anything, are ignored, and a carriage return is a newline.
+func (file *File) InsertKey(key []byte) {
+	if len(key) == 0 {
+		return
 	}
-	r, _ := utf8.DecodeRune(what)
-	if unicode.IsPrint(r) || what[0] == '\n' || what[0] == '\t' {
-		if what[0] == '\r' {
-			what[0] = '\n'
-		}
-		file.pushUndo(what, file.point.off, true)
-		file.insert(what)
+	if key[0] == '\r' {
+		key = append([]byte{'\n'}, key[1:]...)
+	}
+	r, _ := utf8.DecodeRune(key)
+	if unicode.IsPrint(r) || key[0] == '\n' || key[0] == '\t' {
+		file.Insert(key)
 	}
 }
 
 func (file *File) CopyLine() (line []byte) {
-	ls, le := lineStart(file.text, file.point.off), lineEnd(file.text, file.point.off)
-	le = min(len(file.text), le+1)
-	line = append([]byte(nil), file.text[ls:le]...)
+	ls, le := buffer.LineRange(file.text.Bytes(), file.point.Off, true)
+	line = append([]byte(nil), file.text.Slice(ls, le)...)
 	return
 }
 
 func (file *File) delete(start, end int) (what []byte) {
-	file.point.Goto(file.text, start, file.tabStop)
-	file.text, what = textDelete(file.text, start, end)
+	file.Goto(start)
+	if file.words != nil {
+		file.words.Before(&file.text, start, end)
+	}
+	what = file.text.Delete(start, end)
+	if file.lines != nil {
+		file.lines.Delete(start, len(what))
+	}
+	if file.words != nil {
+		file.words.After(&file.text, start, start)
+	}
 	// Fix the mark.
-	if file.mark.off >= start && file.mark.off <= end {
+	if file.mark.Off >= start && file.mark.Off <= end {
 		file.mark = file.point
-	} else if file.mark.off > end {
-		file.mark.off -= len(what)
-		file.mark.line -= bytes.Count(what, NL)
-		file.mark.col = file.mark.Column(file.text, file.tabStop)
+	} else if file.mark.Off > end {
+		file.mark.Off -= len(what)
+		file.mark.Line -= bytes.Count(what, NL)
+		file.mark.Col = file.text.Column(file.mark.Off, file.tabStop)
 	}
 	// Fix the view, as the edit could have potentially been done in front of it.
 	if file.view.start >= start && file.view.start < end {
@@ -222,70 +284,163 @@ func (file *File) delete(start, end int) (what []byte) {
 	} else if file.view.start > end {
 		file.view.start -= len(what)
 	}
+	file.shiftFolds(start, -len(what))
+	file.shiftBookmarks(start, -len(what))
 	file.modified = true
 	return
 }
 
 func (file *File) Delete(start, end int) (what []byte) {
+	if file.readOnly {
+		return nil
+	}
 	start = max(0, start)
-	end = min(len(file.text), end)
+	end = min(file.text.Len(), end)
 	what = file.delete(start, end)
 	file.pushUndo(what, start, false)
 	return
 }
 
 func (file *File) DeleteLineEnd() (what []byte) {
-	what = file.Delete(file.point.off, lineEnd(file.text, file.point.off))
+	what = file.Delete(file.point.Off, buffer.LineEnd(file.text.Bytes(), file.point.Off))
 	return
 }
 
 func (file *File) DeleteLineStart() (what []byte) {
-	what = file.Delete(lineStart(file.text, file.point.off), file.point.off)
+	what = file.Delete(buffer.LineStart(file.text.Bytes(), file.point.Off), file.point.Off)
 	return
 }
 
 func (file *File) DeleteLine(whole bool) (line []byte) {
-	ls, le := lineStart(file.text, file.point.off), lineEnd(file.text, file.point.off)
-	if whole {
-		line = file.Delete(ls, le+1)
-	} else {
-		line = file.Delete(ls, le)
-	}
+	ls, le := buffer.LineRange(file.text.Bytes(), file.point.Off, whole)
+	line = file.Delete(ls, le)
 	return
 }
 
 func (file *File) DeleteChar() {
-	if file.point.off >= len(file.text) {
+	if file.point.Off >= file.text.Len() {
 		return
 	}
-	_, s := utf8.DecodeRune(file.text[file.point.off:])
-	file.Delete(file.point.off, file.point.off+s)
+	_, s := utf8.DecodeRune(file.text.Bytes()[file.point.Off:])
+	file.Delete(file.point.Off, file.point.Off+s)
 }
 
 func (file *File) Backspace() {
-	if file.point.off == 0 {
+	if file.point.Off == 0 {
 		return
 	}
-	file.point.Left(file.text, file.tabStop)
+	file.point.Left(file.text.Bytes(), file.tabStop)
 	file.DeleteChar()
 }
 
 func (file *File) Clear() {
-	if len(file.text) == 0 {
+	if file.text.Len() == 0 || file.readOnly {
 		return
 	}
-	file.pushUndo(append([]byte(nil), file.text...), 0, false)
-	file.point = Point{}
-	file.mark = Point{}
-	file.text = []byte("")
+	file.pushUndo(append([]byte(nil), file.text.Bytes()...), 0, false)
+	file.point = buffer.Point{}
+	file.mark = buffer.Point{}
+	file.text = buffer.Text{}
+	file.words = nil
+	file.lines = nil
+	file.folds = nil
 	file.modified = true
 }
 
+// Replace the whole text. The point stays at the same offset, if possible.
+func (file *File) Replace(text []byte) {
+	if file.readOnly {
+		return
+	}
+	off := file.point.Off
+	file.Clear()
+	file.view.start = 0
+	if len(text) > 0 {
+		file.pushUndo(text, 0, true)
+		file.insert(text)
+	}
+	file.Goto(min(off, file.text.Len()))
+}
+
+// Replace the text between start and end with text, changing only the lines
+// that differ. The point, the mark, folds and bookmarks in the lines that stay
+// keep to their text, and the point in a changed line keeps its line and
+// column as far as the new lines go. Formatting, filtering and reverting the
+// buffer use this, so the point doesn't jump away.
+func (file *File) ReplaceRange(start, end int, text []byte) {
+	old := file.text.Slice(start, end)
+	if file.readOnly || bytes.Equal(old, text) {
+		return
+	}
+	a, b := textLines(old), textLines(text)
+	aoffs, boffs := lineOffsets(a), lineOffsets(b)
+	hunks := buffer.DiffLines(a, b)
+
+	// Where the point ends up.
+	point := file.point.Off
+	if point >= end {
+		point += len(text) - len(old)
+	} else if point >= start {
+		rel := point - start
+		i := sort.SearchInts(aoffs, rel+1) - 1
+		j, col := i, rel-aoffs[i]
+		for _, h := range hunks {
+			if i < h.A0 {
+				break
+			}
+			if i < h.A1 {
+				// A deleted line leaves the point at the start of what
+				// follows.
+				if j = h.B0 + i - h.A0; j >= h.B1 {
+					j, col = h.B1, 0
+				}
+				break
+			}
+			j += (h.B1 - h.B0) - (h.A1 - h.A0)
+		}
+		if j < len(b) {
+			col = min(col, len(bytes.TrimSuffix(b[j], NL)))
+		} else {
+			col = 0
+		}
+		point = start + boffs[min(j, len(b))] + col
+	}
+
+	file.BeginUndoGroup()
+	for k := len(hunks) - 1; k >= 0; k-- {
+		h := hunks[k]
+		off := start + aoffs[h.A0]
+		file.Delete(off, start+aoffs[h.A1])
+		if ins := text[boffs[h.B0]:boffs[h.B1]]; len(ins) > 0 {
+			file.Goto(off)
+			file.pushUndo(ins, off, true)
+			file.insert(ins)
+		}
+	}
+	file.EndUndoGroup()
+	file.Goto(point)
+}
+
+// Append text at the end of the file. The point stays where it is, unless
+// it's at the end, so the appended text can be followed.
+func (file *File) Append(text []byte) {
+	if len(text) == 0 || file.readOnly {
+		return
+	}
+	off, end := file.point.Off, file.text.Len()
+	file.Goto(end)
+	file.pushUndo(text, end, true)
+	file.insert(text)
+	if off < end {
+		file.Goto(off)
+	}
+}
+
 func (file *File) Save() error {
 	if !file.modified {
 		return nil
 	}
-	err := SaveFile(file.path, file.text)
+	err := SaveFile(file.path, file.text.Bytes())
 	if err != nil {
 		return err
 	}
@@ -297,42 +452,96 @@ type Dot struct {
 	start, end int
 }
 
-func (file *File) samAddress(addr *sam.Address) (start, end int) {
+// Evaluate addr, including the addresses relative to it, in the current dot.
+func (file *File) samAddress(addr *sam.Address, dot Dot) Dot {
+	dot = file.samSimpleAddress(addr, dot)
+	for rel := addr.Next; rel != nil; rel = rel.Next {
+		dot = file.samRelativeAddress(rel, dot)
+	}
+	return dot
+}
+
+func (file *File) samSimpleAddress(addr *sam.Address, dot Dot) (res Dot) {
 	switch addr.Type {
 	case '0':
-		start = 0
+		res.start = 0
+	case '.':
+		res = dot
 	case '$':
-		start = len(file.text)
-		end = start
+		res.start = file.text.Len()
+		res.end = res.start
 	case '#':
 		p := file.point
 		c, _ := strconv.Atoi(addr.Arg)
-		p.Goto(file.text, c, file.view.visual.tabStop)
-		start = p.off
-		end = start
+		p.Goto(file.text.Bytes(), c, file.view.visual.tabStop)
+		res.start = p.Off
+		res.end = res.start
 	case 'l':
-		p := file.point
 		l, _ := strconv.Atoi(addr.Arg)
-		p.GotoLine(file.text, l)
-		start = p.off
-		end = lineEnd(file.text, start) + 1
+		res.start = file.lineIndex().Start(l - 1)
+		res.end = buffer.LineEnd(file.text.Bytes(), res.start) + 1
 	case '/':
 		arg := []byte(addr.Arg)
-		if i := textSearch(file.text, arg, file.point.off, true); i >= 0 {
-			start = i
-			end = i + utf8.RuneCount(arg)
+		if i := buffer.Search(file.text.Bytes(), arg, dot.end, true); i >= 0 {
+			res.start = i
+			res.end = i + utf8.RuneCount(arg)
 		}
 	}
 	return
 }
 
+// Evaluate an address relative to dot. Going forward starts at the end of dot,
+// going backward at its start. Lines are counted from the line dot ends on,
+// or starts on, so .+1 is the line below the point and .-1 the one above.
+func (file *File) samRelativeAddress(addr *sam.Address, dot Dot) Dot {
+	forward := addr.Sign == '+'
+	switch addr.Type {
+	case '#':
+		n, _ := strconv.Atoi(addr.Arg)
+		if forward {
+			p := min(file.text.Len(), dot.end+n)
+			return Dot{p, p}
+		}
+		p := max(0, dot.start-n)
+		return Dot{p, p}
+	case '/':
+		arg := []byte(addr.Arg)
+		off := dot.end
+		if !forward {
+			// Matches ending before dot.
+			off = dot.start - len(arg)
+		}
+		if i := buffer.Search(file.text.Bytes(), arg, off, forward); i >= 0 {
+			return Dot{i, i + len(arg)}
+		}
+		return dot
+	}
+	n, _ := strconv.Atoi(addr.Arg)
+	var p int
+	if forward {
+		p = dot.end
+		if dot.start == dot.end || p > 0 && file.text.Bytes()[p-1] != '\n' {
+			p = min(file.text.Len(), buffer.LineEnd(file.text.Bytes(), p)+1)
+		}
+		for i := 1; i < n && p < file.text.Len(); i++ {
+			p = buffer.LineEnd(file.text.Bytes(), p) + 1
+		}
+	} else {
+		p = buffer.LineStart(file.text.Bytes(), dot.start)
+		for i := 0; i < n && p > 0; i++ {
+			p = buffer.LineStart(file.text.Bytes(), p-1)
+		}
+	}
+	return Dot{p, min(file.text.Len(), buffer.LineEnd(file.text.Bytes(), p)+1)}
+}
+
 func (file *File) samExecuteEdit(cmd *sam.Command, dot Dot) (Dot, int) {
 	off := 0
 	switch cmd.Name {
 	case "d":
-		file.Delete(dot.start, dot.end)
+		deleted := file.Delete(dot.start, dot.end)
 		dot.end = dot.start
-		off = -len(cmd.Arg)
+		off = -len(deleted)
 	case "a":
 		file.Goto(dot.end)
 		file.Insert([]byte(cmd.Arg))
@@ -353,13 +562,128 @@ func (file *File) samExecuteEdit(cmd *sam.Command, dot Dot) (Dot, int) {
 	return dot, off
 }
 
+// Replace the first match of the regexp in dot. Dot becomes the replaced text
+// along with the rest of the old dot.
+func (file *File) samExecuteS(cmd *sam.Command, dot Dot) (Dot, int, error) {
+	re, err := regexp.Compile(cmd.Arg)
+	if err != nil {
+		return dot, 0, err
+	}
+	m := re.FindSubmatchIndex(file.text.Slice(dot.start, dot.end))
+	if m == nil {
+		return dot, 0, nil
+	}
+	for i := range m {
+		if m[i] >= 0 {
+			m[i] += dot.start
+		}
+	}
+	repl := sam.Expand(cmd.Repl, file.text.Bytes(), m)
+	file.Goto(m[0])
+	deleted := file.Delete(m[0], m[1])
+	file.Insert(repl)
+	off := len(repl) - len(deleted)
+	dot.end += off
+	return dot, off, nil
+}
+
+// Write dot, or the whole text if dot is empty, to a file, replace dot with
+// the contents of a file, or the whole text. The file defaults to the one of
+// the buffer, so a plain e reverts the buffer.
+func (file *File) samExecuteFile(cmd *sam.Command, dot Dot) (Dot, int, error) {
+	path := cmd.Arg
+	if path == "" {
+		path = file.path
+	}
+	if path == "" {
+		return dot, 0, errors.New("no file name")
+	}
+	if cmd.Name == "w" {
+		if dot.start == dot.end {
+			dot = Dot{0, file.text.Len()}
+		}
+		if err := SaveFile(path, file.text.Slice(dot.start, dot.end)); err != nil {
+			return dot, 0, err
+		}
+		if dot.start == 0 && dot.end == file.text.Len() && absPath(path) == absPath(file.path) {
+			file.modified = false
+		}
+		return dot, 0, nil
+	}
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return dot, 0, err
+	}
+	if cmd.Name == "e" {
+		off := len(text) - file.text.Len()
+		file.Replace(text)
+		if absPath(path) == absPath(file.path) {
+			file.modified = false
+		}
+		return Dot{0, file.text.Len()}, off, nil
+	}
+	file.Goto(dot.start)
+	deleted := file.Delete(dot.start, dot.end)
+	file.Insert(text)
+	return Dot{dot.start, dot.start + len(text)}, len(text) - len(deleted), nil
+}
+
+// Run a shell command in the directory of the file. | and > get dot on the
+// standard input, | and < replace dot with the standard output, and the output
+// of > and ! is kept in samOutput.
+func (file *File) samExecuteShell(cmd *sam.Command, dot Dot) (Dot, int, error) {
+	c := exec.Command("sh", "-c", cmd.Arg)
+	c.Dir = file.dir()
+	if cmd.Name == "|" || cmd.Name == ">" {
+		c.Stdin = bytes.NewReader(file.text.Slice(dot.start, dot.end))
+	}
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			err = fmt.Errorf("%s: %s", cmd.Arg, msg)
+		}
+		return dot, 0, err
+	}
+	if cmd.Name == ">" || cmd.Name == "!" {
+		file.samOutput = append(file.samOutput, out...)
+		return dot, 0, nil
+	}
+	file.ReplaceRange(dot.start, dot.end, out)
+	return Dot{dot.start, dot.start + len(out)}, len(out) - (dot.end - dot.start), nil
+}
+
+// Run the commands of a group, each on the same dot, moved by the changes of
+// the commands before it. Insertions go before dot, appends after it, and
+// everything else changes dot itself.
+func (file *File) samExecuteGroup(cmd *sam.Command, dot Dot) (Dot, int, error) {
+	total := 0
+	for _, c := range cmd.Group {
+		d, off, err := file.samExecuteCommand(c, dot)
+		if err != nil {
+			return d, total, err
+		}
+		switch c.Name {
+		case "i":
+			dot.start += off
+			dot.end += off
+		case "a":
+		default:
+			dot.end = max(dot.start, dot.end+off)
+		}
+		total += off
+	}
+	return dot, total, nil
+}
+
 func (file *File) samExecuteX(cmd *sam.Command, dot Dot) (Dot, int, error) {
 	re, err := regexp.Compile(cmd.Arg)
 	if err != nil {
 		return dot, 0, err
 	}
 	p := dot.start
-	matches := re.FindAllIndex(file.text[p:dot.end], -1)
+	matches := re.FindAllIndex(file.text.Slice(p, dot.end), -1)
 	offset := 0
 	for _, match := range matches {
 		var off int
@@ -379,9 +703,9 @@ func (file *File) samExecuteCond(cmd *sam.Command, dot Dot, include bool) (Dot,
 		return dot, 0, err
 	}
 	var off int
-	if include && re.Match(file.text[dot.start:dot.end]) {
+	if include && re.Match(file.text.Slice(dot.start, dot.end)) {
 		dot, off, err = file.samExecuteCommand(cmd.Next, dot)
-	} else if !include && !re.Match(file.text[dot.start:dot.end]) {
+	} else if !include && !re.Match(file.text.Slice(dot.start, dot.end)) {
 		dot, off, err = file.samExecuteCommand(cmd.Next, dot)
 	}
 	return dot, off, err
@@ -404,23 +728,22 @@ func (file *File) samExecuteCommand(cmd *sam.Command, dot Dot) (Dot, int, error)
 	switch cmd.Name {
 	case "d", "a", "i", "c":
 		dot, off = file.samExecuteEdit(cmd, dot)
+	case "s":
+		dot, off, err = file.samExecuteS(cmd, dot)
+	case "{":
+		dot, off, err = file.samExecuteGroup(cmd, dot)
+	case "w", "r", "e":
+		dot, off, err = file.samExecuteFile(cmd, dot)
+	case "|", "<", ">", "!":
+		dot, off, err = file.samExecuteShell(cmd, dot)
 	case "x":
 		dot, off, err = file.samExecuteX(cmd, dot)
 	case "g":
 		dot, off, err = file.samExecuteG(cmd, dot)
 	case "v":
 		dot, off, err = file.samExecuteV(cmd, dot)
+	case "X", "Y":
+		err = fmt.Errorf("%s only runs on its own", cmd.Name)
 	}
 	return dot, off, err
 }
-
-func (file *File) samExecuteCommandList(cmdList []*sam.Command, dot Dot) (Dot, error) {
-	var err error
-	for _, cmd := range cmdList {
-		dot, _, err = file.samExecuteCommand(cmd, dot)
-		if err != nil {
-			return dot, err
-		}
-	}
-	return dot, nil
-}
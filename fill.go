@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jsynacek/med/buffer"
+)
+
+// With autoFill set, typing a space past fillColumn breaks the line at the last
+// space that fits. The new line starts with the same indentation and comment
+// marker as the broken one. Lines are only broken in comments, or anywhere in
+// prose files, so code is left alone. Both options can be set per buffer:
+//
+//	local "*.md" autoFill true
+//	local "*.md" fillColumn 72
+
+var commentMarkers = []string{"//", "#", "--", ";", "%", "*"}
+
+// Return the prefix lines continuing line start with, its indentation and
+// comment marker, and whether line is a comment. In prose, only quotes, like
+// in Markdown or mail, are taken to be markers.
+func fillPrefix(line []byte, prose bool) (prefix string, comment bool) {
+	n := len(line) - len(bytes.TrimLeft(line, " \t"))
+	markers := commentMarkers
+	if prose {
+		markers = []string{">"}
+	}
+	for _, m := range markers {
+		if bytes.HasPrefix(line[n:], []byte(m)) {
+			e := n + len(m)
+			// Runs like /// or ## are a single marker.
+			for e < len(line) && line[e] == m[len(m)-1] {
+				e++
+			}
+			for e < len(line) && line[e] == ' ' {
+				e++
+			}
+			return string(line[:e]), true
+		}
+	}
+	return string(line[:n]), false
+}
+
+// Break the line of the point if the space just typed is past the fill column.
+func (file *File) autoFill() {
+	if file.Option("autoFill") != "true" {
+		return
+	}
+	fill := 79
+	fmt.Sscan(file.Option("fillColumn"), &fill)
+	off := file.point.Off
+	if off == 0 || file.text.Bytes()[off-1] != ' ' || file.point.Col-1 <= fill {
+		return
+	}
+	ls := buffer.LineStart(file.text.Bytes(), off)
+	prose := isProse(file.path)
+	prefix, comment := fillPrefix(file.text.Slice(ls, off), prose)
+	if !comment && !prose {
+		return
+	}
+	// Find the last run of spaces starting within the fill column, or the
+	// first one if a word doesn't fit.
+	start, end := -1, -1
+	col := buffer.Point{Off: ls + len(prefix)}
+	col.Col = col.Column(file.text.Bytes(), file.tabStop)
+	for p := ls + len(prefix); p < off; {
+		if file.text.Bytes()[p] != ' ' {
+			col.Right(file.text.Bytes(), file.tabStop)
+			p = col.Off
+			continue
+		}
+		e := p
+		for e < off && file.text.Bytes()[e] == ' ' {
+			e++
+		}
+		if start < 0 || col.Col <= fill {
+			start, end = p, e
+		}
+		if col.Col > fill {
+			break
+		}
+		col.Goto(file.text.Bytes(), e, file.tabStop)
+		p = e
+	}
+	if start < 0 {
+		return
+	}
+	file.BeginUndoGroup()
+	file.Delete(start, end)
+	file.Goto(start)
+	file.Insert([]byte("\n" + prefix))
+	file.EndUndoGroup()
+	file.Goto(off - (end - start) + 1 + len(prefix))
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/jsynacek/med/buffer"
+	"github.com/jsynacek/med/sam"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A fold hides whole lines below the line it starts at, which is followed
+// by a single line telling how many lines are hidden. Folds open while the
+// point is in them. They are made from sam addresses and x loops:
+//
+//	0,$x/(?m)^func Test.*/
+//
+// folds every test function, as a fold made from a range ending with an
+// opening bracket extends to the line of the matching bracket. Folds of files
+// are remembered in ~/.cache/med/folds with the positions, one file per line:
+//
+//	<start>-<end>,... <absolute path>
+
+// Return the fold of the lines of text between start and end, which hides all
+// but the first line, or false if it would hide nothing.
+func foldRange(text []byte, start, end int) (Dot, bool) {
+	ls := buffer.LineStart(text, start)
+	le := buffer.LineEnd(text, max(start, end-1))
+	line := bytes.TrimRight(text[ls:le], " \t")
+	if n := len(line); n > 0 {
+		pairs := map[byte]string{'{': "}", '(': ")", '[': "]"}
+		if right, ok := pairs[line[n-1]]; ok {
+			if i, ok := buffer.MatchingBracket(text, ls+n-1, string(line[n-1]), right); ok {
+				le = buffer.LineEnd(text, i)
+			}
+		}
+	}
+	fold := Dot{buffer.LineEnd(text, ls) + 1, min(len(text), le+1)}
+	return fold, fold.start < fold.end
+}
+
+// Add a fold, unless it overlaps another one.
+func (file *File) addFold(fold Dot) bool {
+	for _, f := range file.folds {
+		if fold.start < f.end && f.start < fold.end {
+			return false
+		}
+	}
+	file.folds = append(file.folds, fold)
+	sort.Slice(file.folds, func(i, j int) bool {
+		return file.folds[i].start < file.folds[j].start
+	})
+	return true
+}
+
+// Folds that are closed, as the point is not in them.
+func (file *File) closedFolds(point int) (folds []Dot) {
+	for _, f := range file.folds {
+		if point < f.start || point >= f.end {
+			folds = append(folds, f)
+		}
+	}
+	return
+}
+
+// Move the folds after an insert of n bytes at off, or a delete of n bytes
+// at off if n is negative.
+func (file *File) shiftFolds(off, n int) {
+	move := func(x int) int {
+		switch {
+		case n >= 0 && x >= off:
+			return x + n
+		case n < 0 && x >= off-n:
+			return x + n
+		case n < 0 && x > off:
+			return off
+		}
+		return x
+	}
+	var folds []Dot
+	for _, f := range file.folds {
+		start := move(f.start)
+		end := f.end
+		if n >= 0 && off < f.end && off > f.start {
+			// Inserted inside the fold.
+			end += n
+		} else {
+			end = move(f.end)
+		}
+		if start < end {
+			folds = append(folds, Dot{start, end})
+		}
+	}
+	file.folds = folds
+}
+
+// Return the dots a sam command list without edits selects in dot.
+func (file *File) samDots(cmd *sam.Command, dot Dot) ([]Dot, error) {
+	if cmd == nil {
+		return []Dot{dot}, nil
+	}
+	switch cmd.Name {
+	case "x", "g", "v":
+		re, err := regexp.Compile(cmd.Arg)
+		if err != nil {
+			return nil, err
+		}
+		var dots []Dot
+		if cmd.Name == "x" {
+			for _, m := range re.FindAllIndex(file.text.Slice(dot.start, dot.end), -1) {
+				ds, err := file.samDots(cmd.Next, Dot{dot.start + m[0], dot.start + m[1]})
+				if err != nil {
+					return nil, err
+				}
+				dots = append(dots, ds...)
+			}
+		} else if re.Match(file.text.Slice(dot.start, dot.end)) == (cmd.Name == "g") {
+			return file.samDots(cmd.Next, dot)
+		}
+		return dots, nil
+	}
+	return nil, fmt.Errorf("cannot fold with the %s command", cmd.Name)
+}
+
+// Fold all ranges selected by a sam address and x, g and v commands.
+func foldSam(med *Med, file *File) {
+	update := func() {}
+	finish := func(cancel bool) {
+		if cancel || med.dialog.file.text.Len() == 0 {
+			return
+		}
+		var p sam.Parser
+		p.Init(med.dialog.file.text.Bytes())
+		addr, cmdList, err := p.Parse()
+		if err != nil {
+			med.pushError(err)
+			return
+		}
+		dots := []Dot{med.samDot(file, addr)}
+		for _, cmd := range cmdList {
+			var next []Dot
+			for _, d := range dots {
+				ds, err := file.samDots(cmd, d)
+				if err != nil {
+					med.pushError(err)
+					return
+				}
+				next = append(next, ds...)
+			}
+			dots = next
+		}
+		n := 0
+		for _, d := range dots {
+			if fold, ok := foldRange(file.text.Bytes(), d.start, d.end); ok && file.addFold(fold) {
+				n++
+			}
+		}
+		med.showMessage("%d folds", n)
+	}
+	med.startDialog("fold", update, finish, Helm{})
+}
+
+// Remove the fold the point is in, or the one starting on the line below.
+func unfold(med *Med, file *File) {
+	next := buffer.LineEnd(file.text.Bytes(), file.point.Off) + 1
+	for i, f := range file.folds {
+		if f.start == next || file.point.Off >= f.start && file.point.Off < f.end {
+			file.folds = append(file.folds[:i], file.folds[i+1:]...)
+			return
+		}
+	}
+	med.pushError(errors.New("no fold here"))
+}
+
+func unfoldAll(med *Med, file *File) {
+	file.folds = nil
+}
+
+func foldsPath() string {
+	return filepath.Join(cacheDir(), "folds")
+}
+
+func readFolds() map[string][]Dot {
+	f, err := os.Open(foldsPath())
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	folds := make(map[string][]Dot)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.SplitN(s.Text(), " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		var dots []Dot
+		for _, r := range strings.Split(fields[0], ",") {
+			var d Dot
+			if _, err := fmt.Sscanf(r, "%d-%d", &d.start, &d.end); err == nil {
+				dots = append(dots, d)
+			}
+		}
+		folds[fields[1]] = dots
+	}
+	return folds
+}
+
+// Remember folds of files that have a path. Files without folds are forgotten.
+func rememberFolds(files ...*File) error {
+	folds := readFolds()
+	if folds == nil {
+		folds = make(map[string][]Dot)
+	}
+	for _, file := range files {
+		if file.path == "" || file.scratch {
+			continue
+		}
+		if len(file.folds) > 0 {
+			folds[absPath(file.path)] = file.folds
+		} else {
+			delete(folds, absPath(file.path))
+		}
+	}
+	var b strings.Builder
+	for path, dots := range folds {
+		var rs []string
+		for _, d := range dots {
+			rs = append(rs, fmt.Sprintf("%d-%d", d.start, d.end))
+		}
+		fmt.Fprintf(&b, "%s %s\n", strings.Join(rs, ","), path)
+	}
+	if err := os.MkdirAll(cacheDir(), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(foldsPath(), []byte(b.String()), 0600)
+}
+
+// Restore folds of the file. Folds that don't cover whole lines of the text
+// any more, as the file changed in the meantime, are dropped.
+func restoreFolds(file *File) {
+	for _, d := range readFolds()[absPath(file.path)] {
+		if d.start > 0 && d.start < d.end && d.end <= file.text.Len() &&
+			file.text.Bytes()[d.start-1] == '\n' && (d.end == file.text.Len() || file.text.Bytes()[d.end-1] == '\n') {
+			file.addFold(d)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// The buffer is formatted by piping it through formatCommand, like gofmt or
+// goimports. Only the lines that change are replaced, in a single undo block,
+// so the point stays where it was. To format files whenever they are saved:
+//
+//	hook before-save "*.go" formatBuffer
+//
+// If the command fails, on a syntax error for example, the buffer is left as
+// it is and saved anyway.
+
+func formatBuffer(med *Med, file *File) {
+	if file.readOnly {
+		return
+	}
+	command := file.Option("formatCommand")
+	if command == "" {
+		med.pushError(errors.New("no formatCommand"))
+		return
+	}
+	c := exec.Command("sh", "-c", command)
+	c.Dir = file.dir()
+	c.Stdin = bytes.NewReader(file.text.Bytes())
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			err = fmt.Errorf("%s: %s", command, msg)
+		}
+		med.pushError(err)
+		return
+	}
+	if !bytes.Equal(out, file.text.Bytes()) {
+		file.ReplaceRange(0, file.text.Len(), out)
+	}
+}
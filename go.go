@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"go/scanner"
 	"go/token"
+	"sort"
 	"unicode"
 	"unicode/utf8"
 )
@@ -38,35 +40,88 @@ var goPackages = []string{
 	"unicode", "unicode/utf16", "unicode/utf8", "unsafe",
 }
 
-func getSyntax(text []byte, off int, maxLines int) (res []Highlight) {
+// The Go highlighter remembers offsets of lines in the last text it
+// highlighted that start with a token, and so not in the middle of a
+// multi-line raw string or comment. Scanning starts from the last of them
+// before the highlighted lines instead of the beginning of the text. Those
+// after the first change to the text are forgotten.
+type goHighlighter struct {
+	text  []byte
+	syncs []int
+}
+
+// Minimum distance in bytes between the offsets goHighlighter remembers.
+const goSyncDistance = 4096
+
+func (h *goHighlighter) Highlight(text []byte, off, maxLines int) []Highlight {
+	n := commonPrefix(h.text, text)
+	h.syncs = h.syncs[:sort.SearchInts(h.syncs, n+1)]
+	if n < len(h.text) || n < len(text) {
+		h.text = append(h.text[:0], text...)
+	}
+	start := 0
+	if i := sort.SearchInts(h.syncs, off+1); i > 0 {
+		start = h.syncs[i-1]
+	}
+	return goSyntax(text, start, off, maxLines, func(sync int) {
+		if len(h.syncs) == 0 && sync >= goSyncDistance || len(h.syncs) > 0 && sync >= h.syncs[len(h.syncs)-1]+goSyncDistance {
+			h.syncs = append(h.syncs, sync)
+		}
+	})
+}
+
+// Length of the common prefix of a and b.
+func commonPrefix(a, b []byte) int {
+	n := 0
+	// Compare in chunks first, which is much faster than byte by byte.
+	for c := 4096; n+c <= len(a) && n+c <= len(b) && bytes.Equal(a[n:n+c], b[n:n+c]); {
+		n += c
+	}
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// Highlight Go source, scanning text from start, which must not be in the
+// middle of a token. Offsets of tokens at the start of a line are passed to
+// sync.
+func goSyntax(text []byte, start, off int, maxLines int, sync func(int)) (res []Highlight) {
 	var s scanner.Scanner
 	fset := token.NewFileSet()
-	file := fset.AddFile("", fset.Base(), len(text)-off)
-	s.Init(file, text[off:], nil, scanner.ScanComments)
+	file := fset.AddFile("", fset.Base(), len(text)-start)
+	s.Init(file, text[start:], nil, scanner.ScanComments)
 	l := 0
 	for l < maxLines {
 		pos, tok, lit := s.Scan()
 		if tok == token.EOF {
 			break
-		} else if tok == token.SEMICOLON && lit[0] == '\n' {
+		}
+		tstart := start + int(pos) - 1
+		end := tstart + len(lit)
+		if tstart > 0 && text[tstart-1] == '\n' && tok != token.SEMICOLON {
+			sync(tstart)
+		}
+		if end <= off {
+			continue
+		}
+		if tok == token.SEMICOLON && lit[0] == '\n' {
 			l++
 		}
-		start := off + int(pos) - 1
-		end := start + len(lit)
 		switch tok {
 		case token.COMMENT:
-			res = append(res, Highlight{start, end, theme["comment"]})
+			res = append(res, Highlight{tstart, end, theme["comment"]})
 		// Keywords.
 		case token.BREAK, token.CASE, token.CHAN, token.CONST, token.CONTINUE, token.DEFAULT,
 			token.DEFER, token.ELSE, token.FALLTHROUGH, token.FOR, token.FUNC, token.GO,
 			token.GOTO, token.IF, token.IMPORT, token.INTERFACE, token.MAP, token.PACKAGE,
 			token.RANGE, token.RETURN, token.SELECT, token.STRUCT, token.SWITCH,
 			token.TYPE, token.VAR:
-			res = append(res, Highlight{start, end, theme["keyword"]})
+			res = append(res, Highlight{tstart, end, theme["keyword"]})
 		case token.STRING:
-			res = append(res, Highlight{start, end, theme["string"]})
+			res = append(res, Highlight{tstart, end, theme["string"]})
 		case token.CHAR:
-			res = append(res, Highlight{start, end, theme["char"]})
+			res = append(res, Highlight{tstart, end, theme["char"]})
 		}
 	}
 	return
@@ -98,23 +153,23 @@ func markWord(text []byte, point int) (int, int, bool) {
 	return ws, p, true
 }
 
-// Mark Go string.
-// Valid strings always fit on one line.
+// Mark Go string, raw string or rune literal.
+// Raw strings may span multiple lines, so the text is scanned from its beginning.
 func markString(text []byte, point int) (int, int, bool) {
 	var s scanner.Scanner
 	fset := token.NewFileSet()
-	ls := lineStart(text, point)
-	file := fset.AddFile("", fset.Base(), len(text)-ls)
-	s.Init(file, text[ls:], nil, scanner.ScanComments)
+	file := fset.AddFile("", fset.Base(), len(text))
+	s.Init(file, text, nil, 0)
 	for {
 		pos, tok, lit := s.Scan()
-		if tok == token.EOF || tok == token.SEMICOLON && lit[0] == '\n' {
+		start := int(pos) - 1
+		if tok == token.EOF || start > point {
 			break
-		} else if tok == token.STRING {
-			s := ls + int(pos) - 1
-			e := s + len(lit)
-			if point >= s && point < e {
-				return s, e, true
+		}
+		if tok == token.STRING || tok == token.CHAR {
+			end := start + len(lit)
+			if point >= start && point < end {
+				return start, end, true
 			}
 		}
 	}
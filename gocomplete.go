@@ -0,0 +1,310 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Completion of struct fields and methods after a ".", using only what
+// go/parser can tell about the package of the buffer. There is no type
+// checking, so the type of the expression before the dot is guessed from its
+// declaration, which is good enough for the usual receivers, parameters,
+// variables and chains of fields and calls.
+
+// Members of the types declared in a package, keyed by type name. Each member
+// maps to the name of its type, or of the result type for methods, which is
+// empty if it's not a named type.
+type goPackage struct {
+	members map[string]map[string]string
+	// Embedded types of structs.
+	embedded map[string][]string
+	// Result types of functions and types of package variables.
+	funcs, vars map[string]string
+}
+
+// Name of the named type expr refers to, ignoring pointers.
+func goTypeName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.StarExpr:
+		return goTypeName(e.X)
+	case *ast.ParenExpr:
+		return goTypeName(e.X)
+	}
+	return ""
+}
+
+func goResultType(ft *ast.FuncType) string {
+	if ft.Results == nil || len(ft.Results.List) == 0 {
+		return ""
+	}
+	return goTypeName(ft.Results.List[0].Type)
+}
+
+func newGoPackage() *goPackage {
+	return &goPackage{
+		members:  make(map[string]map[string]string),
+		embedded: make(map[string][]string),
+		funcs:    make(map[string]string),
+		vars:     make(map[string]string),
+	}
+}
+
+func (pkg *goPackage) addMember(typ, name, t string) {
+	if pkg.members[typ] == nil {
+		pkg.members[typ] = make(map[string]string)
+	}
+	pkg.members[typ][name] = t
+}
+
+// Add the top-level declarations of f.
+func (pkg *goPackage) add(f *ast.File) {
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				pkg.addMember(goTypeName(d.Recv.List[0].Type), d.Name.Name, goResultType(d.Type))
+			} else {
+				pkg.funcs[d.Name.Name] = goResultType(d.Type)
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					pkg.addType(s)
+				case *ast.ValueSpec:
+					for i, n := range s.Names {
+						if s.Type != nil {
+							pkg.vars[n.Name] = goTypeName(s.Type)
+						} else if i < len(s.Values) {
+							if cl, ok := s.Values[i].(*ast.CompositeLit); ok {
+								pkg.vars[n.Name] = goTypeName(cl.Type)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func (pkg *goPackage) addType(s *ast.TypeSpec) {
+	name := s.Name.Name
+	switch t := s.Type.(type) {
+	case *ast.StructType:
+		for _, f := range t.Fields.List {
+			if len(f.Names) == 0 {
+				e := goTypeName(f.Type)
+				pkg.embedded[name] = append(pkg.embedded[name], e)
+				pkg.addMember(name, e, e)
+			}
+			for _, n := range f.Names {
+				pkg.addMember(name, n.Name, goTypeName(f.Type))
+			}
+		}
+	case *ast.InterfaceType:
+		for _, m := range t.Methods.List {
+			for _, n := range m.Names {
+				if ft, ok := m.Type.(*ast.FuncType); ok {
+					pkg.addMember(name, n.Name, goResultType(ft))
+				}
+			}
+		}
+	}
+}
+
+// Members of typ, including the promoted ones of embedded types.
+func (pkg *goPackage) allMembers(typ string, seen map[string]bool) map[string]string {
+	res := make(map[string]string)
+	if seen[typ] {
+		return res
+	}
+	seen[typ] = true
+	for _, e := range pkg.embedded[typ] {
+		for n, t := range pkg.allMembers(e, seen) {
+			res[n] = t
+		}
+	}
+	for n, t := range pkg.members[typ] {
+		res[n] = t
+	}
+	return res
+}
+
+// Guess the name of the type of expr.
+func (pkg *goPackage) typeOf(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		if e.Obj != nil {
+			return pkg.typeOfObject(e)
+		}
+		if _, ok := pkg.members[e.Name]; ok {
+			return e.Name
+		}
+		return pkg.vars[e.Name]
+	case *ast.SelectorExpr:
+		return pkg.allMembers(pkg.typeOf(e.X), map[string]bool{})[e.Sel.Name]
+	case *ast.CallExpr:
+		if id, ok := e.Fun.(*ast.Ident); ok {
+			if t, ok := pkg.funcs[id.Name]; ok {
+				return t
+			}
+		}
+		// Methods, and conversions to named types.
+		return pkg.typeOf(e.Fun)
+	case *ast.CompositeLit:
+		return goTypeName(e.Type)
+	case *ast.UnaryExpr:
+		return pkg.typeOf(e.X)
+	case *ast.StarExpr:
+		return pkg.typeOf(e.X)
+	case *ast.ParenExpr:
+		return pkg.typeOf(e.X)
+	}
+	return ""
+}
+
+// Guess the type of a local identifier from its declaration.
+func (pkg *goPackage) typeOfObject(id *ast.Ident) string {
+	switch d := id.Obj.Decl.(type) {
+	case *ast.TypeSpec:
+		return d.Name.Name
+	case *ast.Field:
+		return goTypeName(d.Type)
+	case *ast.ValueSpec:
+		if d.Type != nil {
+			return goTypeName(d.Type)
+		}
+		for i, n := range d.Names {
+			if n.Name == id.Name && i < len(d.Values) {
+				return pkg.typeOf(d.Values[i])
+			}
+		}
+	case *ast.AssignStmt:
+		for i, l := range d.Lhs {
+			if n, ok := l.(*ast.Ident); ok && n.Name == id.Name && len(d.Rhs) == len(d.Lhs) {
+				return pkg.typeOf(d.Rhs[i])
+			}
+		}
+	}
+	return ""
+}
+
+// Parse the other files of the package in dir.
+func (pkg *goPackage) addDir(dir, name, skip string) {
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	for _, path := range paths {
+		if absPath(path) == skip {
+			continue
+		}
+		src, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if f, _ := parser.ParseFile(token.NewFileSet(), path, src, 0); f != nil && f.Name.Name == name {
+			pkg.add(f)
+		}
+	}
+}
+
+// Return the members that can follow the dot before off in the Go source
+// text, along with the offset where the already typed name starts.
+func goMemberCompletions(text []byte, off int, path string) (start int, names []string, err error) {
+	start = off
+	for start > 0 {
+		r, s := utf8.DecodeLastRune(text[:start])
+		if !isIdentRune(r) {
+			break
+		}
+		start -= s
+	}
+	if start == 0 || text[start-1] != '.' {
+		return off, nil, errors.New("not after a selector")
+	}
+	prefix := string(text[start:off])
+	// Make the selector complete, so it parses.
+	src := append(append(append([]byte(nil), text[:off]...), '_'), text[off:]...)
+	fset := token.NewFileSet()
+	f, _ := parser.ParseFile(fset, path, src, 0)
+	if f == nil {
+		return off, nil, errors.New("cannot parse the buffer")
+	}
+	var sel *ast.SelectorExpr
+	ast.Inspect(f, func(n ast.Node) bool {
+		if s, ok := n.(*ast.SelectorExpr); ok && fset.Position(s.Sel.Pos()).Offset == start {
+			sel = s
+		}
+		return sel == nil
+	})
+	if sel == nil {
+		return off, nil, errors.New("not after a selector")
+	}
+	pkg := newGoPackage()
+	if path != "" {
+		pkg.addDir(filepath.Dir(path), f.Name.Name, absPath(path))
+	}
+	pkg.add(f)
+	typ := pkg.typeOf(sel.X)
+	if typ == "" {
+		return off, nil, fmt.Errorf("unknown type of %s", text[fset.Position(sel.X.Pos()).Offset:start-1])
+	}
+	for n := range pkg.allMembers(typ, map[string]bool{}) {
+		if strings.HasPrefix(n, prefix) && n != "_" {
+			names = append(names, n)
+		}
+	}
+	if len(names) == 0 {
+		return off, nil, fmt.Errorf("no members of %s starting with %q", typ, prefix)
+	}
+	sort.Strings(names)
+	return start, names, nil
+}
+
+// Complete the name of a struct field or method after a dot, picking from
+// a helm if there are more candidates.
+func completeGoMember(med *Med, file *File) {
+	start, names, err := goMemberCompletions(file.text.Bytes(), file.point.Off, file.path)
+	if err != nil {
+		med.pushError(err)
+		return
+	}
+	end := file.point.Off
+	insert := func(name string) {
+		file.Delete(start, end)
+		file.Insert([]byte(name))
+	}
+	if len(names) == 1 {
+		insert(names[0])
+		return
+	}
+	mode := med.mode
+	update := func() {}
+	finish := func(cancel bool) {
+		med.mode = mode
+		if cancel || len(med.dialog.helm.data) == 0 {
+			return
+		}
+		insert(med.dialog.helm.data[max(0, med.dialog.helm.index)])
+	}
+	complete := func() {
+		var data []string
+		for _, n := range names {
+			if strings.HasPrefix(n, string(med.dialog.file.text.Bytes())) {
+				data = append(data, n)
+			}
+		}
+		med.dialog.helm.data = data
+	}
+	med.startDialog("member", update, finish, NewHelm(complete))
+	med.dialog.file.Insert(file.text.Slice(start, end))
+	med.dialog.update()
+}
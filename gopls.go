@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Completion of Go code at the point by gopls. A gopls is started for every
+// workspace, the project of the file or its directory, and kept running, so
+// it loads the packages only once. Requests run as jobs, so the editor doesn't
+// wait for them. The candidates pop up at the point like the ones of
+// autoComplete: Up and Down pick one and Tab inserts it. Definitions and
+// references of the symbol at the point are found the same way.
+
+// Time gopls is given to answer. If it takes longer, it's stopped and the
+// next request starts a new one.
+const goplsTimeout = 30 * time.Second
+
+type lspCompletionItem struct {
+	Label      string `json:"label"`
+	InsertText string `json:"insertText"`
+	TextEdit   *struct {
+		NewText string `json:"newText"`
+	} `json:"textEdit"`
+}
+
+// A running gopls.
+type goplsSession struct {
+	cmd    *exec.Cmd
+	w      io.Closer
+	client *lspClient
+	stderr *syncBuffer
+	// Versions of the documents opened, by URI.
+	versions map[string]int
+	killed   sync.Once
+}
+
+// Buffer safe to write from the goroutine copying the standard error output of
+// a command while it's read.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Return what was written since the last call.
+func (b *syncBuffer) Take() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := append([]byte(nil), b.buf.Bytes()...)
+	b.buf.Reset()
+	return s
+}
+
+var (
+	// Sessions by workspace directory.
+	goplsSessions  = make(map[string]*goplsSession)
+	goplsSessionMu sync.Mutex
+)
+
+// Directory of the workspace gopls is started in for the file at path.
+func goplsWorkspace(path string) string {
+	if root := projectRoot(path); root != "" {
+		return root
+	}
+	return filepath.Dir(path)
+}
+
+// Start gopls in dir and initialize it.
+func startGopls(dir string) (*goplsSession, error) {
+	cmd := exec.Command("gopls")
+	cmd.Dir = dir
+	stderr := new(syncBuffer)
+	cmd.Stderr = stderr
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	s := &goplsSession{
+		cmd:      cmd,
+		w:        w,
+		client:   &lspClient{w: w, r: bufio.NewReader(r)},
+		stderr:   stderr,
+		versions: make(map[string]int),
+	}
+	err = s.call("initialize", map[string]interface{}{
+		"processId":    nil,
+		"rootUri":      lspURI(dir),
+		"capabilities": map[string]interface{}{},
+	}, nil)
+	if err != nil {
+		s.kill()
+		return nil, err
+	}
+	s.client.notify("initialized", map[string]interface{}{})
+	return s, nil
+}
+
+// Make a request, stopping gopls if it doesn't answer in goplsTimeout.
+func (s *goplsSession) call(method string, params, result interface{}) error {
+	t := time.AfterFunc(goplsTimeout, s.kill)
+	err := s.client.call(method, params, result)
+	if !t.Stop() {
+		err = errors.New(method + ": gopls timed out")
+	}
+	if err != nil {
+		return goplsError(err, s.stderr.Take())
+	}
+	return nil
+}
+
+// Stop gopls and wait for it to exit.
+func (s *goplsSession) kill() {
+	s.killed.Do(func() {
+		s.cmd.Process.Kill()
+		s.w.Close()
+		s.cmd.Wait()
+	})
+}
+
+// Ask gopls to exit and wait for it.
+func (s *goplsSession) shutdown() {
+	t := time.AfterFunc(time.Second, func() { s.cmd.Process.Kill() })
+	defer t.Stop()
+	if s.client.call("shutdown", nil, nil) == nil {
+		s.client.notify("exit", nil)
+	}
+	s.w.Close()
+	s.cmd.Wait()
+}
+
+// Stop every gopls started, when the editor exits.
+func shutdownGopls() {
+	goplsSessionMu.Lock()
+	defer goplsSessionMu.Unlock()
+	for dir, s := range goplsSessions {
+		s.shutdown()
+		delete(goplsSessions, dir)
+	}
+}
+
+// Make a request about the Go source text of the file at path to the gopls of
+// its workspace, starting it if needed. Params get the document added, result
+// gets the result.
+func goplsRequest(path string, text []byte, method string, params map[string]interface{}, result interface{}) error {
+	goplsSessionMu.Lock()
+	defer goplsSessionMu.Unlock()
+	dir := goplsWorkspace(path)
+	s := goplsSessions[dir]
+	if s == nil {
+		var err error
+		if s, err = startGopls(dir); err != nil {
+			return err
+		}
+		goplsSessions[dir] = s
+	}
+	uri := lspURI(path)
+	// The text the document had when it was last sent doesn't matter, the
+	// whole of it is sent again.
+	var err error
+	if v, ok := s.versions[uri]; !ok {
+		err = s.client.notify("textDocument/didOpen", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri, "languageId": "go", "version": 1, "text": string(text)},
+		})
+		s.versions[uri] = 1
+	} else {
+		s.versions[uri] = v + 1
+		err = s.client.notify("textDocument/didChange", map[string]interface{}{
+			"textDocument":   map[string]interface{}{"uri": uri, "version": v + 1},
+			"contentChanges": []interface{}{map[string]interface{}{"text": string(text)}},
+		})
+	}
+	params["textDocument"] = map[string]interface{}{"uri": uri}
+	if err == nil {
+		err = s.call(method, params, result)
+	}
+	if err != nil {
+		// Start over next time, gopls may be stuck or gone.
+		s.kill()
+		delete(goplsSessions, dir)
+	}
+	return err
+}
+
+// Ask gopls for the completions of the Go source text of the file at path at
+// pos.
+func goplsCompletions(path string, text []byte, pos lspPosition) ([]string, error) {
+	var result json.RawMessage
+	err := goplsRequest(path, text, "textDocument/completion", map[string]interface{}{"position": pos}, &result)
+	if err != nil {
+		return nil, err
+	}
+	// The result is either a list of items or an object holding them.
+	var items []lspCompletionItem
+	if json.Unmarshal(result, &items) != nil {
+		var list struct {
+			Items []lspCompletionItem `json:"items"`
+		}
+		json.Unmarshal(result, &list)
+		items = list.Items
+	}
+	var words []string
+	for _, it := range items {
+		switch {
+		case it.TextEdit != nil:
+			words = append(words, it.TextEdit.NewText)
+		case it.InsertText != "":
+			words = append(words, it.InsertText)
+		default:
+			words = append(words, it.Label)
+		}
+	}
+	return words, nil
+}
+
+// Ask gopls for the locations that method, like textDocument/definition,
+// finds for the Go source text of the file at path at pos.
+func goplsLocations(path string, text []byte, method string, params map[string]interface{}) ([]Location, error) {
+	var result json.RawMessage
+	if err := goplsRequest(path, text, method, params, &result); err != nil {
+		return nil, err
+	}
+	// The result is either a list of locations or a single one.
+	var ls []lspLocation
+	if json.Unmarshal(result, &ls) != nil {
+		var l lspLocation
+		if json.Unmarshal(result, &l) == nil && l.URI != "" {
+			ls = append(ls, l)
+		}
+	}
+	return lspLocations(ls, path, text), nil
+}
+
+// Add what gopls said on the standard error output to err.
+func goplsError(err error, stderr []byte) error {
+	if s := bytes.TrimSpace(stderr); len(s) > 0 {
+		return errors.New(err.Error() + ": " + string(s))
+	}
+	return err
+}
+
+// Pop up the completions by gopls at the point.
+func completeGopls(med *Med, file *File) {
+	if fileType(file.path) != "go" {
+		med.pushError(errors.New("not a Go file"))
+		return
+	}
+	if med.jobRunning("gopls") {
+		return
+	}
+	path, err := filepath.Abs(file.path)
+	if err != nil {
+		med.pushError(err)
+		return
+	}
+	text := append([]byte(nil), file.text.Bytes()...)
+	off := file.point.Off
+	pos := lspPositionOf(file, off)
+	var words []string
+	run := func() ([]byte, error) {
+		var err error
+		words, err = goplsCompletions(path, text, pos)
+		return nil, err
+	}
+	done := func(out []byte, err error) {
+		if err != nil {
+			med.pushError(err)
+			return
+		}
+		// Never mind if the point moved or the text changed meanwhile.
+		if med.file.Value.(*File) != file || file.point.Off != off || !bytes.Equal(file.text.Bytes(), text) {
+			return
+		}
+		if len(words) == 0 {
+			med.showMessage("no completions")
+			return
+		}
+		med.completion = &Completion{start: wordStart(file.text.Bytes(), off), words: words, popup: NewPointPopup(file, words)}
+		med.popup = med.completion.popup
+	}
+	med.startJob("gopls", run, done)
+}
+
+// Run a gopls request for the locations of the symbol at the point and pass
+// them to done.
+func (med *Med) goplsFind(file *File, method string, params map[string]interface{}, done func([]Location)) {
+	if fileType(file.path) != "go" {
+		med.pushError(errors.New("not a Go file"))
+		return
+	}
+	if med.jobRunning("gopls") {
+		return
+	}
+	path, err := filepath.Abs(file.path)
+	if err != nil {
+		med.pushError(err)
+		return
+	}
+	text := append([]byte(nil), file.text.Bytes()...)
+	params["position"] = lspPositionOf(file, file.point.Off)
+	var locs []Location
+	run := func() ([]byte, error) {
+		var err error
+		locs, err = goplsLocations(path, text, method, params)
+		return nil, err
+	}
+	med.startJob("gopls", run, func(out []byte, err error) {
+		if err != nil {
+			med.pushError(err)
+			return
+		}
+		if len(locs) == 0 {
+			med.showMessage("nothing found")
+			return
+		}
+		done(locs)
+	})
+}
+
+// Jump to the definition of the Go symbol at the point. Use jumpBack to
+// return.
+func gotoDefinition(med *Med, file *File) {
+	med.goplsFind(file, "textDocument/definition", map[string]interface{}{}, func(locs []Location) {
+		med.gotoLocation(locs[0])
+	})
+}
+
+// Pick one of the references to the Go symbol at the point in a helm. They
+// can be stepped through with nextLocation and prevLocation, too.
+func listReferences(med *Med, file *File) {
+	params := map[string]interface{}{
+		"context": map[string]interface{}{"includeDeclaration": false},
+	}
+	med.goplsFind(file, "textDocument/references", params, func(locs []Location) {
+		med.setLocations(locs)
+		med.locationsDialog("references", locs)
+	})
+}
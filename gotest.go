@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/jsynacek/med/buffer"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Go tests run as a job. Failures are parsed into the locations, so they can
+// be stepped through with nextLocation and prevLocation. The whole output is
+// in the "go test" buffer.
+
+var testFuncRegexp = regexp.MustCompile(`^func ((?:Test|Benchmark|Example|Fuzz)\w*)\(`)
+
+// Return the name of the test function the point is in.
+func testFunc(text []byte, off int) (string, bool) {
+	for ls := buffer.LineStart(text, off); ; ls = buffer.LineStart(text, ls-1) {
+		if m := testFuncRegexp.FindSubmatch(text[ls:buffer.LineEnd(text, ls)]); m != nil {
+			return string(m[1]), true
+		}
+		if ls == 0 {
+			return "", false
+		}
+	}
+}
+
+// Parse failures from go test output of commands run in dir. Test failures
+// only report the base name of the file, which is resolved using pkgDirs,
+// mapping import paths to package directories, once the package is reported
+// to have failed.
+func parseTestOutput(dir string, out []byte, pkgDirs map[string]string) (locs []Location) {
+	var pending []Location
+	flush := func(pkgDir string) {
+		for _, loc := range pending {
+			loc.path = filepath.Join(pkgDir, loc.path)
+			locs = append(locs, loc)
+		}
+		pending = nil
+	}
+	for _, l := range strings.Split(string(out), "\n") {
+		if f := strings.Fields(l); len(f) >= 2 && (f[0] == "FAIL" || f[0] == "ok") {
+			if pkgDir, ok := pkgDirs[f[1]]; ok {
+				flush(pkgDir)
+			}
+			continue
+		}
+		loc, ok := parseLocation(strings.TrimSpace(l))
+		if !ok || !strings.HasSuffix(loc.path, ".go") {
+			continue
+		}
+		switch {
+		case filepath.IsAbs(loc.path):
+			locs = append(locs, loc)
+		case strings.Contains(loc.path, "/"):
+			// Compiler errors are relative to the directory go was run in.
+			loc.path = filepath.Join(dir, loc.path)
+			locs = append(locs, loc)
+		default:
+			pending = append(pending, loc)
+		}
+	}
+	flush(dir)
+	return
+}
+
+// Return directories of packages matching pattern, keyed by import path.
+func packageDirs(dir, pattern string) map[string]string {
+	cmd := exec.Command("go", "list", "-e", "-f", "{{.ImportPath}} {{.Dir}}", pattern)
+	cmd.Dir = dir
+	out, _ := cmd.Output()
+	dirs := make(map[string]string)
+	for _, l := range strings.Split(string(out), "\n") {
+		if f := strings.SplitN(l, " ", 2); len(f) == 2 {
+			dirs[f[0]] = f[1]
+		}
+	}
+	return dirs
+}
+
+// Run go test with args in dir as a job.
+func (med *Med) goTest(dir string, args ...string) {
+	if med.jobRunning("go test") {
+		med.pushError(errors.New("go test is already running"))
+		return
+	}
+	pattern := args[len(args)-1]
+	var pkgDirs map[string]string
+	run := func() ([]byte, error) {
+		pkgDirs = packageDirs(dir, pattern)
+		cmd := exec.Command("go", append([]string{"test"}, args...)...)
+		cmd.Dir = dir
+		return cmd.CombinedOutput()
+	}
+	done := func(out []byte, err error) {
+		locs := parseTestOutput(dir, out, pkgDirs)
+		med.setLocations(locs)
+		switch {
+		case err == nil:
+			med.showMessage("go test: ok")
+		case len(locs) > 0:
+			med.showMessage("go test: failed, %d locations", len(locs))
+		default:
+			if _, ok := err.(*exec.ExitError); !ok {
+				med.pushError(err)
+				return
+			}
+			lines := bytes.Split(bytes.TrimSpace(out), []byte("\n"))
+			med.showMessage("go test: %s", lines[len(lines)-1])
+		}
+	}
+	med.startJob("go test", run, done)
+}
+
+func (file *File) dir() string {
+	return absPath(filepath.Dir(file.path))
+}
+
+// Run all tests of the project, or of the directory of the file if it's not
+// in a project.
+func goTestAll(med *Med, file *File) {
+	dir := file.project
+	if dir == "" {
+		dir = file.dir()
+	}
+	med.goTest(dir, "./...")
+}
+
+// Run tests of the package of the file.
+func goTestPackage(med *Med, file *File) {
+	med.goTest(file.dir(), ".")
+}
+
+// Run the test function the point is in.
+func goTestFunc(med *Med, file *File) {
+	name, ok := testFunc(file.text.Bytes(), file.point.Off)
+	if !ok {
+		med.pushError(errors.New("not in a test function"))
+		return
+	}
+	med.goTest(file.dir(), "-run", fmt.Sprintf("^%s$", name), ".")
+}
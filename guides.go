@@ -0,0 +1,96 @@
+package main
+
+import (
+	"github.com/jsynacek/med/term"
+	"unicode/utf8"
+)
+
+// Indentation guides are vertical lines drawn over the leading whitespace of
+// the displayed lines, one for each level of indentation, if indentGuides is
+// set. A level is a tab stop for lines indented with tabs, otherwise the
+// smallest indentation by spaces in the view.
+
+// Visual width of the leading whitespace of the line at off and whether it
+// contains tabs.
+func (view *View) indentWidth(text []byte, off int) (w int, tabs bool) {
+	for ; off < len(text); off++ {
+		switch text[off] {
+		case ' ':
+			w++
+		case '\t':
+			w += view.visual.tabStop - w%view.visual.tabStop
+			tabs = true
+		default:
+			return
+		}
+	}
+	return
+}
+
+// Screen rows of the view that start a line, and the offsets of those lines.
+func (view *View) lineRows(text []byte) (rows, offs []int) {
+	p := view.start
+	for row := 0; row < view.height && p < len(text); row++ {
+		if f, ok := view.foldAt(p); ok {
+			p = f.end
+			continue
+		}
+		if p == 0 || text[p-1] == '\n' {
+			rows = append(rows, row)
+			offs = append(offs, p)
+		}
+		if next := view.lineEnd(text, p); next > p {
+			p = next
+		} else {
+			_, s := utf8.DecodeRune(text[p:])
+			p += s
+		}
+	}
+	return
+}
+
+func (view *View) displayGuides(t *term.Term, text []byte, point int) {
+	rows, offs := view.lineRows(text)
+	unit := 0
+	for _, off := range offs {
+		if w, tabs := view.indentWidth(text, off); !tabs && w > 0 && (unit == 0 || w < unit) {
+			unit = w
+		}
+	}
+	theme["indentGuide"].Out(t)
+	for i, off := range offs {
+		w, tabs := view.indentWidth(text, off)
+		u := unit
+		if tabs || u == 0 {
+			u = view.visual.tabStop
+		}
+		// A guide at every level but the first column, up to the text.
+		for c := u; c < w && c < view.width; c += u {
+			if view.offsetAtColumn(text, off, c) == point {
+				continue
+			}
+			t.MoveTo(view.top+rows[i], view.left+c)
+			t.Write([]byte(string(view.visual.guideChar)))
+		}
+	}
+	theme["normal"].Out(t)
+}
+
+// Offset of the character displayed in column c of the leading whitespace of
+// the line at off.
+func (view *View) offsetAtColumn(text []byte, off, c int) int {
+	for col := 0; off < len(text); off++ {
+		switch text[off] {
+		case ' ':
+			col++
+		case '\t':
+			col += view.visual.tabStop - col%view.visual.tabStop
+		default:
+			return off
+		}
+		if col > c {
+			return off
+		}
+	}
+	return off
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// File headers come from a header template for the file's extension, looked
+// up in .med/headers in the project root and then in the headers directory of
+// the configuration, like ~/.config/med/headers/go. The template is written
+// as the comment it produces, license text and all:
+//
+//	// {{file}}
+//	//
+//	// Copyright {{year}} The Authors. Licensed under the MIT license.
+//	// Last modified: {{date}}
+//
+// The placeholders are replaced with the base name of the file, the current
+// year and the current date. A header is recognized by matching the template
+// with any text in place of the placeholders, so it can be refreshed later.
+// To keep the date current, refresh it on every save:
+//
+//	hook before-save "*.go" updateHeader
+
+var headerFields = []string{"{{file}}", "{{year}}", "{{date}}"}
+
+// Read the header template for file.
+func (med *Med) headerTemplate(file *File) ([]byte, error) {
+	ext := fileType(file.path)
+	if ext == "" {
+		return nil, errors.New("no file type")
+	}
+	var dirs []string
+	if file.project != "" {
+		dirs = append(dirs, filepath.Join(file.project, ".med", "headers"))
+	}
+	dirs = append(dirs, filepath.Join(med.config.dir, "headers"))
+	for _, dir := range dirs {
+		if text, err := ioutil.ReadFile(filepath.Join(dir, ext)); err == nil {
+			return text, nil
+		}
+	}
+	return nil, errors.New("no header template for " + ext)
+}
+
+// Fill in the placeholders of the header template.
+func expandHeader(tmpl []byte, path, year string, now time.Time) []byte {
+	r := strings.NewReplacer(
+		"{{file}}", filepath.Base(path),
+		"{{year}}", year,
+		"{{date}}", now.Format("2006-01-02"))
+	return []byte(r.Replace(string(tmpl)))
+}
+
+// Match the header at the start of text. Return its length, or -1 if text
+// doesn't start with one, and the year it has, if any.
+func matchHeader(tmpl, text []byte) (n int, year string) {
+	expr := regexp.QuoteMeta(string(tmpl))
+	for _, f := range headerFields {
+		g := ".*?"
+		if f == "{{year}}" {
+			g = "(.*?)"
+		}
+		expr = strings.Replace(expr, regexp.QuoteMeta(f), g, -1)
+	}
+	re, err := regexp.Compile(`\A` + expr)
+	if err != nil {
+		return -1, ""
+	}
+	m := re.FindSubmatchIndex(text)
+	if m == nil {
+		return -1, ""
+	}
+	if len(m) > 2 && m[2] >= 0 {
+		year = string(text[m[2]:m[3]])
+	}
+	return m[1], year
+}
+
+// Refresh the header of file, or insert it if add is set and there is none.
+// The year of an existing header is kept, as it is usually the year of the
+// copyright.
+func (med *Med) applyHeader(file *File, add bool) {
+	if file.readOnly {
+		return
+	}
+	tmpl, err := med.headerTemplate(file)
+	if err != nil {
+		if add {
+			med.pushError(err)
+		}
+		return
+	}
+	now := time.Now()
+	n, year := matchHeader(tmpl, file.text.Bytes())
+	if n < 0 && !add {
+		return
+	}
+	if n < 0 {
+		n, year = 0, now.Format("2006")
+	}
+	header := expandHeader(tmpl, file.path, year, now)
+	if bytes.Equal(file.text.Slice(0, n), header) {
+		return
+	}
+	off := file.point.Off
+	if off >= n {
+		off += len(header) - n
+	}
+	file.BeginUndoGroup()
+	if n > 0 {
+		file.Delete(0, n)
+	}
+	file.Goto(0)
+	file.Insert(header)
+	file.EndUndoGroup()
+	file.Goto(off)
+}
+
+// Insert the header at the start of the file, or refresh it if it is there.
+func insertHeader(med *Med, file *File) {
+	med.applyHeader(file, true)
+}
+
+// Refresh the header, if the file has one.
+func updateHeader(med *Med, file *File) {
+	med.applyHeader(file, false)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Local history keeps a timestamped copy of every saved version of a file
+// in ~/.cache/med/history/<hash of the absolute path>/.
+
+const historyTimeFormat = "2006-01-02T15:04:05.000"
+
+func cacheDir() string {
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return filepath.Join(dir, "med")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".cache", "med")
+}
+
+func historyDir(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return filepath.Join(cacheDir(), "history", fmt.Sprintf("%x", sha1.Sum([]byte(path))))
+}
+
+func historySave(path string, text []byte) error {
+	dir := historyDir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, time.Now().Format(historyTimeFormat)), text, 0600)
+}
+
+// Saved versions of the file at path, newest first.
+func historyVersions(path string) []string {
+	files, _ := ioutil.ReadDir(historyDir(path))
+	var versions []string
+	for _, fi := range files {
+		versions = append(versions, fi.Name())
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(versions)))
+	return versions
+}
+
+// Start a dialog to pick a saved version of the file and call fn with its text.
+func (med *Med) historyDialog(file *File, fn func(version string, text []byte)) {
+	if file.path == "" {
+		med.pushError(errors.New("buffer has no file"))
+		return
+	}
+	update := func() {}
+	finish := func(cancel bool) {
+		if cancel {
+			return
+		}
+		version := string(med.dialog.file.text.Bytes())
+		text, err := ioutil.ReadFile(filepath.Join(historyDir(file.path), version))
+		if err != nil {
+			med.pushError(err)
+			return
+		}
+		fn(version, text)
+	}
+	complete := func() {
+		var data []string
+		for _, v := range historyVersions(file.path) {
+			if strings.Contains(v, string(med.dialog.file.text.Bytes())) {
+				data = append(data, v)
+			}
+		}
+		med.dialog.helm.data = data
+	}
+	med.startDialog("history", update, finish, NewHelm(complete))
+}
+
+// Open a saved version of the file and diff it against the buffer.
+func historyBrowse(med *Med, file *File) {
+	med.historyDialog(file, func(version string, text []byte) {
+		cur := med.file
+		med.NewScratchBuffer(file.name+"@"+version, text).readOnly = true
+		med.diff = &Diff{a: cur, b: med.file}
+		med.windows = []*list.Element{cur, med.file}
+		med.window = 0
+		med.diff.update()
+	})
+}
+
+// Replace the buffer with a saved version of the file.
+func historyRestore(med *Med, file *File) {
+	med.historyDialog(file, func(version string, text []byte) {
+		file.ReplaceRange(0, file.text.Len(), text)
+	})
+}
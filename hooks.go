@@ -0,0 +1,258 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/jsynacek/med/buffer"
+	"path/filepath"
+	"strings"
+)
+
+// Buffer-local variables and hooks are set up in the config file:
+//
+//	local "*.go" tabStop 4
+//	local ~/src/project/ buildCommand "make"
+//	hook before-save "*.go" stripTrailingSpace
+//	hook filetype md switchVisuals
+//
+// Patterns are globs matched against the base name or the absolute path of
+// the file. A pattern ending with a slash matches every file in that directory
+// and below, which makes per-project settings possible. Hooks run a command on
+// the buffer when the event happens. For the filetype event, the pattern is
+// matched against the file type, which is the file name extension. For the
+// before-command and after-command events, which happen around every command
+// run by a key, it is matched against the command name:
+//
+//	hook after-command "save*" switchVisuals
+//	hook before-command saveFile samCommand ",x/ +$/d"
+//
+// Like with bind, arguments after the command are passed to it. While the
+// command hooks run, med.command is the command line of the command they run
+// around, with its arguments.
+//
+// The idle event happens for the current buffer when no key has been pressed
+// for idleTime milliseconds.
+
+var hookEvents = []string{"load", "filetype", "before-save", "after-save", "before-command", "after-command", "idle"}
+
+type Hook struct {
+	event   string
+	pattern string
+	command func(*Med, *File)
+	args    []string
+}
+
+type Local struct {
+	pattern     string
+	name, value string
+}
+
+func fileType(path string) string {
+	return strings.TrimPrefix(filepath.Ext(path), ".")
+}
+
+func matchFile(pattern, path string) bool {
+	if path == "" {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	pattern = expandHome(pattern)
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(abs, pattern)
+	}
+	m1, _ := filepath.Match(pattern, filepath.Base(path))
+	m2, _ := filepath.Match(pattern, abs)
+	return m1 || m2
+}
+
+func parseHook(f []string) (Hook, error) {
+	if len(f) < 4 {
+		return Hook{}, fmt.Errorf("expected hook event pattern command")
+	}
+	known := false
+	for _, e := range hookEvents {
+		known = known || e == f[1]
+	}
+	if !known {
+		return Hook{}, fmt.Errorf("unknown hook event: %s", f[1])
+	}
+	command, ok := commands[f[3]]
+	if !ok {
+		return Hook{}, fmt.Errorf("unknown command: %s", f[3])
+	}
+	return Hook{f[1], f[2], command, f[4:]}, nil
+}
+
+func parseLocal(f []string) (Local, error) {
+	if len(f) != 4 {
+		return Local{}, fmt.Errorf("expected local pattern name value")
+	}
+	return Local{f[1], f[2], f[3]}, nil
+}
+
+// Var returns the value of a buffer-local variable.
+func (file *File) Var(name string) (string, bool) {
+	v, ok := file.vars[name]
+	return v, ok
+}
+
+// Set buffer-local variables of file from the configuration.
+func (med *Med) applyLocals(file *File) {
+	file.vars = make(map[string]string)
+	for _, l := range med.config.locals {
+		if matchFile(l.pattern, file.path) {
+			file.vars[l.name] = l.value
+		}
+	}
+	// The project config has the last word.
+	if file.project = projectRoot(file.path); file.project != "" {
+		vars, err := projectConfig(file.project)
+		if err != nil {
+			med.pushError(err)
+		}
+		if !med.config.trusts(file.project) {
+			var ignored []string
+			for _, name := range commandSettings {
+				if _, ok := vars[name]; ok {
+					ignored = append(ignored, name)
+					delete(vars, name)
+				}
+			}
+			if len(ignored) > 0 {
+				med.pushError(fmt.Errorf("%s is not trusted, ignoring its %s", file.project, strings.Join(ignored, ", ")))
+			}
+		}
+		for name, value := range vars {
+			file.vars[name] = value
+		}
+	}
+	// Options that are kept per buffer take effect immediately.
+	file.tabStop = tabStop
+	if v, ok := file.vars["tabStop"]; ok {
+		fmt.Sscan(v, &file.tabStop)
+	}
+	file.view.visual.tabStop = file.tabStop
+}
+
+// Run hooks for event on file.
+func (med *Med) runHooks(event string, file *File) {
+	name := file.path
+	if event == "filetype" {
+		name = fileType(file.path)
+	}
+	for _, h := range med.config.hooks {
+		if h.event != event {
+			continue
+		}
+		if event == "filetype" && h.pattern == name || event != "filetype" && matchFile(h.pattern, name) {
+			med.call(h.command, h.args, file)
+			file.UndoBlock()
+		}
+	}
+}
+
+// Run a command line, the name of a command in the commands map followed by
+// its arguments, with the command hooks around it.
+func (med *Med) runCommand(line string, file *File) {
+	f, err := splitConfigLine(line)
+	if err != nil || len(f) == 0 {
+		med.pushError(fmt.Errorf("bad command: %s", line))
+		return
+	}
+	command, ok := commands[f[0]]
+	if !ok {
+		med.pushError(fmt.Errorf("unknown command: %s", f[0]))
+		return
+	}
+	med.command = line
+	med.runCommandHooks("before-command", f[0], file)
+	med.call(command, f[1:], file)
+	med.runCommandHooks("after-command", f[0], file)
+	med.command = ""
+}
+
+// Run hooks for a command event on file.
+func (med *Med) runCommandHooks(event, name string, file *File) {
+	for _, h := range med.config.hooks {
+		if h.event != event {
+			continue
+		}
+		if m, _ := filepath.Match(h.pattern, name); m {
+			med.call(h.command, h.args, file)
+		}
+	}
+}
+
+// Run command with args, which it finds in med.args.
+func (med *Med) call(command func(*Med, *File), args []string, file *File) {
+	// Commands run by hooks of commands have their own arguments.
+	saved := med.args
+	med.args = args
+	command(med, file)
+	med.args = saved
+}
+
+// Add a loaded file to the buffer list, set it up and make it current.
+func (med *Med) addFile(file *File) *list.Element {
+	med.applyLocals(file)
+	if keepPositions && file.path != "" {
+		restorePosition(file)
+		restoreFolds(file)
+	}
+	med.applyTemplate(file)
+	if file.checkLarge(); !file.large {
+		med.checkNormalization(file)
+		med.checkInvisible(file)
+	}
+	e := med.files.PushBack(file)
+	med.file = e
+	med.watcher.watch(file.path)
+	med.runHooks("load", file)
+	med.runHooks("filetype", file)
+	return e
+}
+
+// Save file, running the save hooks around it.
+func (med *Med) saveBuffer(file *File) error {
+	if !file.modified {
+		return nil
+	}
+	if normalizeUnicode {
+		file.normalize()
+	}
+	med.runHooks("before-save", file)
+	if err := file.Save(); err != nil {
+		return err
+	}
+	med.watcher.watch(file.path)
+	med.runHooks("after-save", file)
+	if checkOnSave && fileType(file.path) == "go" {
+		med.checkGo(file)
+	}
+	return nil
+}
+
+// Strip trailing whitespace from all lines.
+func stripTrailingSpace(med *Med, file *File) {
+	off := file.point.Off
+	for le := file.text.Len(); le >= 0; {
+		ls := buffer.LineStart(file.text.Bytes(), le)
+		e := le
+		for e > ls && (file.text.Bytes()[e-1] == ' ' || file.text.Bytes()[e-1] == '\t') {
+			e--
+		}
+		if e < le {
+			file.Delete(e, le)
+			if off > le {
+				off -= le - e
+			} else if off > e {
+				off = e
+			}
+		}
+		le = ls - 1
+	}
+	file.Goto(off)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"container/list"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommandHooks(t *testing.T) {
+	var ran []string
+	commands["testRecord"] = func(med *Med, file *File) {
+		ran = append(ran, med.command+": "+strings.Join(med.args, " "))
+	}
+	defer delete(commands, "testRecord")
+	var hooks []Hook
+	for _, f := range [][]string{
+		{"hook", "before-command", "point*", "testRecord", "before"},
+		{"hook", "after-command", "pageDown", "testRecord", "after", "page"},
+		{"hook", "after-command", "gotoLine", "testRecord"},
+	} {
+		h, err := parseHook(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hooks = append(hooks, h)
+	}
+	med := &Med{
+		files:  list.New(),
+		errors: list.New(),
+		config: &Config{hooks: hooks},
+	}
+	file := NewFile("test", "", []byte(strings.Repeat("line\n", 100)))
+	med.file = med.files.PushBack(file)
+	keymap := editorKeymaps[CommandMode]
+	defer func() { editorKeymaps[CommandMode] = keymap }()
+	editorKeymaps[CommandMode] = joinKeybinds(Keybind{"ZZ", "gotoLine 42"}, keymap)
+	// Movement commands are wrapped, which once made them all look like one.
+	for _, keys := range []string{"k", "/", kPageDown, "K", "Z", "Z"} {
+		med.handleKey([]byte(keys))
+	}
+	want := []string{
+		"pointDown: before",
+		"pageDown: after page",
+		"pageDown: after page",
+		"gotoLine 42: ",
+	}
+	if !reflect.DeepEqual(ran, want) {
+		t.Errorf("hooks ran %q, want %q", ran, want)
+	}
+	if line := file.point.Line + 1; line != 42 {
+		t.Errorf("gotoLine 42 went to line %d", line)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"time"
+)
+
+// Idle tasks run in the main loop once no key has been pressed for idleTime
+// milliseconds, so background work doesn't slow down typing. Each task runs
+// at most once per idle period.
+
+type IdleTask struct {
+	name string
+	run  func(med *Med)
+}
+
+func (med *Med) addIdleTask(name string, run func(med *Med)) {
+	med.idleTasks = append(med.idleTasks, IdleTask{name, run})
+}
+
+// Return a channel that fires when the editor becomes idle, or nil if the
+// idle tasks already ran since the last key press or the terminal is not
+// focused.
+func (med *Med) idleTimer() <-chan time.Time {
+	if med.idle || med.unfocused {
+		return nil
+	}
+	return time.After(time.Until(med.lastKey.Add(time.Duration(idleTime) * time.Millisecond)))
+}
+
+func (med *Med) runIdleTasks() {
+	med.idle = true
+	for _, task := range med.idleTasks {
+		task.run(med)
+	}
+}
+
+// Note a key press, which ends the idle period.
+func (med *Med) keyPressed() {
+	med.lastKey = time.Now()
+	med.idle = false
+}
+
+// Save modified buffers if autoSave is set.
+func autoSaveTask(med *Med) {
+	if autoSave {
+		saveAll(med, nil)
+	}
+}
+
+// Run the idle hooks on the current buffer.
+func idleHooksTask(med *Med) {
+	med.runHooks("idle", med.file.Value.(*File))
+}
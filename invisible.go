@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/jsynacek/med/buffer"
+	"unicode/utf8"
+)
+
+// Invisible characters, like bidirectional overrides and zero-width spaces,
+// can make code read differently from what it does. They are displayed as
+// a marked character and loading a file with them shows a warning.
+
+// Offsets of the invisible characters of text.
+func invisibleChars(text []byte) (offs []int) {
+	for i := 0; i < len(text); {
+		r, s := utf8.DecodeRune(text[i:])
+		if buffer.Invisible(r) {
+			offs = append(offs, i)
+		}
+		i += s
+	}
+	return
+}
+
+func (med *Med) checkInvisible(file *File) {
+	if n := len(invisibleChars(file.text.Bytes())); n > 0 {
+		med.showMessage("%s has %d invisible characters, see stripInvisible", file.name, n)
+	}
+}
+
+// Delete invisible characters in the selection, or the whole buffer.
+func stripInvisible(med *Med, file *File) {
+	start, end := 0, file.text.Len()
+	if med.selection.active {
+		start, end = med.selectionRange(file)
+	}
+	offs := invisibleChars(file.text.Slice(start, end))
+	off := file.point.Off
+	for i := len(offs) - 1; i >= 0; i-- {
+		p := start + offs[i]
+		_, s := utf8.DecodeRune(file.text.Bytes()[p:])
+		file.Delete(p, p+s)
+		if off > p {
+			off -= min(s, off-p)
+		}
+	}
+	file.Goto(off)
+	med.showMessage("%d invisible characters deleted", len(offs))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"container/list"
+	"os/exec"
+)
+
+// Jobs run external commands in the background, so the editor stays
+// responsive while they run. When a job finishes, its output is put into an
+// output buffer named after the job and its done function is called from the
+// main loop. Output of streaming jobs is appended to the output buffer as it
+// comes.
+
+type Job struct {
+	name string
+	run  func() ([]byte, error)
+	done func(out []byte, err error)
+	out  []byte
+	err  error
+	// Output buffer of a streaming job.
+	file *File
+}
+
+// Output of a streaming job, appended to its output buffer in the main loop.
+type JobOutput struct {
+	job  *Job
+	text []byte
+}
+
+type jobWriter struct {
+	med *Med
+	job *Job
+}
+
+func (w jobWriter) Write(p []byte) (int, error) {
+	w.med.events <- JobOutput{w.job, append([]byte(nil), p...)}
+	return len(p), nil
+}
+
+func (med *Med) startJob(name string, run func() ([]byte, error), done func(out []byte, err error)) {
+	med.runJob(&Job{name: name, run: run, done: done})
+}
+
+func (med *Med) runJob(job *Job) {
+	med.jobs = append(med.jobs, job)
+	go func() {
+		job.out, job.err = job.run()
+		med.events <- job
+	}()
+}
+
+// Run cmd as a job, streaming its output into the output buffer called name,
+// which is returned.
+func (med *Med) startStreamJob(name string, cmd *exec.Cmd, done func(err error)) *list.Element {
+	e := med.outputBuffer(name, nil)
+	job := &Job{name: name, file: e.Value.(*File)}
+	cmd.Stdout = jobWriter{med, job}
+	cmd.Stderr = cmd.Stdout
+	job.run = func() ([]byte, error) {
+		return nil, cmd.Run()
+	}
+	job.done = func(out []byte, err error) {
+		done(err)
+	}
+	med.runJob(job)
+	return e
+}
+
+func (med *Med) appendOutput(o JobOutput) {
+	f := o.job.file
+	if f.shell != nil {
+		f.shell.output(f, o.text)
+		return
+	}
+	f.readOnly = false
+	f.Append(o.text)
+	f.readOnly, f.modified = true, false
+}
+
+// Called from the main loop when job finishes.
+func (med *Med) finishJob(job *Job) {
+	for i, j := range med.jobs {
+		if j == job {
+			med.jobs = append(med.jobs[:i], med.jobs[i+1:]...)
+			break
+		}
+	}
+	if job.file == nil {
+		med.outputBuffer(job.name, job.out)
+	}
+	job.done(job.out, job.err)
+}
+
+// Whether a job called name is running.
+func (med *Med) jobRunning(name string) bool {
+	for _, j := range med.jobs {
+		if j.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Replace the text of the output buffer called name, creating it if needed.
+// Unlike NewScratchBuffer, this doesn't change the current buffer.
+func (med *Med) outputBuffer(name string, text []byte) *list.Element {
+	for e := med.files.Front(); e != nil; e = e.Next() {
+		if f := e.Value.(*File); f.scratch && f.name == name {
+			f.readOnly = false
+			f.Replace(text)
+			f.readOnly = true
+			f.modified = false
+			return e
+		}
+	}
+	file := NewFile(name, "", text)
+	file.scratch = true
+	file.readOnly = true
+	return med.files.PushBack(file)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"github.com/jsynacek/med/buffer"
+)
+
+// Return the end of the JSON string starting at p. Strings end at the end of
+// the line if they are not closed.
+func jsonString(text []byte, p int) int {
+	for p++; p < len(text) && text[p] != '\n'; p++ {
+		if text[p] == '\\' {
+			p++
+		} else if text[p] == '"' {
+			return p + 1
+		}
+	}
+	return min(p, len(text))
+}
+
+// Return the end of the number starting at p.
+func jsonNumber(text []byte, p int) int {
+	for p < len(text) && bytes.IndexByte([]byte("0123456789+-.eE"), text[p]) >= 0 {
+		p++
+	}
+	return p
+}
+
+// Return whether the text after p, skipping blanks, starts with a colon, so
+// the string before p is a key.
+func jsonKey(text []byte, p int) bool {
+	for p < len(text) && (text[p] == ' ' || text[p] == '\t') {
+		p++
+	}
+	return p < len(text) && text[p] == ':'
+}
+
+// Highlight JSON.
+func jsonSyntax(text []byte, off int, maxLines int) (res []Highlight) {
+	// Strings don't span lines, so the scan can start at the line of off.
+	l := 0
+	for p := buffer.LineStart(text, off); p < len(text) && l < maxLines; {
+		c := text[p]
+		switch {
+		case c == '\n':
+			l++
+			p++
+		case c == '"':
+			e := jsonString(text, p)
+			attr := "string"
+			if jsonKey(text, e) {
+				attr = "key"
+			}
+			res = append(res, Highlight{p, e, theme[attr]})
+			p = e
+		case c == '-' || c >= '0' && c <= '9':
+			e := jsonNumber(text, p)
+			res = append(res, Highlight{p, e, theme["number"]})
+			p = e
+		case c >= 'a' && c <= 'z':
+			e := p
+			for e < len(text) && text[e] >= 'a' && text[e] <= 'z' {
+				e++
+			}
+			switch string(text[p:e]) {
+			case "true", "false", "null":
+				res = append(res, Highlight{p, e, theme["keyword"]})
+			}
+			p = e
+		default:
+			p++
+		}
+	}
+	return
+}
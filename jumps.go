@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+)
+
+// The jump list remembers where the point was before every jump to a
+// location, like to a definition, an error or a grep match, and before undo
+// and redo change text out of the view, so jumpBack can return there, across
+// buffers. Buffers without a file aren't remembered.
+
+// Most jumps remembered.
+const jumpsMax = 100
+
+// Remember the position of the point in file.
+func (med *Med) pushJump(file *File) {
+	if file.path == "" {
+		return
+	}
+	l := file.lineIndex().Line(file.point.Off)
+	loc := Location{
+		path: absPath(file.path),
+		line: l + 1,
+		col:  file.point.Off - file.lineIndex().Start(l) + 1,
+	}
+	if n := len(med.jumps); n > 0 && med.jumps[n-1] == loc {
+		return
+	}
+	if len(med.jumps) == jumpsMax {
+		med.jumps = med.jumps[1:]
+	}
+	med.jumps = append(med.jumps, loc)
+}
+
+// Return to where the point was before the last jump.
+func jumpBack(med *Med, file *File) {
+	n := len(med.jumps)
+	if n == 0 {
+		med.pushError(errors.New("no jumps"))
+		return
+	}
+	loc := med.jumps[n-1]
+	med.jumps = med.jumps[:n-1]
+	med.jumpTo(loc)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"strings"
 )
 
@@ -10,9 +11,11 @@ const (
 	NoMatch
 )
 
+// A key sequence and the command it runs, the name of a command in the
+// commands map, possibly followed by arguments, see runCommand.
 type Keybind struct {
 	keys    string
-	command func(*Med, *File)
+	command string
 }
 
 const (
@@ -30,6 +33,9 @@ const (
 	kPageUp    = "\033\133\065\176"
 	kDelete    = "\033\133\063\176"
 	kBackspace = "\177"
+	kMouse     = "\033\133\074"
+	kFocusIn   = "\033\133\111"
+	kFocusOut  = "\033\133\117"
 )
 
 func kCtrl(s string) string {
@@ -43,15 +49,55 @@ func kAlt(s string) string {
 	return kEsc + s
 }
 
-func resolveKeys(keymap []Keybind, keyseq string) (int, interface{}) {
+// Readable name of a key sequence, for example "C-x M-a SPC".
+func keyName(keys string) string {
+	var names []string
+	for i := 0; i < len(keys); i++ {
+		alt := ""
+		if keys[i] == kEsc[0] && i+1 < len(keys) {
+			alt = "M-"
+			i++
+		}
+		switch c := keys[i]; {
+		case c == ' ':
+			names = append(names, alt+"SPC")
+		case c == kEsc[0]:
+			names = append(names, alt+"ESC")
+		case c == kTab[0]:
+			names = append(names, alt+"TAB")
+		case c == kEnter[0]:
+			names = append(names, alt+"RET")
+		case c < 0x20:
+			names = append(names, alt+"C-"+string(c+0x60))
+		default:
+			names = append(names, alt+string(c))
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+// List the keys that can follow keyseq in keymap and what they run.
+func whichKey(keymap []Keybind, keyseq string) (lines []string) {
+	seen := make(map[string]bool)
+	for _, kb := range keymap {
+		if !strings.HasPrefix(kb.keys, keyseq) || kb.keys == keyseq || seen[kb.keys] {
+			continue
+		}
+		seen[kb.keys] = true
+		lines = append(lines, fmt.Sprintf("%-6s %s", keyName(kb.keys[len(keyseq):]), kb.command))
+	}
+	return
+}
+
+func resolveKeys(keymap []Keybind, keyseq string) (int, string) {
 	for _, keybind := range keymap {
 		switch {
 		case keybind.keys == keyseq:
 			// Poor man's pattern matching.
 			return Match, keybind.command
 		case strings.HasPrefix(keybind.keys, keyseq):
-			return PartialMatch, nil
+			return PartialMatch, ""
 		}
 	}
-	return NoMatch, nil
+	return NoMatch, ""
 }
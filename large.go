@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+)
+
+// Files bigger than largeFileSize bytes or with a line longer than
+// largeLineLength bytes, like logs, dumps and minified code, are loaded in
+// large file mode. It leaves out what would make the editor slow on them:
+// syntax highlighting and syntax trees, completion popups, the checks for
+// invisible characters and mixed Unicode normalization and the copies kept in
+// the local history. The status line marks these buffers with "large". A limit
+// of 0 turns its check off.
+
+func isLarge(text []byte) bool {
+	if largeFileSize > 0 && len(text) > largeFileSize {
+		return true
+	}
+	if largeLineLength <= 0 {
+		return false
+	}
+	for len(text) > largeLineLength {
+		i := bytes.IndexByte(text, '\n')
+		if i < 0 || i > largeLineLength {
+			return true
+		}
+		text = text[i+1:]
+	}
+	return false
+}
+
+// Switch large file mode on or off according to the text of file.
+func (file *File) checkLarge() {
+	file.large = isLarge(file.text.Bytes())
+}
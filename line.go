@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/jsynacek/med/sam"
+	"os"
+	"strings"
+)
+
+// Without a terminal that can be put into raw mode, or with TERM=dumb, med
+// falls back to a line-oriented interface in the spirit of ed. Every line read
+// from the standard input is a sam command run on the active buffer, with dot
+// kept from one line to the next. A line with just an address prints the
+// addressed text, and q quits, asking again if there are modified buffers.
+// Errors are printed after a question mark.
+
+// Whether the terminal can't display anything but lines.
+func dumbTerminal() bool {
+	return os.Getenv("TERM") == "dumb"
+}
+
+func (med *Med) lineMode() {
+	med.lines = true
+	in := bufio.NewScanner(os.Stdin)
+	warned := false
+	for !med.quit && in.Scan() {
+		file := med.file.Value.(*File)
+		line := strings.TrimSpace(in.Text())
+		if line == "q" {
+			if !warned && med.anyNeedsSave() {
+				fmt.Println("? modified buffers, q again to quit")
+				warned = true
+				continue
+			}
+			return
+		}
+		warned = false
+		if err := med.lineCommand(file, line); err != nil {
+			fmt.Println("?", err)
+		}
+		if med.message != "" {
+			fmt.Println(med.message)
+			med.message = ""
+		}
+	}
+}
+
+func (med *Med) anyNeedsSave() bool {
+	for e := med.files.Front(); e != nil; e = e.Next() {
+		if e.Value.(*File).needsSave() {
+			return true
+		}
+	}
+	return false
+}
+
+func (med *Med) lineCommand(file *File, line string) error {
+	var p sam.Parser
+	p.Init([]byte(line))
+	addr, cmdList, err := p.Parse()
+	if err != nil {
+		return err
+	}
+	if err := med.samExecute(file, addr, cmdList); err != nil {
+		return err
+	}
+	if addr != nil && len(cmdList) == 0 {
+		ss, se := med.selectionRange(file)
+		text := file.text.Slice(ss, se)
+		if len(text) > 0 && text[len(text)-1] != '\n' {
+			text = append(text[:len(text):len(text)], '\n')
+		}
+		os.Stdout.Write(text)
+	}
+	file.UndoBlock()
+	return nil
+}
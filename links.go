@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/jsynacek/med/buffer"
+	"net/url"
+	"os"
+	"regexp"
+)
+
+// Paths of locations and URLs in output buffers, like those of grep, builds
+// and tests, are displayed as OSC 8 hyperlinks if hyperlinks is set, so the
+// terminal can open them on its own. Terminals that don't know the sequence
+// ignore it. Relative paths are relative to the working directory, as they
+// are for locations.
+
+type Link struct {
+	start, end int
+	uri        string
+}
+
+var urlRegexp = regexp.MustCompile(`https?://[^\s<>"'()]+[^\s<>"'().,:;]`)
+
+func fileURI(path string) string {
+	host, _ := os.Hostname()
+	u := url.URL{Scheme: "file", Host: host, Path: absPath(path)}
+	return u.String()
+}
+
+// Links in lines of text starting at off, up to n lines.
+func textLinks(text []byte, off, n int) (links []Link) {
+	for ; off < len(text) && n > 0; n-- {
+		le := buffer.LineEnd(text, off)
+		line := text[off:le]
+		if m := locationRegexp.FindSubmatchIndex(line); m != nil {
+			path := string(line[m[2]:m[3]])
+			if _, err := os.Stat(path); err == nil {
+				links = append(links, Link{off + m[2], off + m[3], fileURI(path)})
+			}
+		}
+		for _, m := range urlRegexp.FindAllIndex(line, -1) {
+			links = append(links, Link{off + m[0], off + m[1], string(line[m[0]:m[1]])})
+		}
+		off = le + 1
+	}
+	return
+}
+
+// Links of file displayed in view.
+func (file *File) links(view *View) []Link {
+	if !hyperlinks || !file.scratch {
+		return nil
+	}
+	return textLinks(file.text.Bytes(), buffer.LineStart(file.text.Bytes(), view.start), view.height)
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	"github.com/jsynacek/med/buffer"
+	"github.com/jsynacek/med/term"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Locations are positions in files reported by external tools, like grep
+// or compilers, in the usual "path:line:col: text" format. They are picked
+// from a helm, while the location under the helm selection is shown in a
+// preview column next to the windows. The point only moves once the location
+// is chosen with Enter.
+
+type Location struct {
+	path      string
+	line, col int
+	text      string
+}
+
+var locationRegexp = regexp.MustCompile(`^([^:\s]+):(\d+):(?:(\d+):)?\s?(.*)$`)
+
+// Parse a location from a line of output. The path is returned as it is.
+func parseLocation(l string) (loc Location, ok bool) {
+	m := locationRegexp.FindStringSubmatch(l)
+	if m == nil {
+		return loc, false
+	}
+	loc = Location{path: m[1], text: m[4]}
+	loc.line, _ = strconv.Atoi(m[2])
+	loc.col, _ = strconv.Atoi(m[3])
+	return loc, true
+}
+
+// Parse locations from the output of a command run in dir.
+func parseLocations(dir string, out []byte) (locs []Location) {
+	for _, l := range strings.Split(string(out), "\n") {
+		loc, ok := parseLocation(l)
+		if !ok {
+			continue
+		}
+		if !filepath.IsAbs(loc.path) {
+			loc.path = filepath.Join(dir, loc.path)
+		}
+		locs = append(locs, loc)
+	}
+	return
+}
+
+func (loc Location) String() string {
+	return fmt.Sprintf("%s:%d: %s", relPath(loc.path), loc.line, strings.TrimSpace(loc.text))
+}
+
+// Return path relative to the working directory if it's below it.
+func relPath(path string) string {
+	if rel, err := filepath.Rel(absPath("."), path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+// Offset of the 1-based line and byte column in text.
+func lineOffset(text []byte, line, col int) int {
+	off := 0
+	for ; off < len(text) && line > 1; line-- {
+		off = buffer.LineEnd(text, off) + 1
+	}
+	return min(len(text), min(buffer.LineEnd(text, off), off+max(0, col-1)))
+}
+
+// Preview of a location in a file that is not necessarily displayed in
+// any window.
+type Preview struct {
+	file *File
+	view View
+	off  int
+}
+
+// Find the buffer of an open file.
+func (med *Med) findFile(path string) *list.Element {
+	for e := med.files.Front(); e != nil; e = e.Next() {
+		if f := e.Value.(*File); f.path != "" && absPath(f.path) == path {
+			return e
+		}
+	}
+	return nil
+}
+
+// Show loc in the preview column. Files that are not open are loaded, but not
+// added to the buffer list.
+func (med *Med) previewLocation(loc Location) error {
+	var file *File
+	if e := med.findFile(loc.path); e != nil {
+		file = e.Value.(*File)
+	} else if med.preview != nil && med.preview.file.path == loc.path {
+		file = med.preview.file
+	} else {
+		f, err := LoadFile(loc.path)
+		if err != nil {
+			return err
+		}
+		med.applyLocals(f)
+		file = f
+	}
+	med.preview = &Preview{file: file, off: lineOffset(file.text.Bytes(), loc.line, loc.col)}
+	med.preview.view = file.view
+	return nil
+}
+
+// Split off the right half of the screen for the preview and return the
+// number of columns left for the windows.
+func (med *Med) previewLayout(cols, rows int) int {
+	view := &med.preview.view
+	w := cols / 2
+	view.top, view.height = 0, max(1, rows-1)
+	view.left, view.width = w, max(1, cols-w-1)
+	return w
+}
+
+func (med *Med) displayPreview(t *term.Term) {
+	p := med.preview
+	text := p.file.text.Bytes()
+	p.view.ToPoint(text, p.off, p.view.height/2)
+	line := []Highlight{{buffer.LineStart(text, p.off), buffer.LineEnd(text, p.off) + 1, theme["preview"]}}
+	var highlights []Highlight
+	if showSyntax && p.file.highlights == nil {
+		highlights = p.file.syntax(p.view.start, p.view.height)
+	}
+	p.view.DisplayText(t, text, -1, line, highlights)
+	displayStatus(t, &p.view, "preview  "+relPath(p.file.path))
+}
+
+// Jump to loc, loading its file if needed, and remember where the point was
+// on the jump list.
+func (med *Med) gotoLocation(loc Location) {
+	med.pushJump(med.file.Value.(*File))
+	med.jumpTo(loc)
+}
+
+func (med *Med) jumpTo(loc Location) {
+	e := med.findFile(loc.path)
+	if e == nil {
+		if med.preview != nil && med.preview.file.path == loc.path {
+			e = med.addFile(med.preview.file)
+		} else {
+			file, err := LoadFile(loc.path)
+			if err != nil {
+				med.pushError(err)
+				return
+			}
+			e = med.addFile(file)
+		}
+	}
+	med.file = e
+	file := e.Value.(*File)
+	file.Goto(lineOffset(file.text.Bytes(), loc.line, loc.col))
+	file.view.ToPoint(file.text.Bytes(), file.point.Off, file.view.height/2)
+}
+
+// Start a helm to pick one of locs, previewing the selected one.
+func (med *Med) locationsDialog(prompt string, locs []Location) {
+	var shown []Location
+	update := func() {
+		med.dialog.selected()
+	}
+	finish := func(cancel bool) {
+		med.preview = nil
+		if cancel || len(shown) == 0 {
+			return
+		}
+		i := max(0, med.dialog.helm.index)
+		med.gotoLocation(shown[i])
+	}
+	complete := func() {
+		shown = nil
+		var data []string
+		for _, loc := range locs {
+			if s := loc.String(); strings.Contains(s, string(med.dialog.file.text.Bytes())) {
+				shown = append(shown, loc)
+				data = append(data, s)
+			}
+		}
+		med.dialog.helm.data = data
+	}
+	med.startDialog(prompt, update, finish, NewHelm(complete))
+	med.dialog.selected = func() {
+		if i := max(0, med.dialog.helm.index); i < len(shown) {
+			if err := med.previewLocation(shown[i]); err != nil {
+				med.preview = nil
+			}
+		}
+	}
+	med.dialog.selected()
+}
+
+// Search for a regular expression in the files of the project, or of the
+// directory of the file if it's not in a project.
+func grep(med *Med, file *File) {
+	var word string
+	if s, e, ok := markWord(file.text.Bytes(), file.point.Off); ok {
+		word = string(file.text.Slice(s, e))
+	}
+	update := func() {}
+	finish := func(cancel bool) {
+		pattern := string(med.dialog.file.text.Bytes())
+		if cancel || pattern == "" {
+			return
+		}
+		dir := file.project
+		if dir == "" {
+			dir = absPath(filepath.Dir(file.path))
+		}
+		cmd := exec.Command("grep", "-rnI", "--exclude-dir=.git", "-e", pattern, ".")
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if err != nil {
+			if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+				err = errors.New(string(bytes.TrimSpace(ee.Stderr)))
+			} else if ok {
+				err = fmt.Errorf("no matches for %s", pattern)
+			}
+			med.pushError(err)
+			return
+		}
+		med.locationsDialog("grep "+pattern, parseLocations(dir, out))
+	}
+	med.startDialog("grep", update, finish, Helm{})
+	med.dialog.file.Insert([]byte(word))
+}
+
+// Make locs the locations to step through with nextLocation and prevLocation.
+func (med *Med) setLocations(locs []Location) {
+	med.locations, med.location = locs, -1
+}
+
+func (med *Med) stepLocation(inc int) {
+	n := len(med.locations)
+	if n == 0 {
+		med.pushError(errors.New("no locations"))
+		return
+	}
+	if med.location < 0 && inc < 0 {
+		med.location = 0
+	}
+	med.location = (med.location + inc + n) % n
+	loc := med.locations[med.location]
+	med.gotoLocation(loc)
+	med.showMessage("%d/%d %s", med.location+1, n, strings.TrimSpace(loc.text))
+}
+
+func nextLocation(med *Med, file *File) {
+	med.stepLocation(1)
+}
+func prevLocation(med *Med, file *File) {
+	med.stepLocation(-1)
+}
+
+// Pick one of the locations in a helm.
+func listLocations(med *Med, file *File) {
+	if len(med.locations) == 0 {
+		med.pushError(errors.New("no locations"))
+		return
+	}
+	med.locationsDialog("locations", med.locations)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// A minimal client of the language server protocol, enough to ask a server
+// like gopls a question about a single buffer. Messages are JSON-RPC 2.0
+// with a Content-Length header, over the standard input and output of the
+// server.
+
+type lspClient struct {
+	w  io.Writer
+	r  *bufio.Reader
+	id int
+}
+
+type lspMessage struct {
+	ID     *int            `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params interface{}     `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// Position of off in text, with the character counted in UTF-16 code units as
+// the protocol wants.
+func lspPositionOf(file *File, off int) lspPosition {
+	li := file.lineIndex()
+	l := li.Line(off)
+	n := 0
+	for p := li.Start(l); p < off; {
+		r, s := utf8.DecodeRune(file.text.Bytes()[p:])
+		n += len(utf16.Encode([]rune{r}))
+		p += s
+	}
+	return lspPosition{l, n}
+}
+
+// URI of the file at the absolute path.
+func lspURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+// Path of the file at uri.
+func lspPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return strings.TrimPrefix(uri, "file://")
+	}
+	return u.Path
+}
+
+type lspLocation struct {
+	URI   string `json:"uri"`
+	Range struct {
+		Start lspPosition `json:"start"`
+	} `json:"range"`
+}
+
+// Byte offset in line of the character counted in UTF-16 code units.
+func lspColumn(line []byte, character int) int {
+	p := 0
+	for n := 0; p < len(line) && n < character; {
+		r, s := utf8.DecodeRune(line[p:])
+		n += len(utf16.Encode([]rune{r}))
+		p += s
+	}
+	return p
+}
+
+// Turn the locations from a server into Locations, with their lines as the
+// text. The lines of the file at path are taken from text, which may not be
+// saved yet, the others are read from disk.
+func lspLocations(ls []lspLocation, path string, text []byte) (locs []Location) {
+	files := map[string][][]byte{path: bytes.Split(text, []byte("\n"))}
+	for _, l := range ls {
+		p := lspPath(l.URI)
+		lines, ok := files[p]
+		if !ok {
+			t, _ := ioutil.ReadFile(p)
+			lines = bytes.Split(t, []byte("\n"))
+			files[p] = lines
+		}
+		loc := Location{path: p, line: l.Range.Start.Line + 1, col: 1}
+		if l.Range.Start.Line < len(lines) {
+			line := lines[l.Range.Start.Line]
+			loc.col = lspColumn(line, l.Range.Start.Character) + 1
+			loc.text = string(line)
+		}
+		locs = append(locs, loc)
+	}
+	return
+}
+
+func (c *lspClient) write(m lspMessage) error {
+	body, err := json.Marshal(struct {
+		JSONRPC string `json:"jsonrpc"`
+		lspMessage
+	}{"2.0", m})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (c *lspClient) read() (*lspMessage, error) {
+	h, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(h.Get("Content-Length"))
+	if err != nil {
+		return nil, errors.New("lsp: bad Content-Length")
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return nil, err
+	}
+	m := new(lspMessage)
+	return m, json.Unmarshal(body, m)
+}
+
+// Send a notification, which has no response.
+func (c *lspClient) notify(method string, params interface{}) error {
+	return c.write(lspMessage{Method: method, Params: params})
+}
+
+// Send a request and decode its result into result. Notifications coming
+// meanwhile are dropped and requests from the server get an empty response.
+func (c *lspClient) call(method string, params, result interface{}) error {
+	c.id++
+	id := c.id
+	if err := c.write(lspMessage{ID: &id, Method: method, Params: params}); err != nil {
+		return err
+	}
+	for {
+		m, err := c.read()
+		if err != nil {
+			return err
+		}
+		switch {
+		case m.ID != nil && m.Method != "":
+			if err := c.write(lspMessage{ID: m.ID, Result: json.RawMessage("null")}); err != nil {
+				return err
+			}
+		case m.ID != nil && *m.ID == id:
+			if m.Error != nil {
+				return fmt.Errorf("%s: %s", method, m.Error.Message)
+			}
+			if result == nil || len(m.Result) == 0 {
+				return nil
+			}
+			return json.Unmarshal(m.Result, result)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/jsynacek/med/buffer"
+	"os"
+	"os/exec"
+	"strings"
+	"unicode/utf8"
+)
+
+// Convert the backspace-overstrike sequences nroff uses for bold ("c\bc")
+// and underlined ("_\bc") text into plain text and highlights.
+func parseOverstrike(out []byte) (text []byte, highlights []Highlight) {
+	var attr string
+	start := 0
+	// Close the current highlight and start a new one with attribute a.
+	flush := func(a string) {
+		if a == attr {
+			return
+		}
+		if attr != "" && start < len(text) {
+			highlights = append(highlights, Highlight{start, len(text), theme[attr]})
+		}
+		attr = a
+		start = len(text)
+	}
+	for p := 0; p < len(out); {
+		r, s := utf8.DecodeRune(out[p:])
+		p += s
+		a := ""
+		for p+1 < len(out) && out[p] == '\b' {
+			r2, s2 := utf8.DecodeRune(out[p+1:])
+			switch {
+			case r == r2 && a != "manUnderline":
+				a = "manBold"
+			case r == '_':
+				a = "manUnderline"
+			case r2 == '_':
+				a = "manUnderline"
+				r2 = r
+			}
+			r = r2
+			p += 1 + s2
+		}
+		flush(a)
+		text = append(text, string(r)...)
+	}
+	flush("")
+	return
+}
+
+// Section headings in man pages start at the beginning of the line with an uppercase letter.
+func manSectionNext(text []byte, off int) int {
+	for p := buffer.LineEnd(text, off) + 1; p < len(text); p = buffer.LineEnd(text, p) + 1 {
+		if text[p] >= 'A' && text[p] <= 'Z' {
+			return p
+		}
+	}
+	return len(text)
+}
+
+func manSectionPrev(text []byte, off int) int {
+	for p := buffer.LineStart(text, off) - 1; p > 0; p = buffer.LineStart(text, p) - 1 {
+		if ls := buffer.LineStart(text, p); text[ls] >= 'A' && text[ls] <= 'Z' {
+			return ls
+		}
+	}
+	return 0
+}
+
+func manNextSection(med *Med, file *File) {
+	file.Goto(manSectionNext(file.text.Bytes(), file.point.Off))
+	file.view.ToPoint(file.text.Bytes(), file.point.Off, 0)
+}
+func manPrevSection(med *Med, file *File) {
+	file.Goto(manSectionPrev(file.text.Bytes(), file.point.Off))
+	file.view.ToPoint(file.text.Bytes(), file.point.Off, 0)
+}
+
+// Show a man page in a read-only buffer. The topic defaults to the word under the point.
+func manPage(med *Med, file *File) {
+	var word string
+	if s, e, ok := markWord(file.text.Bytes(), file.point.Off); ok {
+		word = string(file.text.Slice(s, e))
+	}
+	update := func() {}
+	finish := func(cancel bool) {
+		if cancel {
+			return
+		}
+		topic := strings.TrimSpace(string(med.dialog.file.text.Bytes()))
+		if topic == "" {
+			return
+		}
+		cmd := exec.Command("man", append([]string{"-P", "cat"}, strings.Fields(topic)...)...)
+		cmd.Env = append(os.Environ(),
+			"MAN_KEEP_FORMATTING=1",
+			"GROFF_NO_SGR=1",
+			fmt.Sprintf("MANWIDTH=%d", file.view.width))
+		out, err := cmd.Output()
+		if err != nil {
+			if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+				err = errors.New(string(bytes.TrimSpace(ee.Stderr)))
+			}
+			med.pushError(err)
+			return
+		}
+		text, highlights := parseOverstrike(out)
+		man := med.NewScratchBuffer("man "+topic, text)
+		man.readOnly = true
+		man.pager = true
+		man.highlights = highlights
+		med.mode = PagerMode
+	}
+	med.startDialog("man", update, finish, Helm{})
+	med.dialog.file.Insert([]byte(word))
+}
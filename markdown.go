@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"github.com/jsynacek/med/buffer"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Markdown highlighting covers headings, fenced code blocks and, inside
+// paragraphs, code spans, emphasis and links. It is line based and doesn't try
+// to be a full CommonMark parser, so emphasis spanning several lines is not
+// recognized.
+
+// Whether line opens or closes a fenced code block.
+func markdownFence(line []byte) bool {
+	line = bytes.TrimLeft(line, " ")
+	return bytes.HasPrefix(line, []byte("```")) || bytes.HasPrefix(line, []byte("~~~"))
+}
+
+// Whether line is an ATX heading, like "## Usage".
+func markdownHeading(line []byte) bool {
+	line = bytes.TrimLeft(line, " ")
+	n := 0
+	for n < len(line) && line[n] == '#' {
+		n++
+	}
+	return n > 0 && n <= 6 && (n == len(line) || line[n] == ' ' || line[n] == '\t')
+}
+
+// Highlight Markdown text.
+func markdownSyntax(text []byte, off int, maxLines int) (res []Highlight) {
+	// Always scan from the beginning of the text, as off might point into
+	// a code block.
+	code := false
+	for p, l := 0, 0; p <= len(text) && l < maxLines; {
+		e := buffer.LineEnd(text, p)
+		line := text[p:e]
+		fence := markdownFence(line)
+		if e >= off && e > p {
+			switch {
+			case code || fence:
+				res = append(res, Highlight{p, e, theme["mdCode"]})
+			case markdownHeading(line):
+				res = append(res, Highlight{p, e, theme["mdHeading"]})
+			default:
+				res = append(res, markdownInline(line, p)...)
+			}
+		}
+		if e >= off {
+			l++
+		}
+		if fence {
+			code = !code
+		}
+		p = e + 1
+	}
+	return
+}
+
+// Return the offset right after the closing delimiter matching the opening
+// delim, found in line starting at i, or -1.
+func markdownClose(line []byte, i int, delim []byte) int {
+	for i < len(line) {
+		j := bytes.Index(line[i:], delim)
+		if j < 0 {
+			return -1
+		}
+		j += i
+		// The closing delimiter must not be longer than the opening one
+		// and must follow text, not a space.
+		end := j + len(delim)
+		if end < len(line) && line[end] == delim[0] || line[j-1] == ' ' {
+			for end < len(line) && line[end] == delim[0] {
+				end++
+			}
+			i = end
+			continue
+		}
+		return end
+	}
+	return -1
+}
+
+// Highlight code spans, emphasis and links in a line of a paragraph, which
+// starts at off in the text.
+func markdownInline(line []byte, off int) (res []Highlight) {
+	add := func(start, end int, attr string) {
+		res = append(res, Highlight{off + start, off + end, theme[attr]})
+	}
+	for i := 0; i < len(line); {
+		c := line[i]
+		n := 1
+		for i+n < len(line) && line[i+n] == c {
+			n++
+		}
+		switch {
+		case c == '\\':
+			i += 2
+			continue
+		case c == '`':
+			if end := markdownClose(line, i+n, line[i:i+n]); end > 0 {
+				add(i, end, "mdCode")
+				i = end
+				continue
+			}
+		case c == '[' || c == '!' && i+1 < len(line) && line[i+1] == '[':
+			start := i
+			if c == '!' {
+				i++
+			}
+			if j := bytes.Index(line[i:], []byte("](")); j > 0 {
+				if k := bytes.IndexByte(line[i+j:], ')'); k > 0 {
+					end := i + j + k + 1
+					add(start, end, "mdLink")
+					i = end
+					continue
+				}
+			}
+			i = start + 1
+			continue
+		case (c == '*' || c == '_') && n <= 2 && i+n < len(line) && line[i+n] != ' ':
+			// Underscores inside words, like in snake_case, are not emphasis.
+			if r, _ := utf8.DecodeLastRune(line[:i]); c == '_' && i > 0 && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+				break
+			}
+			if end := markdownClose(line, i+n, line[i:i+n]); end > 0 {
+				add(i, end, "mdEmphasis")
+				i = end
+				continue
+			}
+		}
+		i += n
+	}
+	return
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"container/list"
 	"errors"
+	"flag"
 	"fmt"
+	"github.com/jsynacek/med/buffer"
 	"github.com/jsynacek/med/sam"
 	"github.com/jsynacek/med/term"
 	"io/ioutil"
@@ -11,8 +14,10 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
 )
 
@@ -22,6 +27,7 @@ const (
 	SelectionMode
 	DialogMode
 	ErrorMode
+	PagerMode
 
 	CharSelection
 	LineSelection
@@ -35,6 +41,39 @@ var (
 	smartLineStart   = true
 	showVisuals      = false
 	showSyntax       = true
+	reindentPaste    = false
+	replaceLinewise  = true
+	flashUndo        = true
+	recenterUndo     = false
+	keepHistory      = true
+	promptScratch    = false
+	useMouse         = true
+	showWhichKey     = true
+	buildCommand     = "go build ./..."
+	formatCommand    = "gofmt"
+	keepPositions    = true
+	interpreter      = "sh"
+	idleTime         = 1000
+	autoSave         = false
+	checkOnSave      = true
+	smartQuotes      = false
+	autoComplete     = false
+	indentGuides     = false
+	pageOverlap      = 3
+	wheelLines       = 1
+	hyperlinks       = true
+	inline           = false
+	inlineRows       = 12
+	maxFps           = 60
+	showFrameStats   = false
+	normalizeUnicode = false
+	undoBytes        = 16777216
+	timeFormats      = "2006-01-02|2006-01-02 15:04|Mon, 02 Jan 2006 15:04:05 -0700"
+	previewSam       = false
+	autoFill         = false
+	fillColumn       = 79
+	largeFileSize    = 10485760
+	largeLineLength  = 10000
 )
 
 type updateFunc func()
@@ -44,6 +83,8 @@ type completeFunc func()
 type Helm struct {
 	active bool
 	index  int
+	// First displayed item.
+	scroll int
 	// TODO: Reimplement this using container/ring.
 	data     []string
 	complete completeFunc
@@ -56,16 +97,24 @@ type Dialog struct {
 	helm   Helm
 	update updateFunc
 	finish finishFunc
+	// Called on Tab instead of moving to the next helm item, if set.
+	tab func()
+	// Called when another helm item is selected, if set.
+	selected func()
 }
 
 type SearchContext struct {
 	// Original point and view.
-	point Point
+	point buffer.Point
 	view  View
 	// Last search.
 	last []byte
+	// Last regular expression search, used instead of last if set.
+	re *regexp.Regexp
 }
 
+// Commands that change a selection move its point to the end that moved, and
+// the view follows the point, so the growing end of a selection stays visible.
 type Selection struct {
 	active bool
 	sel    int // Type - chars or lines.
@@ -77,12 +126,66 @@ type Med struct {
 	files     *list.List
 	file      *list.Element
 	mode      int
+	baseMode  int // Mode to return to after dialogs and errors.
 	dialog    *Dialog
 	searchctx *SearchContext
 	selection Selection
 	errors    *list.List
 	keyseq    string
-	clip      []byte
+	// Command line run by the last key, while it runs, and the arguments
+	// of the command running, see runCommand.
+	command string
+	args    []string
+	clip    Clip
+	// Text highlighted until the next key press.
+	flash *Dot
+	// Highlighted matches in the active buffer, like occurrences to rename.
+	matches []Highlight
+	// Message shown in the last row until the next key press.
+	message string
+	// Popup shown until the next key press.
+	popup  *Popup
+	config *Config
+	quit   bool
+	// Last dialog with a helm, for helmResume.
+	lastHelm *Dialog
+	// True while asking about modified buffers before quitting.
+	quitting bool
+	// Completions popped up while typing, see complete.go.
+	completion *Completion
+	// True while the terminal is not focused, see events.go.
+	unfocused bool
+	// True in the line-oriented interface, see line.go.
+	lines bool
+	// Displayed buffers and the index of the active one.
+	windows []*list.Element
+	window  int
+	// Relative sizes of windows and whether they are stacked, see layout.
+	sizes   []int
+	stacked bool
+	// Second column of the active buffer in follow mode, or nil.
+	follow *View
+	// Location shown next to the windows while picking from a list, or nil.
+	preview *Preview
+	// Sidebar listing declarations of the active buffer, or nil.
+	outline *Outline
+	// Locations to step through, like errors, and the current one.
+	locations []Location
+	location  int
+	// Positions to return to with jumpBack, see jumps.go.
+	jumps []Location
+	// Where sam output goes while a request from the socket runs, see ctl.go.
+	ctlOutput *bytes.Buffer
+	// Running jobs, see jobs.go.
+	jobs []*Job
+	// Events for the main loop and the watcher of loaded files, see events.go.
+	events  chan Event
+	watcher *fileWatcher
+	// Tasks to run when idle, see idle.go.
+	idleTasks []IdleTask
+	lastKey   time.Time
+	idle      bool
+	diff      *Diff
 }
 
 //// Keymaps.
@@ -102,139 +205,241 @@ func joinKeybinds(values ...interface{}) (result []Keybind) {
 }
 
 var commonMovementKeymap = []Keybind{
-	{kRight, wMoveSelection(pointRight)},
-	{kLeft, wMoveSelection(pointLeft)},
-	{kDown, wMoveSelection(pointDown)},
-	{kUp, wMoveSelection(pointUp)},
-	{kEnd, wMoveSelection(pointLineEnd)},
-	{kHome, wMoveSelection(pointLineStart)},
-	{kPageDown, wMoveSelection(pageDown)},
-	{kPageUp, wMoveSelection(pageUp)},
+	{kRight, "pointRight"},
+	{kLeft, "pointLeft"},
+	{kDown, "pointDown"},
+	{kUp, "pointUp"},
+	{kEnd, "pointLineEnd"},
+	{kHome, "pointLineStart"},
+	{kPageDown, "pageDown"},
+	{kPageUp, "pageUp"},
 }
 
 var movementKeymap = joinKeybinds(
 	commonMovementKeymap,
 	[]Keybind{
-		{"l", wMoveSelection(pointRight)},
-		{"j", wMoveSelection(pointLeft)},
-		{"k", wMoveSelection(pointDown)},
-		{"i", wMoveSelection(pointUp)},
-		{"L", wMoveSelection(pointLineEnd)},
-		{"J", wMoveSelection(pointLineStart)},
-		{"o", wMoveSelection(pointWordRight)},
-		{"u", wMoveSelection(pointWordLeft)},
-		{"O", wMoveSelection(pointParagraphRight)},
-		{"U", wMoveSelection(pointParagraphLeft)},
-		{"K", wMoveSelection(pageDown)},
-		{"I", wMoveSelection(pageUp)},
-		{" k", wMoveSelection(pointTextEnd)},
-		{" i", wMoveSelection(pointTextStart)},
+		{"l", "pointRight"},
+		{"j", "pointLeft"},
+		{"k", "pointDown"},
+		{"i", "pointUp"},
+		{"L", "pointLineEnd"},
+		{"J", "pointLineStart"},
+		{"o", "pointWordRight"},
+		{"u", "pointWordLeft"},
+		{"O", "pointParagraphRight"},
+		{"U", "pointParagraphLeft"},
+		{"K", "pageDown"},
+		{"I", "pageUp"},
+		{" K", "halfPageDown"},
+		{" I", "halfPageUp"},
+		{" k", "pointTextEnd"},
+		{" i", "pointTextStart"},
 	},
 )
 
 var commandModeKeymap = joinKeybinds(
 	// Catch kEsc first, so it doesn't count as a key sequence start other keys,
 	// that start with an escape sequence.
-	Keybind{kEsc, commandMode},
+	Keybind{kEsc, "commandMode"},
 	movementKeymap,
 	[]Keybind{
-		{"n", searchForward},
-		{"N", searchBackward},
-		{"0", searchNextForward},
-		{"9", searchNextBackward},
-		{"h", searchCurrentWord},
-		{" l", gotoLine},
-		{"/", gotoMatchingBracket},
-		{"c", clipCopy},
-		{"v", clipPaste},
-		{"x", clipCut},
-		{"e", backspace},
-		{"r", deleteChar},
-		{"y", undo},
-		{"Y", redo},
-		{"f", editingMode},
-		{"sk", openBelow},
-		{"si", openAbove},
-		{"dL", changeLineEnd},
-		{"dJ", changeLineStart},
-		{"dd", changeLine},
-		{"mm", selectionMode},
-		{"mw", selectWord},
-		{"ms", selectString},
-		{"md", selectBlock},
-		{" f", switchBuffer},
-		{" q", closeBuffer},
-		{"1", leaveMark},
-		{"2", gotoMark},
-		{" gc", goComment},
-		{" gu", goUncomment},
-		{" gl", goIndent},
-		{" gj", goUnindent},
-		{" gd", godoc},
-		{" o", loadFile},
-		{" s", saveFile},
-		{"`", switchVisuals},
-		{"~", switchSyntax},
-		{"zi", pointToViewTop},
-		{"zj", pointToViewMiddle},
-		{"zk", pointToViewBottom},
-		{"zI", viewToPointTop},
-		{"zJ", viewToPointMiddle},
-		{"zK", viewToPointBottom},
-		{"a", samCommand},
+		{"n", "searchForward"},
+		{"N", "searchBackward"},
+		{"b", "searchRegexpForward"},
+		{"B", "searchRegexpBackward"},
+		{"0", "searchNextForward"},
+		{"9", "searchNextBackward"},
+		{"h", "searchCurrentWord"},
+		{" l", "gotoLine"},
+		{" L", "gotoAddress"},
+		{"/", "gotoMatchingBracket"},
+		{"c", "clipCopy"},
+		{"v", "clipPaste"},
+		{"x", "clipCut"},
+		{"e", "backspace"},
+		{"r", "deleteChar"},
+		{"y", "undo"},
+		{"Y", "redo"},
+		{" y", "undoBranchPrev"},
+		{" Y", "undoBranchNext"},
+		{"f", "editingMode"},
+		{"sk", "openBelow"},
+		{"si", "openAbove"},
+		{"tk", "duplicateBelow"},
+		{"ti", "duplicateAbove"},
+		{"dL", "changeLineEnd"},
+		{"dJ", "changeLineStart"},
+		{"dd", "changeLine"},
+		{"mm", "selectionMode"},
+		{"mw", "selectWord"},
+		{"ms", "selectString"},
+		{"md", "selectBlock"},
+		{"ml", "selectLine"},
+		{"mL", "selectLineExclusive"},
+		{" f", "switchBuffer"},
+		{" q", "closeBuffer"},
+		{" ]", "nextBuffer"},
+		{" [", "prevBuffer"},
+		{"1", "leaveMark"},
+		{"2", "gotoMark"},
+		{"3", "toggleBookmark"},
+		{"4", "nextBookmark"},
+		{"5", "prevBookmark"},
+		{" gc", "goComment"},
+		{" gu", "goUncomment"},
+		{" gl", "goIndent"},
+		{" gj", "goUnindent"},
+		{" gd", "godoc"},
+		{" gf", "gotoDefinition"},
+		{" gr", "listReferences"},
+		{" gb", "jumpBack"},
+		{" m", "manPage"},
+		{" n", "scratchBuffer"},
+		{" o", "loadFile"},
+		{" /", "grep"},
+		{" ta", "goTestAll"},
+		{" tp", "goTestPackage"},
+		{" tt", "goTestFunc"},
+		{" x", "runBuffer"},
+		{" $", "shellBuffer"},
+		{" R", "renameWord"},
+		{" c", "checkBuffer"},
+		{" D", "highlightDuplicates"},
+		{" '", "toggleSmartQuotes"},
+		{" .", "completeGoMember"},
+		{" O", "loadProjectFile"},
+		{" r", "helmResume"},
+		{" s", "saveFile"},
+		{" S", "saveAll"},
+		{" eh", "exportToHTML"},
+		{" ea", "exportToANSI"},
+		{"`", "switchVisuals"},
+		{"~", "switchSyntax"},
+		{"zi", "pointToViewTop"},
+		{"zj", "pointToViewMiddle"},
+		{"zk", "pointToViewBottom"},
+		{"zI", "viewToPointTop"},
+		{"zJ", "viewToPointMiddle"},
+		{"zK", "viewToPointBottom"},
+		{"zf", "foldSam"},
+		{"zu", "unfold"},
+		{"zU", "unfoldAll"},
+		{"zn", "scrollPageDown"},
+		{"zp", "scrollPageUp"},
+		{"a", "samCommand"},
+		{"ws", "splitWindow"},
+		{"wc", "closeWindow"},
+		{"ww", "otherWindow"},
+		{"w=", "balanceWindows"},
+		{"w+", "growWindow"},
+		{"w-", "shrinkWindow"},
+		{"wt", "toggleStacking"},
+		{"wr", "rotateWindows"},
+		{"wS", "saveSession"},
+		{"wR", "restoreSession"},
+		{"wf", "toggleFollow"},
+		{"wo", "toggleOutline"},
+		{"wj", "outlineJump"},
+		{"Dd", "diffBuffers"},
+		{"Dk", "diffNext"},
+		{"Di", "diffPrev"},
+		{"Dj", "diffTakeLeft"},
+		{"Dl", "diffTakeRight"},
+		{"Dq", "diffQuit"},
+		{"Hh", "historyBrowse"},
+		{"Hr", "historyRestore"},
+		{"Ek", "nextLocation"},
+		{"Ei", "prevLocation"},
+		{"El", "listLocations"},
+		{"Eb", "compile"},
 	},
 )
 
 var editingModeKeymap = joinKeybinds(
-	Keybind{kEsc, func(*Med, *File) {}},
+	Keybind{kEsc, "nothing"},
 	commonMovementKeymap,
 	[]Keybind{
-		{kAlt(" "), commandMode},
-		{kEnter, insertNewline},
-		{kDelete, deleteChar},
-		{kBackspace, backspace},
+		{kAlt(" "), "commandMode"},
+		{kEnter, "insertNewline"},
+		{kDelete, "deleteChar"},
+		{kBackspace, "backspace"},
+		{kAlt("."), "completeGoMember"},
+		{kAlt(","), "completeGopls"},
+		{kCtrl("k"), "insertDigraph"},
 	},
 )
 
 var selectionModeKeymap = joinKeybinds(
-	Keybind{kEsc, func(*Med, *File) {}},
+	Keybind{kEsc, "nothing"},
 	movementKeymap,
 	[]Keybind{
-		{kAlt(" "), commandMode},
-		{"/", wMoveSelection(gotoMatchingBracket)},
-		{"c", clipCopy},
-		{"x", clipCut},
-		{"d", clipChange},
-		{" gc", goComment},
-		{" gu", goUncomment},
-		{" gl", goIndent},
-		{" gj", goUnindent},
-		{"m", selectionChange},
-		{"s", selectionSwapEnd},
-		{"n", searchForward},
-		{"N", searchBackward},
-		{"0", wMoveSelection(searchNextForward)},
-		{"9", wMoveSelection(searchNextBackward)},
-		{" n", selectionSearch},
-		{"a", samCommand},
+		{kAlt(" "), "commandMode"},
+		{"/", "gotoMatchingBracket"},
+		{"c", "clipCopy"},
+		{"x", "clipCut"},
+		{"v", "clipReplace"},
+		{"d", "clipChange"},
+		{"#", "insertSequence"},
+		{"tk", "duplicateBelow"},
+		{"ti", "duplicateAbove"},
+		{" gc", "goComment"},
+		{" gu", "goUncomment"},
+		{" gl", "goIndent"},
+		{" gj", "goUnindent"},
+		{"m", "selectionChange"},
+		{"s", "selectionSwapEnd"},
+		{".", "selectNextLine"},
+		{",", "selectPrevLine"},
+		{"n", "searchForward"},
+		{"N", "searchBackward"},
+		{"b", "searchRegexpForward"},
+		{"B", "searchRegexpBackward"},
+		{"0", "searchNextForward"},
+		{"9", "searchNextBackward"},
+		{" n", "selectionSearch"},
+		{" eh", "exportToHTML"},
+		{" ea", "exportToANSI"},
+		{"a", "samCommand"},
 	},
 )
 
 var dialogModeKeymap = []Keybind{
-	{kEsc, func(*Med, *File) {}},
-	{kAlt(" "), dialogCancel},
-	{kRight, dialogPointRight},
-	{kLeft, dialogPointLeft},
-	{kEnd, dialogPointLineEnd},
-	{kHome, dialogPointLineStart},
-	{kDelete, wDialogUpdate(dialogDeleteChar)},
-	{kBackspace, wDialogUpdate(dialogBackspace)},
-	{kCtrl("u"), wDialogUpdate(dialogClear)},
-	{kAlt("l"), dialogHelmNext},
-	{kAlt("j"), dialogHelmPrev},
-	{kTab, dialogHelmNext},
-	{kShiftTab, dialogHelmPrev},
-	{kEnter, dialogFinish},
+	{kEsc, "nothing"},
+	{kAlt(" "), "dialogCancel"},
+	{kRight, "dialogPointRight"},
+	{kLeft, "dialogPointLeft"},
+	{kEnd, "dialogPointLineEnd"},
+	{kHome, "dialogPointLineStart"},
+	{kDelete, "dialogDeleteChar"},
+	{kBackspace, "dialogBackspace"},
+	{kCtrl("u"), "dialogClear"},
+	{kAlt("l"), "dialogHelmNext"},
+	{kAlt("j"), "dialogHelmPrev"},
+	{kTab, "dialogTab"},
+	{kShiftTab, "dialogHelmPrev"},
+	{kEnter, "dialogFinish"},
+}
+
+// Read-only keymap resembling less(1).
+var pagerModeKeymap = []Keybind{
+	{kEsc, "nothing"},
+	{" ", "pageDown"},
+	{"b", "pageUp"},
+	{kPageDown, "pageDown"},
+	{kPageUp, "pageUp"},
+	{"j", "pagerLineDown"},
+	{"k", "pagerLineUp"},
+	{kDown, "pagerLineDown"},
+	{kUp, "pagerLineUp"},
+	{"g", "pointTextStart"},
+	{"G", "pointTextEnd"},
+	{"/", "searchForward"},
+	{"?", "searchBackward"},
+	{"n", "searchNextForward"},
+	{"N", "searchNextBackward"},
+	{"]", "manNextSection"},
+	{"[", "manPrevSection"},
+	{"q", "pagerQuit"},
 }
 
 var editorKeymaps = map[int][]Keybind{
@@ -242,6 +447,7 @@ var editorKeymaps = map[int][]Keybind{
 	EditingMode:   editingModeKeymap,
 	SelectionMode: selectionModeKeymap,
 	DialogMode:    dialogModeKeymap,
+	PagerMode:     pagerModeKeymap,
 }
 
 //// Helpers.
@@ -274,12 +480,16 @@ func (med *Med) startDialog(prompt string, update updateFunc, finish finishFunc,
 	d.update = func() {
 		if d.helm.active {
 			d.helm.index = -1
+			d.helm.scroll = 0
 			d.helm.complete()
 		}
 		update()
 	}
 	d.finish = func(c bool) {
-		med.mode = CommandMode
+		med.mode = med.restMode()
+		if d.helm.active {
+			med.lastHelm = d
+		}
 		finish(c)
 	}
 }
@@ -295,6 +505,9 @@ func wMoveSelection(fn func(*Med, *File)) func(*Med, *File) {
 	}
 }
 
+// Do nothing, for keys that are bound only so that they aren't typed.
+func nothing(med *Med, file *File) {}
+
 func wDialogUpdate(fn func(*Med, *File)) func(*Med, *File) {
 	return func(med *Med, file *File) {
 		fn(med, file)
@@ -305,48 +518,71 @@ func wDialogUpdate(fn func(*Med, *File)) func(*Med, *File) {
 //// Command mode commands.
 
 func pointRight(med *Med, file *File) {
-	file.point.Right(file.text, tabStop)
+	file.point.Right(file.text.Bytes(), tabStop)
 }
 func pointLeft(med *Med, file *File) {
-	file.point.Left(file.text, tabStop)
+	file.point.Left(file.text.Bytes(), tabStop)
 }
 func pointDown(med *Med, file *File) {
-	file.point.Down(file.text, tabStop, keepVisualColumn)
+	file.point.Down(file.text.Bytes(), tabStop, keepVisualColumn)
 }
 func pointUp(med *Med, file *File) {
-	file.point.Up(file.text, tabStop, keepVisualColumn)
+	file.point.Up(file.text.Bytes(), tabStop, keepVisualColumn)
 }
 func pointLineEnd(med *Med, file *File) {
-	file.point.LineEnd(file.text, tabStop)
+	file.point.LineEnd(file.text.Bytes(), tabStop)
 }
 func pointLineStart(med *Med, file *File) {
-	file.point.LineStart(file.text, smartLineStart)
+	file.point.LineStart(file.text.Bytes(), smartLineStart)
 }
 func pointWordRight(med *Med, file *File) {
-	file.Goto(textWordNext(file.text, file.point.off))
+	file.Goto(buffer.WordNext(file.text.Bytes(), file.point.Off))
 }
 func pointWordLeft(med *Med, file *File) {
-	file.Goto(textWordPrev(file.text, file.point.off))
+	file.Goto(buffer.WordPrev(file.text.Bytes(), file.point.Off))
 }
 func pointParagraphRight(med *Med, file *File) {
-	file.Goto(textParagraphNext(file.text, file.point.off))
+	file.Goto(buffer.ParagraphNext(file.text.Bytes(), file.point.Off))
 }
 func pointParagraphLeft(med *Med, file *File) {
-	file.Goto(textParagraphPrev(file.text, file.point.off))
+	file.Goto(buffer.ParagraphPrev(file.text.Bytes(), file.point.Off))
+}
+
+// Scroll the view by n lines and move the point along, to the same row and
+// column of the view it was displayed at.
+func (med *Med) page(file *File, n int) {
+	row, col, _ := file.view.LocateOffset(file.text.Bytes(), file.point.Off)
+	file.view.Scroll(file.text.Bytes(), n)
+	file.Goto(file.view.PositionAt(file.text.Bytes(), row, col))
 }
 func pageDown(med *Med, file *File) {
-	file.view.PageDown(file.text)
-	pointToViewTop(med, file)
+	med.page(file, file.view.pageLines())
 }
 func pageUp(med *Med, file *File) {
-	file.view.PageUp(file.text)
-	pointToViewTop(med, file)
+	med.page(file, -file.view.pageLines())
+}
+func halfPageDown(med *Med, file *File) {
+	med.page(file, max(1, file.view.height/2))
+}
+func halfPageUp(med *Med, file *File) {
+	med.page(file, -max(1, file.view.height/2))
+}
+
+// Scroll the view by a page. The point moves only if it would end up out of
+// the view.
+func scrollPageDown(med *Med, file *File) {
+	file.view.PageDown(file.text.Bytes())
+	med.pointIntoView(file)
+}
+func scrollPageUp(med *Med, file *File) {
+	file.view.PageUp(file.text.Bytes())
+	med.pointIntoView(file)
 }
 func pointTextStart(med *Med, file *File) {
-	file.point.TextStart(file.text)
+	file.point.TextStart(file.text.Bytes())
 }
 func pointTextEnd(med *Med, file *File) {
-	file.point.TextEnd(file.text, tabStop)
+	file.point.TextEnd(file.text.Bytes(), tabStop)
 }
 func searchForward(med *Med, file *File) {
 	med.search(file, true)
@@ -366,9 +602,18 @@ func searchCurrentWord(med *Med, file *File) {
 }
 
 func gotoLine(med *Med, file *File) {
+	if len(med.args) > 0 {
+		l, err := strconv.Atoi(med.args[0])
+		if err != nil {
+			med.pushError(err)
+			return
+		}
+		file.GotoLine(l)
+		return
+	}
 	med.searchctx = &SearchContext{point: file.point, view: file.view}
 	update := func() {
-		l, err := strconv.Atoi(string(med.dialog.file.text))
+		l, err := strconv.Atoi(string(med.dialog.file.text.Bytes()))
 		if err == nil {
 			file.GotoLine(l)
 		} else {
@@ -382,9 +627,43 @@ func gotoLine(med *Med, file *File) {
 	}
 	med.startDialog("goto line", update, finish, Helm{})
 }
+
+// Go to a sam address, like /re/, #off, 42, $ or .+3, as it's typed. The
+// text it selects is flashed.
+func gotoAddress(med *Med, file *File) {
+	med.searchctx = &SearchContext{point: file.point, view: file.view}
+	address := func() (Dot, bool) {
+		var p sam.Parser
+		p.Init(med.dialog.file.text.Bytes())
+		addr, cmdList, err := p.Parse()
+		if err != nil || addr == nil || len(cmdList) > 0 {
+			return Dot{}, false
+		}
+		return med.samDot(file, addr), true
+	}
+	update := func() {
+		med.restoreSearchContext(file)
+		if dot, ok := address(); ok {
+			file.Goto(dot.start)
+		}
+	}
+	finish := func(cancel bool) {
+		med.restoreSearchContext(file)
+		dot, ok := address()
+		if cancel || !ok {
+			return
+		}
+		file.mark = med.searchctx.point
+		file.Goto(dot.start)
+		if dot.end > dot.start {
+			med.flash = &dot
+		}
+	}
+	med.startDialog("goto address", update, finish, Helm{})
+}
 func gotoMatchingBracket(med *Med, file *File) {
 	for _, pair := range []string{"()", "[]", "{}"} {
-		off, ok := textMatchingBracket(file.text, file.point.off, pair[:1], pair[1:])
+		off, ok := buffer.MatchingBracket(file.text.Bytes(), file.point.Off, pair[:1], pair[1:])
 		if ok {
 			file.Goto(off)
 			return
@@ -392,7 +671,11 @@ func gotoMatchingBracket(med *Med, file *File) {
 	}
 }
 func insertNewline(med *Med, file *File) {
-	i := lineIndentText(file.text, file.point.off)
+	if file.shell != nil {
+		file.shell.send(file)
+		return
+	}
+	i := buffer.LineIndentText(file.text.Bytes(), file.point.Off)
 	file.Insert(NL)
 	if keepIndent {
 		file.Insert(i)
@@ -405,14 +688,67 @@ func deleteChar(med *Med, file *File) {
 	file.DeleteChar()
 }
 func undo(med *Med, file *File) {
-	file.Undo()
+	med.pushUndoJump(file, false)
+	start, end := file.Undo()
+	med.flashRange(file, start, end)
+	undoAdjustView(file)
 }
 func redo(med *Med, file *File) {
-	file.Redo()
+	med.pushUndoJump(file, true)
+	start, end := file.Redo()
+	med.flashRange(file, start, end)
+	undoAdjustView(file)
+}
+
+// Remember the point in the jump list if undo, or redo if redo is set,
+// changes only text out of the view, so jumpBack returns to where the text
+// was read.
+func (med *Med) pushUndoJump(file *File, redo bool) {
+	b := file.nextUndoBlock(redo)
+	if b == nil {
+		return
+	}
+	for _, u := range b.undos {
+		if u.off >= file.view.start && u.off <= file.view.end {
+			return
+		}
+	}
+	med.pushJump(file)
+}
+
+// Make the point visible after undo or redo. If recenterUndo is set and the
+// point moved out of the view, it is recentered.
+func undoAdjustView(file *File) {
+	off := file.point.Off
+	if recenterUndo && (off < file.view.start || off >= file.view.end) {
+		file.view.ToPoint(file.text.Bytes(), off, file.view.height/2)
+	} else {
+		file.view.AdjustToPoint(file.text.Bytes(), off)
+	}
+}
+
+// How long flashRange flashes text.
+const flashTime = 500 * time.Millisecond
+
+// Flash the text between start and end for flashTime, or until the next key
+// press. Empty ranges flash a single character.
+func (med *Med) flashRange(file *File, start, end int) {
+	if !flashUndo || start < 0 {
+		return
+	}
+	if start == end && end < file.text.Len() {
+		_, s := utf8.DecodeRune(file.text.Bytes()[end:])
+		end += s
+	}
+	flash := &Dot{start, end}
+	med.flash = flash
+	time.AfterFunc(flashTime, func() {
+		med.events <- FlashEndEvent{flash}
+	})
 }
 func openBelow(med *Med, file *File) {
-	i := lineIndentText(file.text, file.point.off)
-	file.point.LineEnd(file.text, tabStop)
+	i := buffer.LineIndentText(file.text.Bytes(), file.point.Off)
+	file.point.LineEnd(file.text.Bytes(), tabStop)
 	file.Insert(NL)
 	if keepIndent {
 		file.Insert(i)
@@ -420,25 +756,71 @@ func openBelow(med *Med, file *File) {
 	med.mode = EditingMode
 }
 func openAbove(med *Med, file *File) {
-	i := lineIndentText(file.text, file.point.off)
-	file.point.LineStart(file.text, false)
+	i := buffer.LineIndentText(file.text.Bytes(), file.point.Off)
+	file.point.LineStart(file.text.Bytes(), false)
 	file.Insert(NL)
-	file.point.Up(file.text, tabStop, false)
+	file.point.Up(file.text.Bytes(), tabStop, false)
 	if keepIndent {
 		file.Insert(i)
 	}
 	med.mode = EditingMode
 }
+
+// Duplicate the selection, or the current line if there is no selection
+// or it is empty. The duplicate gets selected, or the point moves to it.
+func (med *Med) duplicate(file *File, below bool) {
+	var start, end int
+	if med.mode == SelectionMode {
+		start, end = med.selectionRange(file)
+	}
+	lines := start == end
+	if lines {
+		start, end = buffer.LineRange(file.text.Bytes(), file.point.Off, true)
+	}
+	rel := file.point.Off - start
+	what := append([]byte(nil), file.text.Slice(start, end)...)
+	off, dup := start, start
+	if below {
+		off, dup = end, end
+	}
+	if lines && (len(what) == 0 || what[len(what)-1] != '\n') {
+		// Last line without the trailing newline.
+		if below {
+			what = append([]byte{'\n'}, what...)
+			dup++
+		} else {
+			what = append(what, '\n')
+		}
+	}
+	file.Goto(off)
+	file.Insert(what)
+	if lines {
+		file.Goto(dup + rel)
+		med.selection.anchor, med.selection.point = file.point.Off, file.point.Off
+		return
+	}
+	med.selection.anchor, med.selection.point = dup, dup+end-start
+	if med.selection.sel == LineSelection {
+		med.selection.point--
+	}
+	file.Goto(med.selection.point)
+}
+func duplicateBelow(med *Med, file *File) {
+	med.duplicate(file, true)
+}
+func duplicateAbove(med *Med, file *File) {
+	med.duplicate(file, false)
+}
 func changeLineEnd(med *Med, file *File) {
-	med.clip = file.DeleteLineEnd()
+	med.setClip(file.DeleteLineEnd(), ClipChars)
 	med.mode = EditingMode
 }
 func changeLineStart(med *Med, file *File) {
-	med.clip = file.DeleteLineStart()
+	med.setClip(file.DeleteLineStart(), ClipChars)
 	med.mode = EditingMode
 }
 func changeLine(med *Med, file *File) {
-	med.clip = file.DeleteLine(false)
+	med.setClip(file.DeleteLine(false), ClipChars)
 	med.mode = EditingMode
 }
 
@@ -457,17 +839,17 @@ func (med *Med) mapSelectionRange(file *File, fn func(*File, int, int) int, cm b
 		off, end := med.selectionRange(file)
 		med.selection.point = off
 		for p := off; p < end; {
-			_, i := lineIndent(file.text, p)
+			_, i := buffer.LineIndent(file.text.Bytes(), p)
 			file.Goto(p)
 			end += fn(file, p, i)
-			p = lineEnd(file.text, p) + 1
+			p = buffer.LineEnd(file.text.Bytes(), p) + 1
 		}
 		med.selection.anchor = end - 1
 		if cm {
 			med.mode = CommandMode
 		}
 	} else {
-		ls, i := lineIndent(file.text, file.point.off)
+		ls, i := buffer.LineIndent(file.text.Bytes(), file.point.Off)
 		fn(file, ls, i)
 	}
 	file.gotoMark()
@@ -483,7 +865,7 @@ func goComment(med *Med, file *File) {
 func goUncomment(med *Med, file *File) {
 	uncomment := func(file *File, ls int, i int) int {
 		file.Goto(i)
-		if strings.HasPrefix(string(file.text[i:]), "//") {
+		if strings.HasPrefix(string(file.text.Bytes()[i:]), "//") {
 			file.Delete(i, i+2)
 			return -2
 		}
@@ -502,7 +884,7 @@ func goIndent(med *Med, file *File) {
 func goUnindent(med *Med, file *File) {
 	unindent := func(file *File, ls int, i int) int {
 		file.Goto(ls)
-		if strings.HasPrefix(string(file.text[ls:]), "\t") {
+		if strings.HasPrefix(string(file.text.Bytes()[ls:]), "\t") {
 			file.Delete(ls, ls+1)
 			return -1
 		}
@@ -511,19 +893,125 @@ func goUnindent(med *Med, file *File) {
 	med.mapSelectionRange(file, unindent, false)
 }
 
+// Start loading a file in the directory of the current one.
 func loadFile(med *Med, file *File) {
-	med.load()
+	dir := ""
+	if d := path.Dir(file.path); file.path != "" && d != "." {
+		dir = d + "/"
+	}
+	med.load(dir)
 }
+
+// Open a new buffer that is not backed by a file and make it current.
+func (med *Med) NewScratchBuffer(name string, text []byte) *File {
+	file := NewFile(name, "", text)
+	file.scratch = true
+	med.files.PushBack(file)
+	med.file = med.files.Back()
+	return file
+}
+
+func scratchBuffer(med *Med, file *File) {
+	med.NewScratchBuffer("*scratch*", []byte(""))
+}
+
 func saveFile(med *Med, file *File) {
-	if file.path == "" {
-		med.saveAs()
-	} else {
-		err := file.Save()
-		if err != nil {
+	if file.scratch || file.path == "" {
+		med.saveAs(nil, nil)
+	} else if err := med.saveBuffer(file); err != nil {
+		med.pushError(err)
+	}
+}
+
+// Whether the buffer should be saved before quitting. Scratch buffers are
+// only considered with promptScratch.
+func (file *File) needsSave() bool {
+	return file.modified && !file.readOnly && (!file.scratch || promptScratch)
+}
+
+// Save all modified buffers. Buffers without a file need save as and are
+// left alone.
+func saveAll(med *Med, file *File) {
+	for e := med.files.Front(); e != nil; e = e.Next() {
+		f := e.Value.(*File)
+		if f.needsSave() && f.path != "" {
+			if err := med.saveBuffer(f); err != nil {
+				med.pushError(err)
+			}
+		}
+	}
+}
+
+// Ask whether to save each modified buffer, then quit.
+func quit(med *Med, file *File) {
+	med.quitting = true
+	med.quitPrompt(med.files.Front(), false)
+}
+
+// Go through modified buffers starting at e. If all is set, save them without asking.
+func (med *Med) quitPrompt(e *list.Element, all bool) {
+	for e != nil && !e.Value.(*File).needsSave() {
+		e = e.Next()
+	}
+	if e == nil {
+		med.quit = true
+		return
+	}
+	f := e.Value.(*File)
+	med.file = e
+	next := func() {
+		med.quitPrompt(e.Next(), all)
+	}
+	// Quitting is off if the buffer isn't saved.
+	stop := func() {
+		med.quitting = false
+	}
+	save := func() {
+		if f.path == "" {
+			med.saveAs(next, stop)
+		} else if err := med.saveBuffer(f); err != nil {
+			med.quitting = false
 			med.pushError(err)
+		} else {
+			next()
+		}
+	}
+	if all {
+		save()
+		return
+	}
+	update := func() {
+		if a := string(med.dialog.file.text.Bytes()); len(a) == 1 && strings.Contains("ynadc", a) {
+			med.dialog.finish(false)
+		} else {
+			med.dialog.file.Clear()
 		}
 	}
+	finish := func(cancel bool) {
+		switch string(med.dialog.file.text.Bytes()) {
+		case "y":
+			save()
+		case "n":
+			next()
+		case "a":
+			all = true
+			save()
+		case "d":
+			med.quit = true
+		case "":
+			if !cancel {
+				med.quitPrompt(e, all)
+				return
+			}
+			fallthrough
+		default:
+			med.quitting = false
+		}
+	}
+	prompt := fmt.Sprintf("save %s? [y]es [n]o [a]ll [d]iscard all [c]ancel", f.name)
+	med.startDialog(prompt, update, finish, Helm{})
 }
+
 func switchVisuals(med *Med, file *File) {
 	showVisuals = !showVisuals
 	file.view.visual = NewVisual(showVisuals)
@@ -535,10 +1023,21 @@ func switchSyntax(med *Med, file *File) {
 func (med *Med) pointToView(file *File, down int) {
 	p := file.view.start
 	for i := 0; i < down; i++ {
-		_, p = visualLineEnd(file.text, p, file.view.visual.tabStop, file.view.width)
+		_, p = buffer.VisualLineEnd(file.text.Bytes(), p, file.view.visual.tabStop, file.view.width)
 	}
 	file.Goto(p)
 }
+
+// Move the point to the nearest row of the view, if it's out of it, so the view
+// is not scrolled back on redraw.
+func (med *Med) pointIntoView(file *File) {
+	view := &file.view
+	if file.point.Off < view.start {
+		file.Goto(view.start)
+	} else if end := view.PositionAt(file.text.Bytes(), view.height, 0); end < file.text.Len() && file.point.Off >= end {
+		med.pointToView(file, view.height-1)
+	}
+}
 func pointToViewTop(med *Med, file *File) {
 	med.pointToView(file, 0)
 }
@@ -549,31 +1048,57 @@ func pointToViewBottom(med *Med, file *File) {
 	med.pointToView(file, file.view.height-1)
 }
 func viewToPointTop(med *Med, file *File) {
-	file.view.ToPoint(file.text, file.point.off, 0)
+	file.view.ToPoint(file.text.Bytes(), file.point.Off, 0)
 }
 func viewToPointMiddle(med *Med, file *File) {
-	file.view.ToPoint(file.text, file.point.off, file.view.height/2)
+	file.view.ToPoint(file.text.Bytes(), file.point.Off, file.view.height/2)
 }
 func viewToPointBottom(med *Med, file *File) {
-	file.view.ToPoint(file.text, file.point.off, file.view.height-1)
+	file.view.ToPoint(file.text.Bytes(), file.point.Off, file.view.height-1)
 }
 
-func (med *Med) samExecute(file *File, addr *sam.Address, cmdList []*sam.Command) error {
-	dot := Dot{file.point.off, file.point.off}
+// Dot a sam command starts with, the point or the selection, unless there is
+// an address.
+func (med *Med) samDot(file *File, addr *sam.Address) Dot {
+	dot := Dot{file.point.Off, file.point.Off}
 	if med.selection.active {
 		dot.start, dot.end = med.selectionRange(file)
 	}
 	// Address always takes effect, even though selection might be active.
 	if addr != nil {
-		dot.start, dot.end = file.samAddress(addr)
+		start := file.samAddress(addr, dot)
 		if addr.End != nil {
-			_, dot.end = file.samAddress(addr.End)
+			if addr.Semi {
+				dot = start
+			}
+			start.end = file.samAddress(addr.End, dot).end
 		}
+		dot = start
 		dot.end = max(dot.start, dot.end)
 	}
+	return dot
+}
+
+func (med *Med) samExecute(file *File, addr *sam.Address, cmdList []*sam.Command) error {
+	dot := med.samDot(file, addr)
 	if len(cmdList) > 0 {
+		// All the commands are undone at once.
+		file.BeginUndoGroup()
+		defer file.EndUndoGroup()
 		var err error
-		dot, err = file.samExecuteCommandList(cmdList, dot)
+		for _, cmd := range cmdList {
+			if cmd.Name == "X" || cmd.Name == "Y" {
+				err = med.samExecuteBuffers(cmd)
+				// The active buffer may have changed under dot.
+				dot.start, dot.end = min(dot.start, file.text.Len()), min(dot.end, file.text.Len())
+			} else {
+				dot, _, err = file.samExecuteCommand(cmd, dot)
+			}
+			if err != nil {
+				break
+			}
+		}
+		med.showSamOutput(file)
 		if err != nil {
 			return err
 		}
@@ -585,25 +1110,108 @@ func (med *Med) samExecute(file *File, addr *sam.Address, cmdList []*sam.Command
 	return nil
 }
 
-func samCommand(med *Med, file *File) {
-	update := func() {}
-	finish := func(cancel bool) {
-		if cancel || len(med.dialog.file.text) < 1 {
-			return
+// Run the command following X on the whole text of every buffer whose name
+// matches the regexp, or Y of every buffer whose name doesn't match. Buffers
+// of files are matched by their path. Read-only buffers are left alone. With
+// no command, the names of the buffers are shown.
+func (med *Med) samExecuteBuffers(cmd *sam.Command) error {
+	re, err := regexp.Compile(cmd.Arg)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for e := med.files.Front(); e != nil; e = e.Next() {
+		f := e.Value.(*File)
+		name := f.name
+		if f.path != "" {
+			name = f.path
+		}
+		if f.readOnly || re.MatchString(name) != (cmd.Name == "X") {
+			continue
+		}
+		names = append(names, f.name)
+		if cmd.Next == nil {
+			continue
 		}
+		_, _, err := f.samExecuteCommand(cmd.Next, Dot{0, f.text.Len()})
+		f.UndoBlock()
+		if e != med.file {
+			med.showSamOutput(f)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.name, err)
+		}
+	}
+	if cmd.Next == nil {
+		med.showMessage("%s", strings.Join(names, " "))
+	} else {
+		med.showMessage("%d buffers", len(names))
+	}
+	return nil
+}
+
+// Show the output of the sam > and ! commands, as a message if it's a single
+// line, or in the *sam* buffer otherwise. The line interface just prints it.
+func (med *Med) showSamOutput(file *File) {
+	out := bytes.TrimRight(file.samOutput, "\n")
+	file.samOutput = nil
+	if len(out) == 0 {
+		return
+	}
+	if med.ctlOutput != nil {
+		med.ctlOutput.Write(append(out, '\n'))
+		return
+	}
+	if med.lines {
+		fmt.Printf("%s\n", out)
+		return
+	}
+	if bytes.IndexByte(out, '\n') < 0 {
+		med.showMessage("%s", out)
+		return
+	}
+	med.showBuffer(med.outputBuffer("*sam*", append(out, '\n')))
+}
+
+func samCommand(med *Med, file *File) {
+	if previewSam {
+		med.samDialog(file, med.samExecuteOrPreview)
+	} else {
+		med.samDialog(file, med.samExecute)
+	}
+}
+
+func samPreview(med *Med, file *File) {
+	med.samDialog(file, med.samExecutePreview)
+}
+
+// Ask for sam commands and run them on file with execute. Commands given as
+// arguments run without asking.
+func (med *Med) samDialog(file *File, execute func(*File, *sam.Address, []*sam.Command) error) {
+	run := func(text []byte) {
 		var p sam.Parser
-		p.Init(med.dialog.file.text)
+		p.Init(text)
 		addr, cmdList, err := p.Parse()
 		if err != nil {
 			med.pushError(err)
 			return
 		}
-		err = med.samExecute(file, addr, cmdList)
-		if err != nil {
+		if err := execute(file, addr, cmdList); err != nil {
 			med.pushError(err)
 			return
 		}
 	}
+	if len(med.args) > 0 {
+		run([]byte(strings.Join(med.args, " ")))
+		return
+	}
+	update := func() {}
+	finish := func(cancel bool) {
+		if cancel || med.dialog.file.text.Len() < 1 {
+			return
+		}
+		run(med.dialog.file.text.Bytes())
+	}
 	med.startDialog("sam", update, finish, Helm{})
 }
 
@@ -621,18 +1229,18 @@ func switchBuffer(med *Med, file *File) {
 			return
 		}
 		for f := med.files.Front(); f != nil; f = f.Next() {
-			if f.Value.(*File).name == string(med.dialog.file.text) {
+			if f.Value.(*File).name == string(med.dialog.file.text.Bytes()) {
 				med.file = f
 				return
 			}
 		}
-		med.pushError(errors.New("buffer not found: " + string(med.dialog.file.text)))
+		med.pushError(errors.New("buffer not found: " + string(med.dialog.file.text.Bytes())))
 	}
 	complete := func() {
 		var data []string
 		for f := med.files.Front(); f != nil; f = f.Next() {
 			name := f.Value.(*File).name
-			if strings.Contains(name, string(med.dialog.file.text)) {
+			if strings.Contains(name, string(med.dialog.file.text.Bytes())) {
 				data = append(data, name)
 			}
 		}
@@ -640,17 +1248,40 @@ func switchBuffer(med *Med, file *File) {
 	}
 	med.startDialog("buffer", update, finish, NewHelm(complete))
 }
+func nextBuffer(med *Med, file *File) {
+	if med.file = med.file.Next(); med.file == nil {
+		med.file = med.files.Front()
+	}
+}
+func prevBuffer(med *Med, file *File) {
+	if med.file = med.file.Prev(); med.file == nil {
+		med.file = med.files.Back()
+	}
+}
 func closeBuffer(med *Med, file *File) {
 	if med.files.Len() == 1 {
 		med.pushError(errors.New("refusing to close last buffer"))
 		return
 	}
+	removed := med.file
+	if file.shell != nil {
+		// The shell exits when its input is closed.
+		file.shell.stdin.Close()
+	}
+	if keepPositions {
+		rememberPositions(file)
+	}
+	med.watcher.unwatch(file.path)
 	f := med.file.Next()
 	med.files.Remove(med.file)
 	if f == nil {
 		f = med.files.Back()
 	}
 	med.file = f
+	med.closeBufferWindows(removed)
+	if med.diff != nil && (med.diff.a == removed || med.diff.b == removed) {
+		med.diff = nil
+	}
 }
 func godoc(med *Med, file *File) {
 	update := func() {}
@@ -658,7 +1289,7 @@ func godoc(med *Med, file *File) {
 		if cancel {
 			return
 		}
-		arg := string(med.dialog.file.text)
+		arg := string(med.dialog.file.text.Bytes())
 		out, err := exec.Command("godoc", arg).Output()
 		if err != nil {
 			med.pushError(err)
@@ -669,14 +1300,12 @@ func godoc(med *Med, file *File) {
 			med.pushError(errors.New(fmt.Sprintf("godoc %s: docs not found", arg)))
 			return
 		}
-		file := NewFile("godoc "+arg, "", out)
-		med.files.PushBack(file)
-		med.file = med.files.Back()
+		med.NewScratchBuffer("godoc "+arg, out)
 	}
 	complete := func() {
 		var data []string
 		for _, str := range goPackages {
-			if strings.Contains(str, string(med.dialog.file.text)) {
+			if strings.Contains(str, string(med.dialog.file.text.Bytes())) {
 				data = append(data, str)
 			}
 		}
@@ -688,16 +1317,16 @@ func godoc(med *Med, file *File) {
 //// Dialog mode commands.
 
 func dialogPointRight(med *Med, file *File) {
-	med.dialog.file.point.Right(med.dialog.file.text, tabStop)
+	med.dialog.file.point.Right(med.dialog.file.text.Bytes(), tabStop)
 }
 func dialogPointLeft(med *Med, file *File) {
-	med.dialog.file.point.Left(med.dialog.file.text, tabStop)
+	med.dialog.file.point.Left(med.dialog.file.text.Bytes(), tabStop)
 }
 func dialogPointLineEnd(med *Med, file *File) {
-	med.dialog.file.point.LineEnd(med.dialog.file.text, tabStop)
+	med.dialog.file.point.LineEnd(med.dialog.file.text.Bytes(), tabStop)
 }
 func dialogPointLineStart(med *Med, file *File) {
-	med.dialog.file.point.LineStart(med.dialog.file.text, false)
+	med.dialog.file.point.LineStart(med.dialog.file.text.Bytes(), false)
 }
 func dialogDeleteChar(med *Med, file *File) {
 	med.dialog.file.DeleteChar()
@@ -720,6 +1349,9 @@ func helmRotate(d *Dialog, inc int) {
 	}
 	d.file.Clear()
 	d.file.Insert([]byte(d.helm.data[d.helm.index]))
+	if d.selected != nil {
+		d.selected()
+	}
 }
 
 func dialogHelmNext(med *Med, file *File) {
@@ -728,6 +1360,23 @@ func dialogHelmNext(med *Med, file *File) {
 func dialogHelmPrev(med *Med, file *File) {
 	helmRotate(med.dialog, -1)
 }
+func dialogTab(med *Med, file *File) {
+	if med.dialog.tab != nil {
+		med.dialog.tab()
+	} else {
+		helmRotate(med.dialog, 1)
+	}
+}
+
+// Reopen the last dialog with a helm, as it was when it was closed.
+func helmResume(med *Med, file *File) {
+	if med.lastHelm == nil {
+		med.pushError(errors.New("no helm to resume"))
+		return
+	}
+	med.dialog = med.lastHelm
+	med.mode = DialogMode
+}
 func dialogCancel(med *Med, file *File) {
 	med.dialog.finish(true)
 }
@@ -735,9 +1384,31 @@ func dialogFinish(med *Med, file *File) {
 	med.dialog.finish(false)
 }
 
+//// Pager mode commands.
+
+func pagerLineDown(med *Med, file *File) {
+	file.view.ScrollDown(file.text.Bytes())
+	pointToViewTop(med, file)
+}
+func pagerLineUp(med *Med, file *File) {
+	file.view.ScrollUp(file.text.Bytes())
+	pointToViewTop(med, file)
+}
+
+// Close the buffer and leave the pager. Quit if it was started as a pager
+// and this is the last buffer.
+func pagerQuit(med *Med, file *File) {
+	if med.files.Len() > 1 {
+		closeBuffer(med, file)
+	} else if med.baseMode == PagerMode {
+		med.quit = true
+	}
+	med.mode = med.restMode()
+}
+
 func selectionMode(med *Med, file *File) {
 	med.mode = SelectionMode
-	med.selection = Selection{true, CharSelection, file.point.off, file.point.off}
+	med.selection = Selection{true, CharSelection, file.point.Off, file.point.Off}
 }
 func selectionSwapEnd(med *Med, file *File) {
 	med.selection.point, med.selection.anchor = med.selection.anchor, med.selection.point
@@ -749,13 +1420,13 @@ func selectionSearch(med *Med, file *File) {
 	med.searchctx = &SearchContext{
 		point: file.point,
 		view:  file.view,
-		last:  append([]byte(nil), file.text[off:end]...),
+		last:  append([]byte(nil), file.text.Slice(off, end)...),
 	}
 	file.SearchNext(med.searchctx.last, true)
 }
 
 func selectWord(med *Med, file *File) {
-	a, p, ok := markWord(file.text, file.point.off)
+	a, p, ok := file.markWord(file.point.Off)
 	if ok {
 		med.mode = SelectionMode
 		med.selection = Selection{true, CharSelection, p, a}
@@ -763,7 +1434,7 @@ func selectWord(med *Med, file *File) {
 	}
 }
 func selectString(med *Med, file *File) {
-	a, p, ok := markString(file.text, file.point.off)
+	a, p, ok := markString(file.text.Bytes(), file.point.Off)
 	if ok {
 		med.mode = SelectionMode
 		med.selection = Selection{true, CharSelection, p, a}
@@ -771,7 +1442,7 @@ func selectString(med *Med, file *File) {
 	}
 }
 func selectBlock(med *Med, file *File) {
-	a, p, ok := markBlock(file.text, file.point.off)
+	a, p, ok := file.markBlock(file.point.Off)
 	if ok {
 		med.mode = SelectionMode
 		med.selection = Selection{true, CharSelection, p, a}
@@ -779,6 +1450,37 @@ func selectBlock(med *Med, file *File) {
 	}
 }
 
+func (med *Med) selectLine(file *File, newline bool) {
+	a, p := buffer.LineRange(file.text.Bytes(), file.point.Off, newline)
+	med.mode = SelectionMode
+	med.selection = Selection{true, CharSelection, p, a}
+	file.Goto(p)
+}
+func selectLine(med *Med, file *File) {
+	med.selectLine(file, true)
+}
+func selectLineExclusive(med *Med, file *File) {
+	med.selectLine(file, false)
+}
+
+// Move the selection point past the newline ending its line.
+func selectNextLine(med *Med, file *File) {
+	_, p := buffer.LineRange(file.text.Bytes(), med.selection.point, true)
+	med.selection.point = p
+	file.Goto(p)
+}
+
+// Move the selection point to the start of its line, or of the previous line
+// if it already is at one.
+func selectPrevLine(med *Med, file *File) {
+	p := med.selection.point
+	if p == buffer.LineStart(file.text.Bytes(), p) {
+		p = max(0, p-1)
+	}
+	med.selection.point = buffer.LineStart(file.text.Bytes(), p)
+	file.Goto(med.selection.point)
+}
+
 func selectionChange(med *Med, file *File) {
 	if med.selection.sel == CharSelection {
 		med.selection.sel = LineSelection
@@ -790,39 +1492,79 @@ func selectionChange(med *Med, file *File) {
 func clipCopy(med *Med, file *File) {
 	if med.mode == SelectionMode {
 		off, end := med.selectionRange(file)
-		med.clip = append([]byte(nil), file.text[off:end]...)
+		kind := clipKind(med.selection.sel)
+		med.setClip(append([]byte(nil), file.text.Slice(off, end)...), kind)
 	} else {
-		med.clip = file.CopyLine()
+		med.setClip(file.CopyLine(), ClipLines)
 	}
 	commandMode(med, file)
 }
 
+// Paste the clip. Linewise clips are pasted above the current line, wherever
+// the point is in it, and if reindentPaste is set, they are reindented to
+// match it. Other clips are pasted at the point.
 func clipPaste(med *Med, file *File) {
-	if med.clip != nil {
-		file.Insert(med.clip)
+	clip := med.clip
+	if clip.text == nil {
+		return
+	}
+	switch clip.kind {
+	case ClipLines:
+		text := clip.text
+		if !bytes.HasSuffix(text, NL) {
+			// The last line of a file may have no newline.
+			text = append(append([]byte(nil), text...), NL...)
+		}
+		if reindentPaste {
+			text = buffer.Reindent(text, buffer.LineIndentText(file.text.Bytes(), file.point.Off))
+		}
+		file.Goto(buffer.LineStart(file.text.Bytes(), file.point.Off))
+		file.Insert(text)
+	default:
+		file.Insert(clip.text)
 	}
 }
 
+// Replace the selection with the clip. The clip itself is kept, so that
+// it can replace more selections. If replaceLinewise is set, linewise clips
+// replace all lines the selection touches.
+func clipReplace(med *Med, file *File) {
+	clip := med.clip
+	if clip.text == nil {
+		return
+	}
+	off, end := med.selectionRange(file)
+	if replaceLinewise && clip.kind == ClipLines {
+		_, end = buffer.LineRange(file.text.Bytes(), max(off, end-1), true)
+		off = buffer.LineStart(file.text.Bytes(), off)
+	}
+	file.Delete(off, end)
+	file.Insert(clip.text)
+	commandMode(med, file)
+}
+
 func clipCut(med *Med, file *File) {
 	if med.mode == SelectionMode {
 		off, end := med.selectionRange(file)
-		med.clip = file.Delete(off, end)
+		kind := clipKind(med.selection.sel)
+		med.setClip(file.Delete(off, end), kind)
 	} else {
-		med.clip = file.DeleteLine(true)
+		med.setClip(file.DeleteLine(true), ClipLines)
 	}
 	commandMode(med, file)
 }
 
 func clipChange(med *Med, file *File) {
 	off, end := med.selectionRange(file)
-	med.clip = file.Delete(off, end)
+	kind := clipKind(med.selection.sel)
+	med.setClip(file.Delete(off, end), kind)
 	med.mode = EditingMode
 	med.selection.active = false
 }
 
 func (med *Med) selectionUpdate(file *File) {
 	if med.selection.active {
-		med.selection.point = file.point.off
+		med.selection.point = file.point.Off
 	}
 }
 
@@ -833,7 +1575,8 @@ func (med *Med) selectionRange(file *File) (start, end int) {
 	}
 	if med.selection.sel == LineSelection {
 		// This will be called every cursor move, which might be slow...
-		start, end = lineStart(file.text, start), min(len(file.text), lineEnd(file.text, end)+1)
+		start, _ = buffer.LineRange(file.text.Bytes(), start, true)
+		_, end = buffer.LineRange(file.text.Bytes(), end, true)
 	}
 	return
 }
@@ -854,8 +1597,8 @@ func (med *Med) search(file *File, forward bool) {
 	mode := med.mode
 	med.searchctx = &SearchContext{point: file.point, view: file.view}
 	update := func() {
-		med.searchctx.last = append([]byte(nil), med.dialog.file.text...)
-		if i := textSearch(file.text, med.searchctx.last, med.searchctx.point.off, forward); i >= 0 {
+		med.searchctx.last = append([]byte(nil), med.dialog.file.text.Bytes()...)
+		if i := buffer.Search(file.text.Bytes(), med.searchctx.last, med.searchctx.point.Off, forward); i >= 0 {
 			file.Goto(i)
 			med.selectionUpdate(file)
 		} else {
@@ -872,81 +1615,197 @@ func (med *Med) search(file *File, forward bool) {
 }
 
 func (med *Med) searchNext(file *File, forward bool) {
-	if med.searchctx == nil || len(med.searchctx.last) == 0 {
+	if med.searchctx == nil {
+		return
+	}
+	if re := med.searchctx.re; re != nil {
+		off := file.point.Off + 1
+		if !forward {
+			off = file.point.Off
+		}
+		if start, end := file.searchRegexp(re, off, forward); start >= 0 {
+			med.flash = &Dot{start, end}
+		}
+		return
+	}
+	if len(med.searchctx.last) == 0 {
 		return
 	}
 	file.SearchNext(med.searchctx.last, forward)
 }
 
-func (med *Med) load() {
+// Search for a regular expression as it's typed. The match is flashed.
+func (med *Med) searchRegexp(file *File, forward bool) {
+	prompt := "regexp →"
+	if !forward {
+		prompt = "regexp ←"
+	}
+	mode := med.mode
+	med.searchctx = &SearchContext{point: file.point, view: file.view}
+	update := func() {
+		ctx := med.searchctx
+		re, err := compileSearch(string(med.dialog.file.text.Bytes()))
+		if err != nil || med.dialog.file.text.Len() == 0 {
+			ctx.re = nil
+			med.restoreSearchContext(file)
+			return
+		}
+		ctx.re = re
+		file.point = ctx.point
+		if start, end := file.searchRegexp(re, ctx.point.Off, forward); start >= 0 {
+			med.flash = &Dot{start, end}
+			med.selectionUpdate(file)
+		} else {
+			med.restoreSearchContext(file)
+		}
+	}
+	finish := func(cancel bool) {
+		med.mode = mode
+		if cancel {
+			med.restoreSearchContext(file)
+			return
+		}
+		if _, err := compileSearch(string(med.dialog.file.text.Bytes())); err != nil {
+			med.pushError(err)
+		}
+	}
+	med.startDialog(prompt, update, finish, Helm{})
+}
+
+func searchRegexpForward(med *Med, file *File) {
+	med.searchRegexp(file, true)
+}
+func searchRegexpBackward(med *Med, file *File) {
+	med.searchRegexp(file, false)
+}
+
+// Expand a leading ~ to the home directory.
+func expandHome(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		return os.Getenv("HOME") + p[1:]
+	}
+	return p
+}
+
+// Start the load dialog in dir. The helm lists entries of the directory being
+// typed, directories end with a slash and Tab descends into them.
+func (med *Med) load(dir string) {
 	update := func() {}
 	finish := func(cancel bool) {
 		if cancel {
 			return
 		}
-		file, err := LoadFile(string(med.dialog.file.text))
+		name := string(med.dialog.file.text.Bytes())
+		if st, err := os.Stat(expandHome(name)); err == nil && st.IsDir() {
+			if !strings.HasSuffix(name, "/") {
+				name += "/"
+			}
+			med.load(name)
+			return
+		}
+		file, err := LoadFile(expandHome(name))
 		if err != nil {
 			med.pushError(err)
 		} else {
-			file.tabStop = tabStop
-			med.files.PushBack(file)
-			med.file = med.files.Back()
+			med.addFile(file)
 		}
 	}
-	// File path completion is quite primitive, but good enough for now.
-	// By default, files in the current directory are shown. If the dialog
-	// line contains at least one slash, it's considered path to a directory
-	// and the search continues there.
 	complete := func() {
-		var files []os.FileInfo
 		var data []string
 		d := med.dialog
-		line := string(d.file.text)
-		dir, file := path.Split(line)
-		if dir == "" {
-			dir = "."
-		}
-		if st, err := os.Stat(dir); err == nil && st.IsDir() {
-			files, err = ioutil.ReadDir(dir)
-			if err != nil {
-				d.helm.data = data
-				return
-			}
+		dir, prefix := path.Split(string(d.file.text.Bytes()))
+		rdir := expandHome(dir)
+		if rdir == "" {
+			rdir = "."
 		}
+		files, _ := ioutil.ReadDir(rdir)
 		for _, fi := range files {
-			if strings.Contains(fi.Name(), file) {
-				f := fi.Name()
-				if dir != "." {
-					f = dir + f
+			name := fi.Name()
+			// Hidden files only when asked for.
+			if strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".") ||
+				!strings.Contains(name, prefix) {
+				continue
+			}
+			if fi.Mode()&os.ModeSymlink != 0 {
+				if st, err := os.Stat(path.Join(rdir, name)); err == nil {
+					fi = st
 				}
-				data = append(data, f)
 			}
+			if fi.IsDir() {
+				name += "/"
+			}
+			data = append(data, dir+name)
 		}
 		d.helm.data = data
 	}
+	tab := func() {
+		d := med.dialog
+		sel := ""
+		if d.helm.index >= 0 {
+			sel = d.helm.data[d.helm.index]
+		} else if len(d.helm.data) == 1 {
+			sel = d.helm.data[0]
+		}
+		switch {
+		case strings.HasSuffix(sel, "/"):
+			d.file.Clear()
+			d.file.Insert([]byte(sel))
+			d.update()
+		case sel != "" && sel != string(d.file.text.Bytes()):
+			d.file.Clear()
+			d.file.Insert([]byte(sel))
+		default:
+			helmRotate(d, 1)
+		}
+	}
 	med.startDialog("load", update, finish, NewHelm(complete))
+	med.dialog.tab = tab
+	if dir != "" {
+		med.dialog.file.Insert([]byte(dir))
+		med.dialog.update()
+	}
 }
 
-func (med *Med) saveAs() {
+// Save the current buffer under a new name and call then, if not nil. If it
+// isn't saved, failed is called instead, if not nil.
+func (med *Med) saveAs(then, failed func()) {
 	update := func() {}
 	finish := func(cancel bool) {
 		if cancel {
+			if failed != nil {
+				failed()
+			}
 			return
 		}
 		file := med.file.Value.(*File)
-		path := string(med.dialog.file.text)
-		err := SaveFile(path, file.text)
+		path := string(med.dialog.file.text.Bytes())
+		// Hooks are matched against the new path.
+		old := file.path
+		file.path = path
+		med.runHooks("before-save", file)
+		err := SaveFile(path, file.text.Bytes())
 		if err != nil {
+			file.path = old
 			med.pushError(err)
+			if failed != nil {
+				failed()
+			}
 		} else {
 			file.name = path
-			file.path = path
+			file.scratch = false
+			file.modified = false
+			med.applyLocals(file)
+			med.runHooks("after-save", file)
+			med.runHooks("filetype", file)
+			if then != nil {
+				then()
+			}
 		}
 	}
 	med.startDialog("save as", update, finish, Helm{})
 }
 
-func (med *Med) statusLine(pline, px int) string {
+func (med *Med) statusLine(file *File, active bool) string {
 	var m string
 	switch med.mode {
 	case CommandMode:
@@ -963,20 +1822,39 @@ func (med *Med) statusLine(pline, px int) string {
 		m = "[d]"
 	case ErrorMode:
 		m = "[err]"
+	case PagerMode:
+		m = "[p]"
 	default:
 		m = "[unk]"
 	}
-	file := med.file.Value.(*File)
+	if !active {
+		m = "   "
+	}
 	e := ""
 	if file.modified {
 		e = "🖉"
+	} else if file.readOnly {
+		e = "%"
 	}
 	var ks string
-	if len(med.keyseq) > 0 {
+	if active && len(med.keyseq) > 0 {
 		ks = "|" + med.keyseq + "|"
 	}
+	if active {
+		for _, j := range med.jobs {
+			ks += " [" + j.name + "]"
+		}
+		if c := med.clip.String(); c != "" {
+			ks += " ⎘" + c
+		}
+	}
+	name := file.name
+	if file.large {
+		name += " large"
+	}
+	pline, px := file.point.Line+1, file.point.Column(file.text.Bytes(), tabStop)
 	return fmt.Sprintf("%s %1s %s  %d:%d %s",
-		m, e, file.name, pline, px, ks)
+		m, e, name, pline, px, ks)
 }
 
 // Whenever med.mode is set to ErrorMode, there is always at least one
@@ -986,11 +1864,25 @@ func (med *Med) pushError(e error) {
 	med.errors.PushFront(e)
 }
 
+// Show a message in the last row until the next key press.
+func (med *Med) showMessage(format string, a ...interface{}) {
+	med.message = fmt.Sprintf(format, a...)
+}
+
 func (med *Med) popError() {
 	med.errors.Remove(med.errors.Front())
 	if med.errors.Len() == 0 {
-		med.mode = CommandMode
+		med.mode = med.restMode()
+	}
+}
+
+// Mode to return to in the current buffer after dialogs and errors. Pager
+// buffers stay in the pager even if med was started as an editor.
+func (med *Med) restMode() int {
+	if med.file != nil && med.file.Value.(*File).pager {
+		return PagerMode
 	}
+	return med.baseMode
 }
 
 func (med *Med) displayDialog(t *term.Term, y int) {
@@ -1002,17 +1894,17 @@ func (med *Med) displayDialog(t *term.Term, y int) {
 	theme["normal"].Out(t)
 	t.Write([]byte(" "))
 	// Before the point.
-	off := file.point.off
-	t.Write(file.text[:off])
-	if off < len(file.text) {
+	off := file.point.Off
+	t.Write(file.text.Slice(0, off))
+	if off < file.text.Len() {
 		// Point.
-		_, s := utf8.DecodeRune(file.text[off:])
+		_, s := utf8.DecodeRune(file.text.Bytes()[off:])
 		s += off
 		theme["point"].Out(t)
-		t.Write(file.text[off:s])
+		t.Write(file.text.Slice(off, s))
 		theme["normal"].Out(t)
 		// After the point.
-		t.Write(file.text[s:])
+		t.Write(file.text.Bytes()[s:])
 	} else {
 		// Point.
 		theme["point"].Out(t)
@@ -1020,18 +1912,35 @@ func (med *Med) displayDialog(t *term.Term, y int) {
 	}
 }
 
-// BUG: Displays only the beginning of the list, no matter where helm index is.
+// Whether items fit on a line of the given width.
+func helmFits(items []string, cols int) bool {
+	col := 4 // Length of "[ " + " ]".
+	for _, item := range items {
+		col += utf8.RuneCount([]byte(item)) + 1
+	}
+	return col <= cols
+}
+
 func (med *Med) displayHelm(t *term.Term, y int) {
 	tcols := term.Cols()
+	h := &med.dialog.helm
+	// Scroll the list so the selected item is visible.
+	if h.index >= 0 && h.index < h.scroll {
+		h.scroll = h.index
+	}
+	for h.index >= 0 && h.scroll < h.index && !helmFits(h.data[h.scroll:h.index+1], tcols) {
+		h.scroll++
+	}
 	str := "[ "
 	col := 4 // Length of "[ " + " ]".
-	for i, item := range med.dialog.helm.data {
+	for i := h.scroll; i < len(h.data); i++ {
+		item := h.data[i]
 		n := utf8.RuneCount([]byte(item))
 		col += n + 1
 		if col > tcols {
 			break
 		}
-		if med.dialog.helm.index == i {
+		if h.index == i {
 			// This piece deserves to be rewritten...
 			on, off := solarizedPalette["magenta"], solarizedPalette["base00"]
 			attrOn := fmt.Sprintf("\033[38;2;%d;%d;%dm", on.R, on.G, on.B)
@@ -1047,7 +1956,11 @@ func (med *Med) displayHelm(t *term.Term, y int) {
 	t.Write([]byte(str))
 }
 
-func (med *Med) init(args []string) {
+func (med *Med) init(args []string, pager bool) {
+	if pager {
+		med.mode = PagerMode
+		med.baseMode = PagerMode
+	}
 	if len(args) == 0 {
 		med.files.PushBack(EmptyFile())
 		med.file = med.files.Front()
@@ -1059,8 +1972,9 @@ func (med *Med) init(args []string) {
 			med.pushError(err)
 			continue
 		}
-		file.tabStop = tabStop
-		med.files.PushBack(file)
+		file.readOnly = pager
+		file.pager = pager
+		med.addFile(file)
 	}
 	if med.files.Len() == 0 {
 		for e := med.errors.Front(); e != nil; e = e.Next() {
@@ -1076,96 +1990,212 @@ func main() {
 		files:     list.New(),
 		file:      nil,
 		mode:      CommandMode,
+		baseMode:  CommandMode,
 		dialog:    nil,
 		searchctx: nil,
 		selection: Selection{},
 		errors:    list.New(),
 		keyseq:    "",
-		clip:      nil,
+		clip:      Clip{},
+		config:    NewConfig(configDir()),
+		events:    make(chan Event),
+		watcher:   newFileWatcher(),
 	}
-	med.init(os.Args[1:])
+	pager := flag.Bool("p", false, "view files read-only, like a pager")
+	inlineFlag := flag.Bool("inline", false, "use only the bottom rows of the terminal, keeping what's above")
+	keys := flag.String("keys", "", "play back keys from `file` and print the current buffer, without a terminal")
+	flag.Parse()
+	// Load the configuration before any files, so their hooks run.
+	med.reloadConfig()
+	if *keys != "" {
+		// Positions from earlier sessions would change the result.
+		keepPositions = false
+	}
+	med.init(flag.Args(), *pager)
+	med.windows = []*list.Element{med.file}
+	if *keys != "" {
+		if err := med.playKeys(*keys); err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(med.file.Value.(*File).text.Bytes())
+		return
+	}
+	defer med.savePositions()
+	defer shutdownGopls()
 
-	err := term.SetRaw()
-	if err != nil {
+	if dumbTerminal() {
+		med.lineMode()
+		return
+	}
+	if err := term.SetRaw(); err != nil {
 		term.Restore()
-		log.Fatal(err)
+		fmt.Fprintf(os.Stderr, "med: %v, using the line interface\n", err)
+		med.lineMode()
+		return
 	}
 
+	if inline || *inlineFlag {
+		term.Inline(inlineRows)
+	}
 	t := term.NewTerm()
 	t.Init()
 	defer t.Finish()
-
-	b := make([]byte, 8)
-	for {
-		file := med.file.Value.(*File)
-		theme["normal"].Out(t)
-		t.EraseDisplay()
-
-		var highlights []Highlight
-		var selections []Highlight
-		if med.selection.active {
-			ss, se := med.selectionRange(file)
-			selections = append(selections, Highlight{ss, se, theme["selection"]})
+	if useMouse {
+		t.MouseOn()
+	}
+	t.FocusOn()
+	t.SetMaxFPS(maxFps)
+
+	go readKeys(med.events)
+	go watchResize(med.events)
+	go med.watcher.run(med.events, med.config)
+	if l := med.listenCtl(); l != nil {
+		defer l.Close()
+	}
+	med.addIdleTask("autoSave", autoSaveTask)
+	med.addIdleTask("idleHooks", idleHooksTask)
+	med.addIdleTask("diagnostics", diagnosticsTask)
+	med.keyPressed()
+	for !med.quit {
+		med.syncWindows()
+		if med.diff != nil {
+			med.diff.update()
 		}
+		med.display(t)
 
-		file.view.AdjustToPoint(file.text, file.point.off)
-		if showSyntax {
-			highlights = getSyntax(file.text, file.view.start, file.view.height)
+		var ev Event
+		select {
+		case ev = <-med.events:
+		case <-med.idleTimer():
+			ev = IdleEvent{}
 		}
-		// TODO: Redraw only when cursor moves off screen or on insert/delete.
-		file.view.DisplayText(t, file.text, file.point.off, selections, highlights)
+		med.dispatch(ev)
+		// Events coming faster than frames, like keys of a paste, are handled
+		// before the next frame, instead of drawing frames nobody sees.
+		for wait := t.NextFrame(); wait > 0 && !med.quit; wait = t.NextFrame() {
+			select {
+			case ev = <-med.events:
+				med.dispatch(ev)
+			case <-time.After(wait):
+			}
+		}
+	}
+}
+
+func (med *Med) display(t *term.Term) {
+	t.BeginFrame()
+	theme["normal"].Out(t)
+	t.EraseDisplay()
 
-		px := file.point.Column(file.text, tabStop)
-		pl := file.point.line
+	med.layout()
+	for i, e := range med.windows {
+		med.displayWindow(t, e.Value.(*File), i == med.window)
+	}
+	if med.preview != nil {
+		med.displayPreview(t)
+	}
+	if med.outline != nil {
+		med.displayOutline(t, term.Rows()-1)
+	}
+	t.AttrReset()
+	// The last row is for dialogs and errors, the helm covers the status line above it.
+	bottom := term.Rows() - 1
+	if med.mode == DialogMode {
+		med.displayDialog(t, bottom)
+	}
+	if med.mode == ErrorMode {
+		e := med.errors.Front().Value.(error)
+		t.MoveTo(bottom, 0)
+		theme["error"].Out(t)
+		t.Write([]byte(fmt.Sprintf("%v", e)))
 		t.AttrReset()
-		status := med.statusLine(pl+1, px)
-		if med.mode == DialogMode {
-			med.displayDialog(t, file.view.height+2)
-		}
-		if med.mode == ErrorMode {
-			e := med.errors.Front().Value.(error)
-			t.MoveTo(file.view.height+2, 0)
-			theme["error"].Out(t)
-			t.Write([]byte(fmt.Sprintf("%v", e)))
-			t.AttrReset()
-		}
-		t.MoveTo(file.view.height, 0)
-		if med.mode == DialogMode && med.dialog.helm.active {
-			med.displayHelm(t, file.view.height+1)
-		} else {
-			theme["status"].Out(t)
-			t.EraseEol()
-			t.Write([]byte(status))
-		}
-		t.Flush()
+	}
+	if med.mode == DialogMode && med.dialog.helm.active {
+		t.MoveTo(bottom-1, 0)
+		med.displayHelm(t, bottom)
+	}
+	if med.message != "" && med.mode != DialogMode && med.mode != ErrorMode {
+		t.MoveTo(bottom, 0)
+		t.Write([]byte(med.message))
+	}
+	if med.popup != nil {
+		med.popup.Display(t)
+	}
+	if showFrameStats {
+		med.displayFrameStats(t)
+	}
+	t.EndFrame()
+}
+
+// Show how long the last frame took to draw and write in the top right corner.
+func (med *Med) displayFrameStats(t *term.Term) {
+	st := t.Stats()
+	s := fmt.Sprintf(" render %v write %v frames %d skipped %d ",
+		st.Render.Round(time.Microsecond), st.Write.Round(time.Microsecond), st.Frames, st.Skipped)
+	t.MoveTo(0, max(0, term.Cols()-len(s)))
+	theme["popup"].Out(t)
+	t.Write([]byte(s))
+	t.AttrReset()
+}
+
+func toggleFrameStats(med *Med, file *File) {
+	showFrameStats = !showFrameStats
+}
 
-		n, _ := os.Stdin.Read(b)
-		if string(b[:n]) == kCtrl("q") {
+func (med *Med) handleKey(b []byte) {
+	file := med.file.Value.(*File)
+	med.flash = nil
+	med.popup = nil
+	med.message = ""
+	if c := med.completion; c != nil {
+		med.completion = nil
+		if med.completionKey(file, c, string(b)) {
 			return
 		}
-		if med.mode == ErrorMode {
-			// Any key in ErrorMode will do.
-			med.popError()
-		} else {
-			med.keyseq += string(b[:n])
-			match, v := resolveKeys(editorKeymaps[med.mode], med.keyseq)
-			switch match {
-			case Match:
-				command := v.(func(*Med, *File))
-				command(&med, file)
-				med.keyseq = ""
-			case PartialMatch:
-				break // Nothing, for now.
-			case NoMatch:
-				switch med.mode {
-				case EditingMode:
-					file.Insert(b[:n])
-				case DialogMode:
-					med.dialog.file.Insert(b[:n])
-					med.dialog.update()
-				}
-				med.keyseq = ""
-			}
+	}
+	if string(b) == kCtrl("q") {
+		// Pressing it again while being asked about modified buffers
+		// quits without saving.
+		if med.quitting {
+			med.quit = true
+			return
+		}
+		quit(med, file)
+		return
+	}
+	if strings.HasPrefix(string(b), kMouse) {
+		med.mouse(string(b))
+		return
+	}
+	if med.mode == ErrorMode {
+		// Any key in ErrorMode will do.
+		med.popError()
+		return
+	}
+	med.keyseq += string(b)
+	match, command := resolveKeys(editorKeymaps[med.mode], med.keyseq)
+	switch match {
+	case Match:
+		med.runCommand(command, file)
+		// The command may have switched buffers or changed others, like
+		// diffTakeLeft or sam's X, so the blocks of all of them are closed.
+		for e := med.files.Front(); e != nil; e = e.Next() {
+			e.Value.(*File).UndoBlock()
+		}
+		med.keyseq = ""
+	case PartialMatch:
+		if showWhichKey {
+			med.popup = NewPointPopup(file, whichKey(editorKeymaps[med.mode], med.keyseq))
+		}
+	case NoMatch:
+		switch med.mode {
+		case EditingMode:
+			med.insertTyped(file, b)
+			med.popupCompletions(file)
+		case DialogMode:
+			med.dialog.file.InsertKey(b)
+			med.dialog.update()
 		}
+		med.keyseq = ""
 	}
 }
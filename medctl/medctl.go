@@ -0,0 +1,46 @@
+// Medctl sends a request to the running editor and prints the reply:
+//
+//	medctl buffers
+//	medctl sam ',x/Println/c/Printf/'
+//	medctl insert 'hello, world
+//	'
+//
+// The arguments are joined by spaces into the argument of the request, which
+// can span lines. See ctl.go of med for the requests.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/jsynacek/med/ctl"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: medctl request [argument...]")
+		os.Exit(2)
+	}
+	req := ctl.Request(os.Args[1], strings.Join(os.Args[2:], " "))
+	c, err := net.Dial("unix", ctl.SocketPath())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "medctl:", err)
+		os.Exit(1)
+	}
+	defer c.Close()
+	fmt.Fprintf(c, "%s\n", req)
+	r := bufio.NewReader(c)
+	status, err := r.ReadString('\n')
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "medctl:", err)
+		os.Exit(1)
+	}
+	if status != "ok\n" {
+		fmt.Fprint(os.Stderr, "medctl: ", strings.TrimPrefix(status, "error: "))
+		os.Exit(1)
+	}
+	io.Copy(os.Stdout, r)
+}
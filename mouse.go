@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jsynacek/med/term"
+	"strings"
+)
+
+// Handle an SGR mouse report. A left click moves the point to the clicked
+// character, activating the window under it, and the wheel scrolls the view by
+// wheelLines.
+func (med *Med) mouse(seq string) {
+	var b, x, y int
+	if !strings.HasSuffix(seq, "M") {
+		return // Button release.
+	}
+	if _, err := fmt.Sscanf(seq[len(kMouse):len(seq)-1], "%d;%d;%d", &b, &x, &y); err != nil {
+		return
+	}
+	if med.mode == DialogMode || med.mode == ErrorMode {
+		return
+	}
+	row, col := y-1-term.Top(), x-1
+	if med.outline != nil && col < med.outline.cols {
+		if b == 0 {
+			med.outlineClick(row)
+		}
+		return
+	}
+	for i, e := range med.windows {
+		file := e.Value.(*File)
+		view := &file.view
+		if med.follow != nil && col >= med.follow.left {
+			view = med.follow
+		}
+		if col < view.left || col >= view.left+view.width || row < view.top || row >= view.top+view.height {
+			continue
+		}
+		if b != 0 {
+			// The wheel scrolls the buffer, even over the second column in follow mode.
+			view = &file.view
+		}
+		switch b {
+		case 0:
+			if i != med.window {
+				med.window, med.file = i, e
+				commandMode(med, file)
+			}
+			file.Goto(view.PositionAt(file.text.Bytes(), row-view.top, col-view.left))
+			if med.mode == SelectionMode {
+				med.selectionUpdate(file)
+			}
+		case 64:
+			view.Scroll(file.text.Bytes(), -wheelLines)
+			med.pointIntoView(file)
+		case 65:
+			view.Scroll(file.text.Bytes(), wheelLines)
+			med.pointIntoView(file)
+		case 66, 67:
+			// Horizontal wheel. Long lines are always wrapped, so there is
+			// nothing to scroll sideways.
+		}
+		return
+	}
+}
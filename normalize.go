@@ -0,0 +1,77 @@
+package main
+
+import (
+	"golang.org/x/text/unicode/norm"
+	"unicode/utf8"
+)
+
+// The same text can be written in Unicode with precomposed characters or
+// with combining marks, which look the same, but don't match in searches and
+// diffs. With normalizeUnicode, buffers are normalized to NFC when loaded and
+// before saved. Without it, loading a file that mixes both only shows
+// a warning.
+
+// Normalize the text to NFC. Only the part that changes is replaced, so the
+// point and the view stay where they are. Returns false if the text was
+// already normalized.
+func (file *File) normalize() bool {
+	if norm.NFC.IsNormal(file.text.Bytes()) || file.readOnly {
+		return false
+	}
+	text := norm.NFC.Bytes(file.text.Bytes())
+	start := 0
+	for start < len(text) && file.text.Bytes()[start] == text[start] {
+		start++
+	}
+	end, nend := file.text.Len(), len(text)
+	for end > start && nend > start && file.text.Bytes()[end-1] == text[nend-1] {
+		end--
+		nend--
+	}
+	// Replace whole characters.
+	for start > 0 && start < len(text) && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < file.text.Len() && !utf8.RuneStart(file.text.Bytes()[end]) {
+		end++
+		nend++
+	}
+	off := file.point.Off
+	file.Goto(start)
+	file.Delete(start, end)
+	if nend > start {
+		file.pushUndo(text[start:nend], start, true)
+		file.insert(text[start:nend])
+	}
+	if off >= end {
+		off += nend - end
+	} else if off > start {
+		off = start
+	}
+	file.Goto(min(off, file.text.Len()))
+	return true
+}
+
+// Whether the text has both precomposed characters and combining marks that
+// could be composed.
+func mixedNormalization(text []byte) bool {
+	return !norm.NFC.IsNormal(text) && !norm.NFD.IsNormal(text)
+}
+
+// Normalize a loaded file, or warn about mixed normalization.
+func (med *Med) checkNormalization(file *File) {
+	if normalizeUnicode {
+		if file.normalize() {
+			file.UndoBlock()
+			med.showMessage("%s normalized to NFC", file.name)
+		}
+	} else if mixedNormalization(file.text.Bytes()) {
+		med.showMessage("%s mixes Unicode normalization forms, see normalizeNFC", file.name)
+	}
+}
+
+func normalizeNFC(med *Med, file *File) {
+	if !file.normalize() {
+		med.showMessage("already normalized")
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/jsynacek/med/buffer"
+	"github.com/jsynacek/med/term"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+// The outline is a sidebar on the left listing the declarations of the active
+// buffer, top-level Go declarations or Markdown headings. The one the point is
+// in is highlighted. Clicking an entry, or picking it with outlineJump, moves
+// the point to it.
+
+type Decl struct {
+	name  string
+	off   int
+	depth int
+}
+
+type Outline struct {
+	// Columns taken by the sidebar, including the separator, set by layout.
+	cols int
+	// First displayed entry.
+	scroll int
+	// Declarations as they were last displayed, for mouse clicks.
+	decls []Decl
+}
+
+const outlineWidth = 24
+
+func goDecls(text []byte) (decls []Decl) {
+	fset := token.NewFileSet()
+	f, _ := parser.ParseFile(fset, "", text, parser.SkipObjectResolution)
+	if f == nil {
+		return nil
+	}
+	add := func(name string, pos token.Pos, depth int) {
+		decls = append(decls, Decl{name, fset.Position(pos).Offset, depth})
+	}
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				add(fmt.Sprintf("(%s) %s", goTypeName(d.Recv.List[0].Type), d.Name.Name), d.Pos(), 0)
+			} else {
+				add(d.Name.Name, d.Pos(), 0)
+			}
+		case *ast.GenDecl:
+			if d.Tok == token.IMPORT {
+				continue
+			}
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					add("type "+s.Name.Name, s.Pos(), 0)
+				case *ast.ValueSpec:
+					for _, n := range s.Names {
+						add(d.Tok.String()+" "+n.Name, n.Pos(), 0)
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+// Headings are indented by their level. Lines in fenced code blocks are not
+// headings.
+func markdownDecls(text []byte) (decls []Decl) {
+	fenced := false
+	for off := 0; off < len(text); off = buffer.LineEnd(text, off) + 1 {
+		line := text[off:buffer.LineEnd(text, off)]
+		if bytes.HasPrefix(line, []byte("```")) {
+			fenced = !fenced
+		}
+		if fenced || !bytes.HasPrefix(line, []byte("#")) {
+			continue
+		}
+		level := len(line) - len(bytes.TrimLeft(line, "#"))
+		if name := strings.TrimSpace(string(line[level:])); name != "" {
+			decls = append(decls, Decl{name, off, level - 1})
+		}
+	}
+	return
+}
+
+func fileDecls(file *File) ([]Decl, bool) {
+	switch fileType(file.path) {
+	case "go":
+		return goDecls(file.text.Bytes()), true
+	case "md", "markdown":
+		return markdownDecls(file.text.Bytes()), true
+	}
+	return nil, false
+}
+
+// Index of the declaration off is in, or -1.
+func declAt(decls []Decl, off int) int {
+	i := -1
+	for j, d := range decls {
+		if d.off > off {
+			break
+		}
+		i = j
+	}
+	return i
+}
+
+func toggleOutline(med *Med, file *File) {
+	if med.outline != nil {
+		med.outline = nil
+		return
+	}
+	if _, ok := fileDecls(file); !ok {
+		med.pushError(fmt.Errorf("no outline for %s", file.name))
+		return
+	}
+	med.outline = &Outline{}
+}
+
+// Split off the sidebar on the left and return the number of columns it takes.
+func (med *Med) outlineLayout(cols int) int {
+	med.outline.cols = min(outlineWidth, cols/2)
+	return med.outline.cols
+}
+
+func (med *Med) displayOutline(t *term.Term, rows int) {
+	o := med.outline
+	file := med.file.Value.(*File)
+	w := o.cols - 1
+	o.decls, _ = fileDecls(file)
+	cur := declAt(o.decls, file.point.Off)
+	// Keep the current entry visible.
+	h := rows - 1
+	if cur >= 0 && cur < o.scroll {
+		o.scroll = cur
+	} else if cur >= o.scroll+h {
+		o.scroll = cur - h + 1
+	}
+	o.scroll = max(0, min(o.scroll, len(o.decls)-h))
+	for i := 0; i < h && o.scroll+i < len(o.decls); i++ {
+		d := o.decls[o.scroll+i]
+		t.MoveTo(i, 0)
+		if o.scroll+i == cur {
+			theme["outlineCurrent"].Out(t)
+		}
+		t.Write([]byte(fitString(strings.Repeat(" ", d.depth)+d.name, w)))
+		t.AttrReset()
+	}
+	view := View{top: 0, height: h, left: 0, width: w - 1}
+	displayStatus(t, &view, "outline  "+file.name)
+}
+
+// Jump to the entry of the outline on screen row.
+func (med *Med) outlineClick(row int) {
+	o := med.outline
+	if i := o.scroll + row; i < len(o.decls) {
+		file := med.file.Value.(*File)
+		file.Goto(o.decls[i].off)
+		commandMode(med, file)
+	}
+}
+
+// Pick a declaration of the buffer in a helm and jump to it.
+func outlineJump(med *Med, file *File) {
+	decls, ok := fileDecls(file)
+	if !ok || len(decls) == 0 {
+		med.pushError(errors.New("no declarations"))
+		return
+	}
+	var shown []Decl
+	update := func() {}
+	finish := func(cancel bool) {
+		if cancel || len(shown) == 0 {
+			return
+		}
+		file.Goto(shown[max(0, med.dialog.helm.index)].off)
+		file.view.ToPoint(file.text.Bytes(), file.point.Off, file.view.height/2)
+	}
+	complete := func() {
+		shown = nil
+		var data []string
+		for _, d := range decls {
+			if strings.Contains(d.name, string(med.dialog.file.text.Bytes())) {
+				shown = append(shown, d)
+				data = append(data, strings.Repeat(" ", d.depth)+d.name)
+			}
+		}
+		med.dialog.helm.data = data
+	}
+	med.startDialog("declaration", update, finish, NewHelm(complete))
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Key scripts drive the editor without a terminal, which makes it possible to
+// test editing from the outside. Every field of a line is a key sequence as
+// it would be read from the terminal, written as a Go string if it contains
+// spaces or special characters:
+//
+//	# Insert a line at the top and save.
+//	f "hello" "\r" "\x1b "
+//	" s"
+//
+// Errors are printed to stderr as they happen.
+func (med *Med) playKeys(path string) error {
+	return readConfigLines(path, func(keys []string) error {
+		for _, k := range keys {
+			if med.quit {
+				return nil
+			}
+			med.syncWindows()
+			med.layout()
+			med.dispatch(KeyEvent(k))
+			for med.mode == ErrorMode {
+				fmt.Fprintln(os.Stderr, med.errors.Front().Value.(error))
+				med.popError()
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jsynacek/med/buffer"
+	"github.com/jsynacek/med/term"
+	"strings"
+)
+
+// Popup is a small bordered window drawn over the text, anchored at a screen
+// cell. It is shown below the anchor if it fits, otherwise above it.
+type Popup struct {
+	lines []string
+	// Screen cell the popup is anchored at.
+	row, col int
+	// Selected line, or -1 if there is none.
+	index int
+	// First displayed line and the maximum number of displayed lines.
+	scroll int
+	height int
+}
+
+func NewPopup(lines []string, row, col int) *Popup {
+	return &Popup{lines: lines, row: row, col: col, index: -1, height: 10}
+}
+
+// Create a popup anchored at the point of file.
+func NewPointPopup(file *File, lines []string) *Popup {
+	row, col, ok := file.DotPosition()
+	if !ok {
+		row, col = 0, file.view.left
+	}
+	return NewPopup(lines, row, col)
+}
+
+func stringWidth(s string) (w int) {
+	for _, r := range s {
+		w += buffer.RuneWidth(r)
+	}
+	return
+}
+
+// Cut s to at most w screen cells and pad it with spaces to exactly w.
+func fitString(s string, w int) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range s {
+		if n+buffer.RuneWidth(r) > w {
+			break
+		}
+		b.WriteRune(r)
+		n += buffer.RuneWidth(r)
+	}
+	return b.String() + strings.Repeat(" ", w-n)
+}
+
+// Move the selection by n lines, scrolling to keep it visible.
+func (p *Popup) Move(n int) {
+	if len(p.lines) == 0 {
+		return
+	}
+	p.index = min(len(p.lines)-1, max(0, p.index+n))
+	if p.index < p.scroll {
+		p.scroll = p.index
+	} else if p.index >= p.scroll+p.height {
+		p.scroll = p.index - p.height + 1
+	}
+}
+
+func (p *Popup) Scroll(n int) {
+	p.scroll = min(max(0, len(p.lines)-p.height), max(0, p.scroll+n))
+}
+
+func (p *Popup) Display(t *term.Term) {
+	cols, rows := term.Cols(), term.Rows()
+	h := min(p.height, len(p.lines))
+	if h == 0 {
+		return
+	}
+	w := 0
+	for _, l := range p.lines {
+		w = max(w, stringWidth(l))
+	}
+	// Leave room for the border.
+	w = min(w, cols-2)
+	top := p.row + 1
+	if top+h+2 > rows-2 && p.row-h-2 >= 0 {
+		top = p.row - h - 2
+	}
+	h = min(h, rows-2-top-2)
+	left := max(0, min(p.col, cols-w-2))
+
+	theme["popup"].Out(t)
+	t.MoveTo(top, left)
+	t.Write([]byte("┌" + strings.Repeat("─", w) + "┐"))
+	for i := 0; i < h; i++ {
+		t.MoveTo(top+1+i, left)
+		t.Write([]byte("│"))
+		if p.scroll+i == p.index {
+			theme["popupSelected"].Out(t)
+		}
+		t.Write([]byte(fitString(p.lines[p.scroll+i], w)))
+		theme["popup"].Out(t)
+		t.Write([]byte("│"))
+	}
+	// Show how many lines are left in the bottom border.
+	bottom := strings.Repeat("─", w)
+	if more := fmt.Sprintf("+%d", len(p.lines)-p.scroll-h); more != "+0" && w > len(more) {
+		bottom = strings.Repeat("─", w-len(more)) + more
+	}
+	t.MoveTo(top+1+h, left)
+	t.Write([]byte("└" + bottom + "┘"))
+	t.AttrReset()
+}
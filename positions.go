@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/jsynacek/med/buffer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Point and view positions of files are remembered in ~/.cache/med/positions,
+// one file per line, most recently used first:
+//
+//	<point offset> <view start> <absolute path>
+
+const maxPositions = 1000
+
+type position struct {
+	point, view int
+	path        string
+}
+
+func positionsPath() string {
+	return filepath.Join(cacheDir(), "positions")
+}
+
+func readPositions() (ps []position) {
+	f, err := os.Open(positionsPath())
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var p position
+		if n, _ := fmt.Sscanf(s.Text(), "%d %d", &p.point, &p.view); n != 2 {
+			continue
+		}
+		fields := strings.SplitN(s.Text(), " ", 3)
+		if len(fields) == 3 {
+			p.path = fields[2]
+			ps = append(ps, p)
+		}
+	}
+	return
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// Remember positions of files that have a path.
+func rememberPositions(files ...*File) error {
+	var ps []position
+	seen := make(map[string]bool)
+	for _, file := range files {
+		if file.path == "" || file.scratch {
+			continue
+		}
+		p := position{file.point.Off, file.view.start, absPath(file.path)}
+		if !seen[p.path] {
+			ps = append(ps, p)
+			seen[p.path] = true
+		}
+	}
+	if len(ps) == 0 {
+		return nil
+	}
+	for _, p := range readPositions() {
+		if !seen[p.path] && len(ps) < maxPositions {
+			ps = append(ps, p)
+			seen[p.path] = true
+		}
+	}
+	var b strings.Builder
+	for _, p := range ps {
+		fmt.Fprintf(&b, "%d %d %s\n", p.point, p.view, p.path)
+	}
+	if err := os.MkdirAll(cacheDir(), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(positionsPath(), []byte(b.String()), 0600)
+}
+
+// Move the point and the view to where they were when the file was last closed.
+func restorePosition(file *File) {
+	path := absPath(file.path)
+	for _, p := range readPositions() {
+		if p.path != path {
+			continue
+		}
+		// The file might have changed in the meantime.
+		if p.point <= file.text.Len() && p.view <= p.point {
+			file.Goto(p.point)
+			file.view.start, _ = buffer.VisualLineStart(file.text.Bytes(), p.view, file.view.visual.tabStop, file.view.width)
+		}
+		return
+	}
+}
+
+// Remember positions and folds of all open files.
+func (med *Med) savePositions() {
+	if !keepPositions {
+		return
+	}
+	var files []*File
+	for e := med.files.Front(); e != nil; e = e.Next() {
+		files = append(files, e.Value.(*File))
+	}
+	rememberPositions(files...)
+	rememberFolds(files...)
+}
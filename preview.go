@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jsynacek/med/sam"
+	"sort"
+	"strings"
+)
+
+// Sam commands can be previewed before they change the buffer, always with
+// samPreview or every time with the previewSam option. The commands are run
+// as usual, but the regions they changed are highlighted and counted and the
+// change is kept only if confirmed. Otherwise it is undone and dropped from the
+// undo tree. Commands running on other buffers with X or Y are not previewed.
+// Commands that read or write files or run shell commands can't be taken back,
+// so samPreview refuses them, while with previewSam they run without
+// a preview.
+
+// Return the regions changed by the records of an undo block, in the text
+// after the block, sorted and merged. Regions that were only deleted are
+// empty.
+func blockChanges(b *UndoNode) []Dot {
+	var changes []Dot
+	for _, u := range b.undos {
+		n := len(u.text)
+		for i := range changes {
+			d := &changes[i]
+			if u.isInsert {
+				if d.start >= u.off {
+					d.start += n
+					d.end += n
+				} else if d.end > u.off {
+					d.end += n
+				}
+			} else {
+				d.start = u.off + max(0, d.start-u.off-n) + min(0, d.start-u.off)
+				d.end = u.off + max(0, d.end-u.off-n) + min(0, d.end-u.off)
+			}
+		}
+		if u.isInsert {
+			changes = append(changes, Dot{u.off, u.off + n})
+		} else {
+			changes = append(changes, Dot{u.off, u.off})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].start < changes[j].start
+	})
+	var res []Dot
+	for _, d := range changes {
+		if k := len(res) - 1; k >= 0 && d.start <= res[k].end {
+			res[k].end = max(res[k].end, d.end)
+			continue
+		}
+		res = append(res, d)
+	}
+	return res
+}
+
+// Return the name of a command in cmds that reads or writes files or runs
+// a shell command, or "" if there is none.
+func externalCommand(cmds []*sam.Command) string {
+	for _, cmd := range cmds {
+		for c := cmd; c != nil; c = c.Next {
+			switch c.Name {
+			case "w", "r", "e", "|", "<", ">", "!":
+				return c.Name
+			case "{":
+				if name := externalCommand(c.Group); name != "" {
+					return name
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// Preview sam commands if they can be, otherwise just run them.
+func (med *Med) samExecuteOrPreview(file *File, addr *sam.Address, cmdList []*sam.Command) error {
+	if externalCommand(cmdList) != "" {
+		return med.samExecute(file, addr, cmdList)
+	}
+	return med.samExecutePreview(file, addr, cmdList)
+}
+
+// Run sam commands on file and ask whether to keep what they changed.
+func (med *Med) samExecutePreview(file *File, addr *sam.Address, cmdList []*sam.Command) error {
+	if name := externalCommand(cmdList); name != "" {
+		return fmt.Errorf("%s can't be previewed", name)
+	}
+	if file.undos == nil {
+		return med.samExecute(file, addr, cmdList)
+	}
+	for _, cmd := range cmdList {
+		if cmd.Name == "X" || cmd.Name == "Y" {
+			return med.samExecute(file, addr, cmdList)
+		}
+	}
+	point := file.point.Off
+	before := file.undos.current
+	if err := med.samExecute(file, addr, cmdList); err != nil || file.undos.current == before {
+		return err
+	}
+	changes := blockChanges(file.undos.current)
+	for _, d := range changes {
+		// Deleted regions show as a single character.
+		med.matches = append(med.matches, Highlight{d.start, max(d.end, d.start+1), theme["match"]})
+	}
+	mode := med.mode
+	update := func() {
+		if a := string(med.dialog.file.text.Bytes()); len(a) == 1 && strings.Contains("yn", a) {
+			med.dialog.finish(false)
+		} else {
+			med.dialog.file.Clear()
+		}
+	}
+	finish := func(cancel bool) {
+		med.matches = nil
+		if !cancel && string(med.dialog.file.text.Bytes()) == "y" {
+			med.mode = mode
+			return
+		}
+		file.UndoDiscard()
+		file.Goto(min(point, file.text.Len()))
+		commandMode(med, file)
+	}
+	s := "s"
+	if len(changes) == 1 {
+		s = ""
+	}
+	med.startDialog(fmt.Sprintf("apply %d change%s? [y]es [n]o", len(changes), s), update, finish, Helm{})
+	return nil
+}
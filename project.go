@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// A project is the directory tree under the nearest directory containing .git
+// or go.mod. A project can override some settings for its files in
+// .med/config in its root, using the same syntax as the config file:
+//
+//	tabStop 4
+//	buildCommand "make"
+//	formatCommand "goimports"
+
+// The settings that run commands are only taken from the project config if
+// the project root is trusted in the config file, so opening a file of
+// a cloned repository can't run whatever its config says:
+//
+//	trust ~/src/project
+
+// Settings a project config can override.
+var projectSettings = []string{"tabStop", "buildCommand", "formatCommand", "interpreter"}
+
+// Return the root of the project containing path, or "" if there is none.
+func projectRoot(path string) string {
+	if path == "" {
+		return ""
+	}
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return ""
+	}
+	for {
+		for _, name := range []string{".git", "go.mod"} {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				return dir
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// Settings that are run as shell commands.
+var commandSettings = []string{"buildCommand", "formatCommand", "interpreter"}
+
+// Whether the config of the project in root may set commands.
+func (c *Config) trusts(root string) bool {
+	for _, dir := range c.trusted {
+		if dir == root {
+			return true
+		}
+	}
+	return false
+}
+
+// Read the project config in root.
+func projectConfig(root string) (vars map[string]string, err error) {
+	vars = make(map[string]string)
+	err = readConfigLines(filepath.Join(root, ".med", "config"), func(f []string) error {
+		if len(f) != 2 {
+			return fmt.Errorf("expected setting and value")
+		}
+		for _, s := range projectSettings {
+			if s == f[0] {
+				vars[f[0]] = f[1]
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown project setting: %s", f[0])
+	})
+	return
+}
+
+// Option returns the value of a buffer-local variable, or of the global
+// option with the same name if the variable is not set.
+func (file *File) Option(name string) string {
+	if v, ok := file.vars[name]; ok {
+		return v
+	}
+	switch o := options[name].(type) {
+	case *int:
+		return fmt.Sprint(*o)
+	case *bool:
+		return fmt.Sprint(*o)
+	case *string:
+		return *o
+	}
+	return ""
+}
+
+// Start loading a file in the project root.
+func loadProjectFile(med *Med, file *File) {
+	if file.project == "" {
+		med.pushError(errors.New("not in a project"))
+		return
+	}
+	med.load(file.project + "/")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Typographic replacements are done as the text is typed: straight quotes
+// become curly ones and two or three hyphens become a dash. Each replacement
+// is an undo block of its own, so undo brings back what was typed. They are
+// on for prose file types if smartQuotes is set, and can be toggled for
+// a buffer, which sets its smartQuotes variable.
+
+var proseTypes = []string{"md", "markdown", "txt", "text"}
+
+func isProse(path string) bool {
+	for _, t := range proseTypes {
+		if fileType(path) == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (file *File) smartQuotes() bool {
+	if v, ok := file.Var("smartQuotes"); ok {
+		return v == "true"
+	}
+	return smartQuotes && isProse(file.path)
+}
+
+func toggleSmartQuotes(med *Med, file *File) {
+	if file.vars == nil {
+		file.vars = make(map[string]string)
+	}
+	if file.smartQuotes() {
+		file.vars["smartQuotes"] = "false"
+		med.showMessage("smart quotes off")
+	} else {
+		file.vars["smartQuotes"] = "true"
+		med.showMessage("smart quotes on")
+	}
+}
+
+// Return the replacement of the text typed just before off, and where the
+// replaced text starts.
+func typographic(text []byte, off int) (start int, repl string, ok bool) {
+	r, s := utf8.DecodeLastRune(text[:off])
+	start = off - s
+	prev, ps := utf8.DecodeLastRune(text[:start])
+	// Quotes open after a space, an opening bracket or another opening quote.
+	opening := start == 0 || unicode.IsSpace(prev) || prev == '(' || prev == '[' || prev == '{' || prev == '“' || prev == '‘'
+	switch {
+	case r == '"' && opening:
+		return start, "“", true
+	case r == '"':
+		return start, "”", true
+	case r == '\'' && opening:
+		return start, "‘", true
+	case r == '\'':
+		return start, "’", true
+	case r == '-' && prev == '-':
+		return start - ps, "–", true
+	case r == '-' && prev == '–':
+		return start - ps, "—", true
+	}
+	return off, "", false
+}
+
+// Insert typed text, expanding abbreviations and doing the typographic
+// replacements if they are on.
+func (med *Med) insertTyped(file *File, b []byte) {
+	file.InsertKey(b)
+	if len(b) != 1 {
+		return
+	}
+	med.expandAbbrev(file)
+	file.autoFill()
+	if !file.smartQuotes() {
+		return
+	}
+	off := file.point.Off
+	if start, repl, ok := typographic(file.text.Slice(0, off), off); ok {
+		file.BeginUndoGroup()
+		file.Delete(start, off)
+		file.Insert([]byte(repl))
+		file.EndUndoGroup()
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// Return the identifier at off.
+func identAt(text []byte, off int) (start, end int, ok bool) {
+	start, end = off, off
+	for start > 0 {
+		r, s := utf8.DecodeLastRune(text[:start])
+		if !isIdentRune(r) {
+			break
+		}
+		start -= s
+	}
+	for end < len(text) {
+		r, s := utf8.DecodeRune(text[end:])
+		if !isIdentRune(r) {
+			break
+		}
+		end += s
+	}
+	return start, end, start < end
+}
+
+// Return offsets of all occurrences of word in text that are not part of
+// a longer identifier.
+func wholeWordMatches(text, word []byte) (offs []int) {
+	for off := 0; ; off += len(word) {
+		i := bytes.Index(text[off:], word)
+		if i < 0 {
+			return
+		}
+		off += i
+		r1, _ := utf8.DecodeLastRune(text[:off])
+		r2, _ := utf8.DecodeRune(text[off+len(word):])
+		if (off == 0 || !isIdentRune(r1)) && (off+len(word) == len(text) || !isIdentRune(r2)) {
+			offs = append(offs, off)
+		}
+	}
+}
+
+// Rename the identifier under the point in the whole buffer. All occurrences
+// are highlighted until the new name is confirmed.
+func renameWord(med *Med, file *File) {
+	s, e, ok := identAt(file.text.Bytes(), file.point.Off)
+	if !ok {
+		med.pushError(errors.New("no identifier under the point"))
+		return
+	}
+	word := append([]byte(nil), file.text.Slice(s, e)...)
+	offs := wholeWordMatches(file.text.Bytes(), word)
+	for _, off := range offs {
+		med.matches = append(med.matches, Highlight{off, off + len(word), theme["match"]})
+	}
+	update := func() {}
+	finish := func(cancel bool) {
+		med.matches = nil
+		name := med.dialog.file.text.Bytes()
+		if cancel || len(name) == 0 || bytes.Equal(name, word) {
+			return
+		}
+		point := file.point.Off
+		for i := len(offs) - 1; i >= 0; i-- {
+			off := offs[i]
+			file.Delete(off, off+len(word))
+			file.Goto(off)
+			file.Insert(append([]byte(nil), name...))
+			if point > off {
+				point += len(name) - len(word)
+			}
+		}
+		file.Goto(max(0, point))
+	}
+	med.startDialog(fmt.Sprintf("rename %s (%d)", word, len(offs)), update, finish, Helm{})
+	med.dialog.file.Insert(append([]byte(nil), word...))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/jsynacek/med/buffer"
+	"os/exec"
+	"strings"
+)
+
+// Return the interpreter on the shebang line of text.
+func shebang(text []byte) ([]string, bool) {
+	if !bytes.HasPrefix(text, []byte("#!")) {
+		return nil, false
+	}
+	f := strings.Fields(string(text[2:buffer.LineEnd(text, 0)]))
+	return f, len(f) > 0
+}
+
+// Run the buffer and stream its output into the "run" buffer. Go files are
+// run with go run and files with a shebang line with its interpreter, after
+// saving them. Other buffers and the selection are piped to the interpreter
+// option.
+func runBuffer(med *Med, file *File) {
+	if med.jobRunning("run") {
+		med.pushError(errors.New("already running"))
+		return
+	}
+	var cmd *exec.Cmd
+	var input []byte
+	interp, ok := shebang(file.text.Bytes())
+	onDisk := file.path != "" && !file.scratch
+	switch {
+	case med.selection.active:
+		ss, se := med.selectionRange(file)
+		input = append(input, file.text.Slice(ss, se)...)
+	case onDisk && fileType(file.path) == "go":
+		cmd = exec.Command("go", "run", file.path)
+	case onDisk && ok:
+		cmd = exec.Command(interp[0], append(interp[1:], file.path)...)
+	default:
+		input = append(input, file.text.Bytes()...)
+	}
+	if cmd == nil {
+		cmd = exec.Command("sh", "-c", file.Option("interpreter"))
+		cmd.Stdin = bytes.NewReader(input)
+	} else if err := med.saveBuffer(file); err != nil {
+		med.pushError(err)
+		return
+	}
+	cmd.Dir = file.dir()
+	e := med.startStreamJob("run", cmd, func(err error) {
+		if err != nil {
+			med.showMessage("run: %v", err)
+		} else {
+			med.showMessage("run: done")
+		}
+	})
+	med.showBuffer(e)
+}
@@ -6,11 +6,30 @@
 // Only a subset of the command language was implemented:
 //
 // Addresses can be specified by line numbers, character position (#number),
-// regular expression to match (/regexp/) and anchors (0, $, .).
+// regular expression to match (/regexp/) and anchors (0, $, .). Any of them
+// can be followed by addresses relative to it, +number or -number for lines
+// (1 if the number is left out), +#number, -#number, +/regexp/ and -/regexp/,
+// as in .+3, $-5 or /foo/+1. A relative address alone is relative to dot.
+// Two addresses joined by a comma select everything from the start of the
+// first to the end of the second. Joined by a semicolon, the second one is
+// evaluated with dot set to the first one, so /start/;/end/ finds the end
+// after the start.
 //
 // Implemented commands:
-// Editing - d,a,i,c.
+// Editing - d,a,i,c,s.
 // Control - x,g,v.
+// Buffers - X,Y, running a command on the whole text of every open buffer
+// whose name matches, or doesn't match, a regexp, as in X/\.go$/x/foo/c/bar/.
+// Grouping - { }, running all commands of the group on the same dot, as in
+// x/foo/{ i/A/ a/B/ }.
+// Files - w,r,e. Unlike the other commands, they take a file name up to the
+// end of the line, as in "w out.txt".
+// Shell - |,<,>,!. They take a shell command up to the end of the line. | pipes
+// dot through the command and replaces it with the output, < replaces dot with
+// the output, > sends dot to the command and ! just runs it, as in "|sort".
+//
+// The s command replaces only the first match in dot, x/re/ s/re/text/
+// replaces all of them.
 
 package sam
 
@@ -62,7 +81,7 @@ func (s *Scanner) scanAddress() string {
 			s.next()
 			return string(r)
 		}
-		if s.ch == '#' {
+		if s.ch == '#' || s.ch == '+' || s.ch == '-' {
 			s.next()
 		}
 		for s.ch >= 0 && unicode.IsDigit(s.ch) {
@@ -97,6 +116,35 @@ done:
 	return string(s.src[start:s.offset]), nil
 }
 
+// Scan the file name of a file command or the shell command of a shell
+// command, which is the rest of the line without leading and trailing blanks.
+func (s *Scanner) scanLine() string {
+	for s.ch == ' ' || s.ch == '\t' {
+		s.next()
+	}
+	start := s.offset
+	for s.ch >= 0 && s.ch != '\n' {
+		s.next()
+	}
+	return strings.TrimRight(string(s.src[start:s.offset]), " \t")
+}
+
+// Scan the replacement of the s command, which follows its regexp up to the
+// next unescaped '/'. Unlike scanText, the slashes are not part of the result.
+func (s *Scanner) scanReplacement() string {
+	start := s.offset
+	esc := false
+	for s.ch >= 0 && (s.ch != '/' || esc) {
+		esc = s.ch == '\\' && !esc
+		s.next()
+	}
+	end := s.offset
+	if s.ch == '/' {
+		s.next()
+	}
+	return string(s.src[start:end])
+}
+
 type Token int
 
 const (
@@ -104,6 +152,8 @@ const (
 	COMMA
 	COMMAND
 	TEXT
+	LBRACE
+	RBRACE
 	EOF
 	UNKNOWN
 )
@@ -112,20 +162,28 @@ func (s *Scanner) Scan() (pos int, tok Token, lit string) {
 	s.skipWhitespace()
 	pos = s.offset
 	switch s.ch {
-	case '#', '.', '$', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+	case '#', '.', '$', '+', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
 		tok = ADDRESS
 		lit = s.scanAddress()
-	case ',':
+	case ',', ';':
 		tok = COMMA
 		lit = string(s.ch)
 		s.next()
-	case 'a', 'i', 'c', 'd', 'x', 'g', 'v':
+	case 'a', 'i', 'c', 'd', 's', 'x', 'g', 'v', 'X', 'Y', 'w', 'r', 'e', '|', '<', '>', '!':
 		tok = COMMAND
 		lit = string(s.ch)
 		s.next()
 	case '/':
 		tok = TEXT
 		lit, _ = s.scanText()
+	case '{':
+		tok = LBRACE
+		lit = string(s.ch)
+		s.next()
+	case '}':
+		tok = RBRACE
+		lit = string(s.ch)
+		s.next()
 	case -1:
 		tok = EOF
 		lit = ""
@@ -138,20 +196,34 @@ func (s *Scanner) Scan() (pos int, tok Token, lit string) {
 }
 
 type Address struct {
-	Type rune     // '0', '$', '#', 'l', '/'.
+	Type rune     // '0', '.', '$', '#', 'l', '/'.
 	Arg  string   // Char position, line number or /text/.
-	End  *Address // Part right of comma.
+	Sign rune     // '+' or '-' for relative addresses.
+	Next *Address // Relative address following this one.
+	End  *Address // Part right of comma or semicolon.
+	Semi bool     // End is evaluated with dot set to this address.
 }
 
 type Command struct {
-	Name string   // "d", "a", "i", "c", "x", "g".
-	Arg  string   // Text/regexp argument for all but "d".
-	Next *Command // Next command in chain, in case of "x" or "g".
+	Name string   // "d", "a", "i", "c", "s", "x", "g", "X", "Y", "w", "r", "e", "|", "<", ">", "!".
+	Arg  string   // Text/regexp argument, file name or shell command for all but "d".
+	Repl string   // Replacement, in case of "s".
+	Next *Command // Next command in chain, in case of "x", "g", "X" or "Y".
+	// Commands of a group, in case of "{".
+	Group []*Command
 }
 
 func (a Address) String() string {
 	s := fmt.Sprintf("addr: type:%s arg:[%v]", string(a.Type), a.Arg)
-	if a.End != nil {
+	if a.Sign != 0 {
+		s = string(a.Sign) + s
+	}
+	if a.Next != nil {
+		s += " " + a.Next.String()
+	}
+	if a.End != nil && a.Semi {
+		return s + " ;-> " + a.End.String()
+	} else if a.End != nil {
 		return s + " -> " + a.End.String()
 	}
 	return s
@@ -159,6 +231,12 @@ func (a Address) String() string {
 
 func (cmd Command) String() string {
 	s := fmt.Sprintf("cmd: name:%s arg:[%s]", cmd.Name, cmd.Arg)
+	if cmd.Name == "s" {
+		s += fmt.Sprintf(" repl:[%s]", cmd.Repl)
+	}
+	if cmd.Name == "{" {
+		s += fmt.Sprintf(" group:%v", cmd.Group)
+	}
 	if cmd.Next != nil {
 		return s + " -> " + cmd.Next.String()
 	}
@@ -196,6 +274,9 @@ func (p *Parser) parseAddressSide() (addr *Address, err error) {
 			addr.Type = '.'
 		case '$':
 			addr.Type = '$'
+		case '+', '-':
+			addr.Type = '.'
+			return p.parseRelative(addr)
 		default:
 			addr.Type = 'l'
 			addr.Arg = p.lit
@@ -205,6 +286,32 @@ func (p *Parser) parseAddressSide() (addr *Address, err error) {
 		addr.Arg = strings.Trim(p.lit, "/")
 	}
 	p.next()
+	return p.parseRelative(addr)
+}
+
+// Parse the relative addresses following addr.
+func (p *Parser) parseRelative(addr *Address) (*Address, error) {
+	last := addr
+	for p.tok == ADDRESS && (p.lit[0] == '+' || p.lit[0] == '-') {
+		rel := &Address{Type: 'l', Arg: p.lit[1:], Sign: rune(p.lit[0])}
+		p.next()
+		if rel.Arg == "" {
+			switch {
+			case p.tok == TEXT:
+				rel.Type = '/'
+				rel.Arg = strings.Trim(p.lit, "/")
+				p.next()
+			case p.tok == ADDRESS && p.lit[0] == '#':
+				rel.Type = '#'
+				rel.Arg = p.lit[1:]
+				p.next()
+			default:
+				rel.Arg = "1"
+			}
+		}
+		last.Next = rel
+		last = rel
+	}
 	return addr, nil
 }
 
@@ -218,6 +325,7 @@ func (p *Parser) parseAddress() (addr *Address, err error) {
 		}
 	}
 	if p.tok == COMMA {
+		addr.Semi = p.lit == ";"
 		// Special case of address ending with a comma. Look-ahead is needed.
 		s := p.scanner
 		_, tok, _ := s.Scan()
@@ -239,15 +347,34 @@ func (p *Parser) parseAddress() (addr *Address, err error) {
 
 func (p *Parser) parseCommand() (cmd *Command, err error) {
 	cmd = new(Command)
-	if p.lit == "d" {
+	if p.tok == LBRACE {
+		cmd.Name = "{"
+		p.next()
+		cmd.Group, err = p.parseCommandList()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != RBRACE {
+			return nil, fmt.Errorf("expecting }: %q", p.lit)
+		}
+	} else if p.lit == "d" {
 		cmd.Name = "d"
 		cmd.Arg = ""
+	} else if strings.Contains("wre|<>!", p.lit) {
+		cmd.Name = p.lit
+		cmd.Arg = p.scanner.scanLine()
+		if cmd.Arg == "" && strings.Contains("|<>!", p.lit) {
+			return nil, fmt.Errorf("missing shell command: %q", p.lit)
+		}
 	} else {
 		n := p.lit
 		p.next()
 		if p.tok == TEXT {
 			cmd.Name = n
 			cmd.Arg = strings.Trim(p.lit, "/")
+			if n == "s" {
+				cmd.Repl = p.scanner.scanReplacement()
+			}
 		} else {
 			return nil, fmt.Errorf("invalid command argument: %q", n)
 		}
@@ -258,7 +385,7 @@ func (p *Parser) parseCommand() (cmd *Command, err error) {
 func (p *Parser) parseCommandList() (list []*Command, err error) {
 	var cmd, head *Command
 	var next **Command
-	for p.tok == COMMAND {
+	for p.tok == COMMAND || p.tok == LBRACE {
 		cmd, err = p.parseCommand()
 		if err != nil {
 			return
@@ -270,7 +397,7 @@ func (p *Parser) parseCommandList() (list []*Command, err error) {
 			*next = cmd
 			next = &cmd.Next
 		}
-		if cmd.Name != "x" && cmd.Name != "g" && cmd.Name != "v" {
+		if !strings.Contains("xgvXY", cmd.Name) {
 			next = nil
 			list = append(list, head)
 			head = nil
@@ -296,7 +423,7 @@ func (p *Parser) Parse() (addr *Address, cmdList []*Command, err error) {
 			return
 		}
 	}
-	if p.tok == COMMAND {
+	if p.tok == COMMAND || p.tok == LBRACE {
 		cmdList, err = p.parseCommandList()
 		if err != nil {
 			return
@@ -306,3 +433,34 @@ func (p *Parser) Parse() (addr *Address, cmdList []*Command, err error) {
 	}
 	return
 }
+
+// Expand the replacement of the s command for a match in src, as returned by
+// regexp.FindSubmatchIndex. & stands for the whole match and \1 to \9 for
+// the submatches, \n is a newline and a backslash escapes anything else.
+func Expand(repl string, src []byte, match []int) []byte {
+	var res []byte
+	group := func(i int) {
+		if 2*i+1 < len(match) && match[2*i] >= 0 {
+			res = append(res, src[match[2*i]:match[2*i+1]]...)
+		}
+	}
+	for i := 0; i < len(repl); i++ {
+		switch c := repl[i]; {
+		case c == '&':
+			group(0)
+		case c == '\\' && i+1 < len(repl):
+			i++
+			switch c := repl[i]; {
+			case c >= '1' && c <= '9':
+				group(int(c - '0'))
+			case c == 'n':
+				res = append(res, '\n')
+			default:
+				res = append(res, c)
+			}
+		default:
+			res = append(res, c)
+		}
+	}
+	return res
+}
@@ -1,9 +1,18 @@
 package sam
 
-import "testing"
+import (
+	"regexp"
+	"testing"
+)
 
 func addrEq(a1 *Address, a2 *Address) bool {
-	eq := a1.Type == a2.Type && a1.Arg == a2.Arg
+	eq := a1.Type == a2.Type && a1.Arg == a2.Arg && a1.Sign == a2.Sign && a1.Semi == a2.Semi
+	if (a1.Next == nil) != (a2.Next == nil) {
+		return false
+	}
+	if a1.Next != nil {
+		eq = eq && addrEq(a1.Next, a2.Next)
+	}
 	if a1.End != nil && a2.End != nil {
 		return eq && addrEq(a1.End, a2.End)
 	}
@@ -35,6 +44,23 @@ func testParseAddress(t *testing.T) {
 		{"10", Address{Type: 'l', Arg: "10", End: nil}},
 		{"#", Address{Type: '#', Arg: "", End: nil}},
 		{"#11", Address{Type: '#', Arg: "11", End: nil}},
+		// Relative addresses.
+		{".+3", Address{Type: '.', Next: &Address{Type: 'l', Arg: "3", Sign: '+'}}},
+		{"$-5", Address{Type: '$', Next: &Address{Type: 'l', Arg: "5", Sign: '-'}}},
+		{"/foo/+1", Address{Type: '/', Arg: "foo", Next: &Address{Type: 'l', Arg: "1", Sign: '+'}}},
+		{"+", Address{Type: '.', Next: &Address{Type: 'l', Arg: "1", Sign: '+'}}},
+		{"-#2", Address{Type: '.', Next: &Address{Type: '#', Arg: "2", Sign: '-'}}},
+		{"10-/a/+2", Address{Type: 'l', Arg: "10",
+			Next: &Address{Type: '/', Arg: "a", Sign: '-',
+				Next: &Address{Type: 'l', Arg: "2", Sign: '+'}}}},
+		{"/a/+,$-", Address{Type: '/', Arg: "a", Next: &Address{Type: 'l', Arg: "1", Sign: '+'},
+			End: &Address{Type: '$', Next: &Address{Type: 'l', Arg: "1", Sign: '-'}}}},
+		// Compound addresses.
+		{"/a/;/b/", Address{Type: '/', Arg: "a", Semi: true, End: &Address{Type: '/', Arg: "b"}}},
+		{"3;", Address{Type: 'l', Arg: "3", Semi: true, End: &Address{Type: '$'}}},
+		{";+2", Address{Type: '0', Semi: true, End: &Address{Type: '.', Next: &Address{Type: 'l', Arg: "2", Sign: '+'}}}},
+		{"/start/;/end/-1", Address{Type: '/', Arg: "start", Semi: true,
+			End: &Address{Type: '/', Arg: "end", Next: &Address{Type: 'l', Arg: "1", Sign: '-'}}}},
 	}
 	var p Parser
 	for _, test := range tests {
@@ -44,15 +70,16 @@ func testParseAddress(t *testing.T) {
 			t.Errorf("got:%q, want:%q", addr, test.res)
 		}
 	}
-	p.Init([]byte(",,"))
-	_, _, err := p.Parse()
-	if err == nil {
-		t.Errorf(`expected parser error when parsing ",,"`)
+	for _, src := range []string{",,", ";;", ",;"} {
+		p.Init([]byte(src))
+		if _, _, err := p.Parse(); err == nil {
+			t.Errorf("expected parser error when parsing %q", src)
+		}
 	}
 }
 
 func cmdEq(c1 *Command, c2 *Command) bool {
-	eq := c1.Name == c2.Name && c1.Arg == c2.Arg
+	eq := c1.Name == c2.Name && c1.Arg == c2.Arg && c1.Repl == c2.Repl && cmdListEq(c1.Group, c2.Group)
 	if c1.Next == nil && c2.Next != nil || c1.Next != nil && c2.Next == nil {
 		return false
 	}
@@ -103,9 +130,66 @@ func testParseCommand(t *testing.T) {
 		{"v/vvv/", []*Command{
 			&Command{Name: "v", Arg: "vvv"},
 		}},
+		{"s/sss/rrr/", []*Command{
+			&Command{Name: "s", Arg: "sss", Repl: "rrr"},
+		}},
+		{"s/s\\/s/r\\/r/", []*Command{
+			&Command{Name: "s", Arg: "s\\/s", Repl: "r\\/r"},
+		}},
+		{"s/sss//d", []*Command{
+			&Command{Name: "s", Arg: "sss", Repl: ""},
+			&Command{Name: "d"},
+		}},
+		{"x/xxx/s/(a)/\\1&/", []*Command{
+			&Command{Name: "x", Arg: "xxx", Next: &Command{Name: "s", Arg: "(a)", Repl: "\\1&"}},
+		}},
 		{"x/xxx/a/foo", []*Command{
 			&Command{Name: "x", Arg: "xxx", Next: &Command{Name: "a", Arg: "foo"}},
 		}},
+		{"w out.txt", []*Command{
+			&Command{Name: "w", Arg: "out.txt"},
+		}},
+		{"r  /tmp/a b.txt \t", []*Command{
+			&Command{Name: "r", Arg: "/tmp/a b.txt"},
+		}},
+		{"e", []*Command{
+			&Command{Name: "e"},
+		}},
+		{"x/xxx/w x.txt\nd", []*Command{
+			&Command{Name: "x", Arg: "xxx", Next: &Command{Name: "w", Arg: "x.txt"}},
+			&Command{Name: "d"},
+		}},
+		{"X/\\.go$/x/foo/c/bar/", []*Command{
+			&Command{Name: "X", Arg: "\\.go$", Next: &Command{Name: "x", Arg: "foo", Next: &Command{Name: "c", Arg: "bar"}}},
+		}},
+		{"Y/test/w", []*Command{
+			&Command{Name: "Y", Arg: "test", Next: &Command{Name: "w"}},
+		}},
+		{",|sort -u", []*Command{
+			&Command{Name: "|", Arg: "sort -u"},
+		}},
+		{"x/xxx/<date +%s\n!make", []*Command{
+			&Command{Name: "x", Arg: "xxx", Next: &Command{Name: "<", Arg: "date +%s"}},
+			&Command{Name: "!", Arg: "make"},
+		}},
+		{"> wc -l", []*Command{
+			&Command{Name: ">", Arg: "wc -l"},
+		}},
+		{"x/xxx/{ i/A/ a/B/ }", []*Command{
+			&Command{Name: "x", Arg: "xxx", Next: &Command{Name: "{", Group: []*Command{
+				&Command{Name: "i", Arg: "A"},
+				&Command{Name: "a", Arg: "B"},
+			}}},
+		}},
+		{"{d x/y/{c/z/}}i/foo/", []*Command{
+			&Command{Name: "{", Group: []*Command{
+				&Command{Name: "d"},
+				&Command{Name: "x", Arg: "y", Next: &Command{Name: "{", Group: []*Command{
+					&Command{Name: "c", Arg: "z"},
+				}}},
+			}},
+			&Command{Name: "i", Arg: "foo"},
+		}},
 		{"i/foo/x/xxx/a/bar", []*Command{
 			&Command{Name: "i", Arg: "foo"},
 			&Command{Name: "x", Arg: "xxx", Next: &Command{Name: "a", Arg: "bar"}},
@@ -143,6 +227,12 @@ func testParseCommand(t *testing.T) {
 			t.Errorf("got:%q, want:%q", cmdList, test.res)
 		}
 	}
+	for _, src := range []string{"{d", "x/a/{", "}", "|", "x/a/! "} {
+		p.Init([]byte(src))
+		if _, _, err := p.Parse(); err == nil {
+			t.Errorf("expected parser error when parsing %q", src)
+		}
+	}
 }
 
 func testParseCompound(t *testing.T) {
@@ -156,6 +246,11 @@ func testParseCompound(t *testing.T) {
 			[]*Command{
 				&Command{Name: "x", Arg: "xxx", Next: &Command{Name: "a", Arg: "foo"}},
 			}},
+		{"/a/+2d",
+			&Address{Type: '/', Arg: "a", Next: &Address{Type: 'l', Arg: "2", Sign: '+'}},
+			[]*Command{
+				&Command{Name: "d"},
+			}},
 	}
 	var p Parser
 	for _, test := range tests {
@@ -177,3 +272,23 @@ func TestParser(t *testing.T) {
 	testParseCompound(t)
 
 }
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		re, repl, src, res string
+	}{
+		{"b+", "x", "abbc", "x"},
+		{"b+", "[&]", "abbc", "[bb]"},
+		{`(\w+)=(\w+)`, `\2=\1`, "a=b", "b=a"},
+		{`(\w+)(x)?`, `\1\2\3`, "ab", "ab"},
+		{"b", `\&\\\n`, "b", "&\\\n"},
+		{"b", "", "abc", ""},
+	}
+	for _, test := range tests {
+		src := []byte(test.src)
+		m := regexp.MustCompile(test.re).FindSubmatchIndex(src)
+		if res := string(Expand(test.repl, src, m)); res != test.res {
+			t.Errorf("Expand(%q) of %q in %q: got:%q, want:%q", test.repl, test.re, test.src, res, test.res)
+		}
+	}
+}
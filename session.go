@@ -0,0 +1,114 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A session file records open files and the window arrangement:
+//
+//	buffer "/path/to/file"
+//	window "/path/to/file" 10 active
+//	layout stacked
+//
+// Buffers without a file are not recorded.
+
+func sessionPath() string {
+	return filepath.Join(cacheDir(), "session")
+}
+
+func saveSession(med *Med, file *File) {
+	var b strings.Builder
+	for e := med.files.Front(); e != nil; e = e.Next() {
+		if f := e.Value.(*File); f.path != "" && !f.scratch {
+			fmt.Fprintf(&b, "buffer %s\n", strconv.Quote(absPath(f.path)))
+		}
+	}
+	med.layout()
+	for i, e := range med.windows {
+		f := e.Value.(*File)
+		if f.path == "" || f.scratch {
+			continue
+		}
+		fmt.Fprintf(&b, "window %s %d", strconv.Quote(absPath(f.path)), med.sizes[i])
+		if i == med.window {
+			b.WriteString(" active")
+		}
+		b.WriteString("\n")
+	}
+	if med.stacked {
+		b.WriteString("layout stacked\n")
+	} else {
+		b.WriteString("layout columns\n")
+	}
+	err := os.MkdirAll(cacheDir(), 0700)
+	if err == nil {
+		err = ioutil.WriteFile(sessionPath(), []byte(b.String()), 0600)
+	}
+	if err != nil {
+		med.pushError(err)
+	}
+}
+
+// Return the buffer of the file at path, loading it if it's not open yet.
+func (med *Med) findOrLoad(path string) (*list.Element, error) {
+	for e := med.files.Front(); e != nil; e = e.Next() {
+		if f := e.Value.(*File); f.path != "" && absPath(f.path) == path {
+			return e, nil
+		}
+	}
+	file, err := LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return med.addFile(file), nil
+}
+
+func restoreSession(med *Med, file *File) {
+	var windows []*list.Element
+	var sizes []int
+	active := 0
+	stacked := false
+	err := readConfigLines(sessionPath(), func(f []string) error {
+		switch {
+		case f[0] == "buffer" && len(f) == 2:
+			_, err := med.findOrLoad(f[1])
+			return err
+		case f[0] == "window" && len(f) >= 3:
+			e, err := med.findOrLoad(f[1])
+			if err != nil {
+				return err
+			}
+			size, err := strconv.Atoi(f[2])
+			if err != nil {
+				return err
+			}
+			if len(f) > 3 && f[3] == "active" {
+				active = len(windows)
+			}
+			windows = append(windows, e)
+			sizes = append(sizes, size)
+		case f[0] == "layout" && len(f) == 2:
+			stacked = f[1] == "stacked"
+		default:
+			return errors.New("invalid session line")
+		}
+		return nil
+	})
+	if err != nil {
+		med.pushError(err)
+	}
+	if len(windows) == 0 {
+		return
+	}
+	med.windows, med.sizes, med.stacked = windows, sizes, stacked
+	med.window = active
+	med.file = windows[active]
+	med.diff = nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+)
+
+var shellKeywords = map[string]bool{
+	"if": true, "then": true, "else": true, "elif": true, "fi": true,
+	"for": true, "while": true, "until": true, "do": true, "done": true,
+	"case": true, "esac": true, "in": true, "function": true, "select": true,
+	"return": true, "break": true, "continue": true, "local": true,
+	"export": true, "readonly": true, "shift": true, "exit": true,
+}
+
+func isShellWord(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// Return the end of the quoted string starting at p, which is the end of the
+// text if it isn't closed.
+func shellString(text []byte, p int) int {
+	q := text[p]
+	for p++; p < len(text); p++ {
+		if text[p] == '\\' && q == '"' {
+			p++
+		} else if text[p] == q {
+			return p + 1
+		}
+	}
+	return len(text)
+}
+
+// Return the end of the variable reference starting at p, or p if there is
+// none.
+func shellVariable(text []byte, p int) int {
+	if p+1 >= len(text) {
+		return p
+	}
+	c := text[p+1]
+	switch {
+	case c == '{':
+		if i := bytes.IndexByte(text[p:], '}'); i > 0 {
+			return p + i + 1
+		}
+		return p
+	case c >= '0' && c <= '9' || bytes.IndexByte([]byte("@*#?$!-"), c) >= 0:
+		return p + 2
+	}
+	e := p + 1
+	for e < len(text) && isShellWord(text[e]) && !(e == p+1 && text[e] >= '0' && text[e] <= '9') {
+		e++
+	}
+	if e == p+1 {
+		return p
+	}
+	return e
+}
+
+// Parse the delimiter of the here-document starting with << at p. Returns
+// the delimiter, whether leading tabs are stripped from the lines of the
+// document (<<-), and the end of the redirection.
+func shellHeredoc(text []byte, p int) (delim string, tabs bool, end int) {
+	end = p + 2
+	if end < len(text) && text[end] == '-' {
+		tabs = true
+		end++
+	}
+	for end < len(text) && (text[end] == ' ' || text[end] == '\t') {
+		end++
+	}
+	start := end
+	for end < len(text) && (isShellWord(text[end]) || text[end] == '\'' || text[end] == '"') {
+		end++
+	}
+	delim = string(bytes.Trim(text[start:end], `'"`))
+	return
+}
+
+// Highlight shell scripts.
+func shellSyntax(text []byte, off int, maxLines int) (res []Highlight) {
+	// Highlights are needed up to the end of the last line.
+	limit := off
+	for l := 0; l < maxLines && limit < len(text); l++ {
+		if i := bytes.IndexByte(text[limit:], '\n'); i >= 0 {
+			limit += i + 1
+		} else {
+			limit = len(text)
+		}
+	}
+	add := func(start, end int, attr string) {
+		if end > off {
+			res = append(res, Highlight{start, end, theme[attr]})
+		}
+	}
+	type heredoc struct {
+		delim string
+		tabs  bool
+	}
+	var heredocs []heredoc
+	// Always scan from the beginning of the text, as off might point into
+	// the middle of a multi-line string or here-document.
+	for p := 0; p < len(text) && p < limit; {
+		c := text[p]
+		word := p > 0 && isShellWord(text[p-1])
+		switch {
+		case c == '\n':
+			p++
+			// Here-documents start on the next line and end with a line
+			// that is just the delimiter.
+			for _, h := range heredocs {
+				start := p
+				for p < len(text) {
+					e := bytes.IndexByte(text[p:], '\n')
+					if e < 0 {
+						e = len(text)
+					} else {
+						e += p
+					}
+					line := text[p:e]
+					if h.tabs {
+						line = bytes.TrimLeft(line, "\t")
+					}
+					p = min(e+1, len(text))
+					if string(line) == h.delim {
+						break
+					}
+				}
+				add(start, p, "string")
+			}
+			heredocs = nil
+		case c == '\\':
+			p += 2
+		case c == '#' && (p == 0 || bytes.IndexByte([]byte(" \t\n;&|("), text[p-1]) >= 0):
+			e := bytes.IndexByte(text[p:], '\n')
+			if e < 0 {
+				e = len(text) - p
+			}
+			add(p, p+e, "comment")
+			p += e
+		case c == '\'' || c == '"':
+			e := shellString(text, p)
+			add(p, e, "string")
+			p = e
+		case c == '$':
+			e := shellVariable(text, p)
+			if e > p {
+				add(p, e, "variable")
+				p = e
+			} else {
+				p++
+			}
+		case c == '<' && p+1 < len(text) && text[p+1] == '<' && (p+2 >= len(text) || text[p+2] != '<'):
+			delim, tabs, e := shellHeredoc(text, p)
+			if delim != "" {
+				heredocs = append(heredocs, heredoc{delim, tabs})
+			}
+			p = e
+		case isShellWord(c) && !word:
+			e := p
+			for e < len(text) && isShellWord(text[e]) {
+				e++
+			}
+			if shellKeywords[string(text[p:e])] {
+				add(p, e, "keyword")
+			}
+			p = e
+		default:
+			p++
+		}
+	}
+	return
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"github.com/jsynacek/med/buffer"
+	"io"
+	"os/exec"
+)
+
+// A shell buffer talks to a shell running in the background. The last line
+// of the buffer is the prompt. Enter sends it to the shell and the output is
+// inserted above the prompt as it comes.
+
+const shellPrompt = "$ "
+
+type Shell struct {
+	stdin io.WriteCloser
+	// Output after the last newline, inserted once the line is complete.
+	partial []byte
+}
+
+// Insert output of the shell above the prompt line.
+func (sh *Shell) output(file *File, text []byte) {
+	text = append(sh.partial, text...)
+	i := bytes.LastIndexByte(text, '\n')
+	sh.partial = append([]byte(nil), text[i+1:]...)
+	sh.insert(file, text[:i+1])
+}
+
+func (sh *Shell) insert(file *File, text []byte) {
+	if len(text) == 0 {
+		return
+	}
+	ls := buffer.LineStart(file.text.Bytes(), file.text.Len())
+	off := file.point.Off
+	if off >= ls {
+		off += len(text)
+	}
+	file.Goto(ls)
+	file.pushUndo(text, ls, true)
+	file.insert(text)
+	file.Goto(off)
+	file.modified = false
+}
+
+// Send the prompt line to the shell and start a new one.
+func (sh *Shell) send(file *File) {
+	if len(sh.partial) > 0 {
+		sh.insert(file, append(sh.partial, '\n'))
+		sh.partial = nil
+	}
+	ls := buffer.LineStart(file.text.Bytes(), file.text.Len())
+	line := bytes.TrimPrefix(file.text.Bytes()[ls:], []byte(shellPrompt))
+	sh.stdin.Write(append(append([]byte(nil), line...), '\n'))
+	file.Goto(file.text.Len())
+	file.Insert([]byte("\n" + shellPrompt))
+	file.modified = false
+}
+
+// Switch to the shell buffer, starting the shell in the directory of the
+// file if it's not running.
+func shellBuffer(med *Med, file *File) {
+	for e := med.files.Front(); e != nil; e = e.Next() {
+		if f := e.Value.(*File); f.shell != nil {
+			med.file = e
+			f.Goto(f.text.Len())
+			editingMode(med, f)
+			return
+		}
+	}
+	cmd := exec.Command("sh")
+	cmd.Dir = file.dir()
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		med.pushError(err)
+		return
+	}
+	shell := med.NewScratchBuffer("*shell*", []byte(shellPrompt))
+	shell.shell = &Shell{stdin: stdin}
+	shell.Goto(shell.text.Len())
+	job := &Job{name: "shell", file: shell}
+	cmd.Stdout = jobWriter{med, job}
+	cmd.Stderr = cmd.Stdout
+	job.run = func() ([]byte, error) {
+		return nil, cmd.Run()
+	}
+	job.done = func(out []byte, err error) {
+		shell.shell = nil
+		if err != nil {
+			med.showMessage("shell: %v", err)
+		} else {
+			med.showMessage("shell exited")
+		}
+	}
+	med.runJob(job)
+	editingMode(med, shell)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/jsynacek/med/buffer"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Fields of data files, separated by blanks, commas, semicolons or bars.
+func dataFields(text []byte) [][]byte {
+	return bytes.FieldsFunc(text, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(",;|", r)
+	})
+}
+
+// Show the count, sum, minimum, maximum and mean of the fields that are
+// numbers in the selection, or the line of the point without one.
+func selectionStats(med *Med, file *File) {
+	start, end := buffer.LineRange(file.text.Bytes(), file.point.Off, false)
+	if med.selection.active {
+		start, end = med.selectionRange(file)
+	}
+	var n int
+	var sum float64
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, m := range dataFields(file.text.Slice(start, end)) {
+		x, err := strconv.ParseFloat(string(m), 64)
+		// Words like inf and nan parse too.
+		if err != nil || math.IsInf(x, 0) || math.IsNaN(x) {
+			continue
+		}
+		n++
+		sum += x
+		lo = math.Min(lo, x)
+		hi = math.Max(hi, x)
+	}
+	if n == 0 {
+		med.pushError(errors.New("no numbers"))
+		return
+	}
+	// Rounded, so sums like 0.1+0.2 don't show the binary error.
+	f := func(x float64) string {
+		return strconv.FormatFloat(math.Round(x*1e6)/1e6, 'f', -1, 64)
+	}
+	med.showMessage("count %d  sum %s  min %s  max %s  mean %s", n, f(sum), f(lo), f(hi), f(sum/float64(n)))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"path/filepath"
+)
+
+// A Highlighter finds the syntax highlights of text. Only the highlights of
+// the first maxLines lines starting at off are needed, but text is always
+// the whole text, so highlighters can scan it from the beginning.
+type Highlighter interface {
+	Highlight(text []byte, off, maxLines int) []Highlight
+}
+
+// A Highlighter that parses the text into a syntax tree can also be a
+// NodeFinder, so the word and block selections follow its nodes instead of
+// guessing from characters. Like markWord and markBlock, it returns the range
+// of the word at off and the inside of the brackets around it.
+type NodeFinder interface {
+	WordAt(text []byte, off int) (start, end int, ok bool)
+	BlockAt(text []byte, off int) (start, end int, ok bool)
+}
+
+// HighlighterFunc adapts a function to the Highlighter interface.
+type HighlighterFunc func(text []byte, off, maxLines int) []Highlight
+
+func (f HighlighterFunc) Highlight(text []byte, off, maxLines int) []Highlight {
+	return f(text, off, maxLines)
+}
+
+// Highlighters by file type. New languages are added here. Files of a type not in the map are highlighted
+// as Go, which used to be the only syntax there was.
+var highlighters = map[string]Highlighter{
+	"go":       &goHighlighter{},
+	"md":       HighlighterFunc(markdownSyntax),
+	"markdown": HighlighterFunc(markdownSyntax),
+	"sh":       HighlighterFunc(shellSyntax),
+	"bash":     HighlighterFunc(shellSyntax),
+	"json":     HighlighterFunc(jsonSyntax),
+	"yaml":     HighlighterFunc(yamlSyntax),
+	"yml":      HighlighterFunc(yamlSyntax),
+}
+
+// File types of files without an extension, by the interpreter on their
+// shebang line.
+var interpreterTypes = map[string]string{
+	"sh":      "sh",
+	"bash":    "sh",
+	"python":  "py",
+	"python3": "py",
+	"perl":    "pl",
+}
+
+// Return the file type of file, from its extension or its shebang line.
+func (file *File) syntaxType() string {
+	if ft := fileType(file.path); ft != "" {
+		return ft
+	}
+	if interp, ok := shebang(file.text.Bytes()); ok {
+		name := filepath.Base(interp[0])
+		// #!/usr/bin/env python
+		if name == "env" && len(interp) > 1 {
+			name = interp[1]
+		}
+		return interpreterTypes[name]
+	}
+	return ""
+}
+
+// Return the highlighter for file.
+func (file *File) highlighter() Highlighter {
+	if h, ok := highlighters[file.syntaxType()]; ok {
+		return h
+	}
+	return highlighters["go"]
+}
+
+// Return the syntax highlights of maxLines lines of file starting at off.
+func (file *File) syntax(off, maxLines int) []Highlight {
+	if file.large {
+		return nil
+	}
+	return file.highlighter().Highlight(file.text.Bytes(), off, maxLines)
+}
+
+// Return the range of the word at off in file.
+func (file *File) markWord(off int) (int, int, bool) {
+	if nf, ok := file.highlighter().(NodeFinder); ok && !file.large {
+		return nf.WordAt(file.text.Bytes(), off)
+	}
+	return markWord(file.text.Bytes(), off)
+}
+
+// Return the range of the inside of the block around off in file.
+func (file *File) markBlock(off int) (int, int, bool) {
+	if nf, ok := file.highlighter().(NodeFinder); ok && !file.large {
+		return nf.BlockAt(file.text.Bytes(), off)
+	}
+	return markBlock(file.text.Bytes(), off)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// New files start with the template for their extension, if there is one, in
+// the templates directory of the configuration, like ~/.config/med/templates/go
+// for Go files. The point is put where the template has {{point}}, which is
+// removed, or at its end.
+
+const templatePoint = "{{point}}"
+
+// Fill a file that doesn't exist yet with its template.
+func (med *Med) applyTemplate(file *File) {
+	if file.path == "" || file.text.Len() > 0 {
+		return
+	}
+	if _, err := os.Stat(file.path); !os.IsNotExist(err) {
+		return
+	}
+	ext := strings.TrimPrefix(filepath.Ext(file.path), ".")
+	if ext == "" {
+		return
+	}
+	text, err := ioutil.ReadFile(filepath.Join(med.config.dir, "templates", ext))
+	if err != nil {
+		return
+	}
+	off := bytes.Index(text, []byte(templatePoint))
+	if off >= 0 {
+		text = append(text[:off:off], text[off+len(templatePoint):]...)
+	} else {
+		off = len(text)
+	}
+	file.Insert(text)
+	file.UndoBlock()
+	file.Goto(off)
+}
@@ -8,20 +8,27 @@ import (
 	"image/color"
 	"fmt"
 	"bufio"
+	"bytes"
 	"os"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 /*
 #include <sys/ioctl.h>
 #include <termios.h>
+// Without a terminal, pretend there is one of the usual size.
 int term_rows() {
 	struct winsize ws;
-	ioctl(0, TIOCGWINSZ, &ws);
+	if (ioctl(0, TIOCGWINSZ, &ws) < 0 || ws.ws_row == 0)
+		return 24;
 	return ws.ws_row;
 }
 int term_cols() {
 	struct winsize ws;
-	ioctl(0, TIOCGWINSZ, &ws);
+	if (ioctl(0, TIOCGWINSZ, &ws) < 0 || ws.ws_col == 0)
+		return 80;
 	return ws.ws_col;
 }
 struct termios ostate, nstate;
@@ -49,8 +56,13 @@ import "C"
 //
 // \033[ ? 1049 h - Save cursor and use alternate buffer.
 // \033[ ? 1049 l - Restore cursor and use normal screen buffer.
+// \033[ J        - Erase display below cursor, used in the inline mode.
 // \033[ ? 25 l   - Hide cursor.
 // \033[ ? 25 h   - Show cursor.
+// \033[ ? 1000 h - Report mouse button presses and releases.
+// \033[ ? 1000 l - Stop reporting mouse buttons.
+// \033[ ? 1006 h - Use SGR format for mouse reports, \033[ < b ; x ; y M (or m on release).
+// \033[ ? 1006 l - Use the default format for mouse reports.
 // \033[ y ; x f  - Move cursor to y, x.
 // \033[ 0 K      - Erase from cursor to the end of line.
 // \033[ 1 J      - Erase display from cursor.
@@ -82,11 +94,35 @@ const (
 )
 
 type Term struct {
-	writer *bufio.Writer
+	// Output not flushed yet, usually a frame being drawn.
+	buf bytes.Buffer
+	// Flushed output waiting for the writer, see writeOut.
+	out chan []byte
+	done chan struct{}
 	rows int
 	cols int
+	// Last frame written and the size of the terminal it was drawn for.
+	last []byte
+	lastRows, lastCols int
+	maxFPS int
+	begin time.Time
+	ended time.Time
+	stats FrameStats
+	// Time the last write took, in nanoseconds, set by the writer.
+	writeTime int64
 }
 
+// Frames drawn by BeginFrame and EndFrame.
+type FrameStats struct {
+	Frames int // Frames written to the terminal.
+	Skipped int // Frames not written, as they were the same as the last one.
+	Render time.Duration // Time between BeginFrame and EndFrame of the last frame.
+	Write time.Duration // Time the terminal took to take the last output.
+}
+
+// Rows at the bottom of the terminal used in the inline mode, see Inline.
+var inline int
+
 type TermError int
 
 func (e TermError) Error() string {
@@ -98,10 +134,25 @@ func (e TermError) Error() string {
 	return "Unknown error"
 }
 
+// Use only the bottom rows of the terminal, instead of switching to the
+// alternate screen, so whatever is above stays visible and in the scrollback.
+// Must be called before Init.
+func Inline(rows int) {
+	inline = rows
+}
+
 func Rows() int {
+	if inline > 0 {
+		return min(inline, int(C.term_rows()))
+	}
 	return int(C.term_rows())
 }
 
+// Terminal row of the first row used, which is not 0 in the inline mode.
+func Top() int {
+	return int(C.term_rows()) - Rows()
+}
+
 func Cols() int {
 	return int(C.term_cols())
 }
@@ -124,26 +175,77 @@ func Restore() error {
 
 func NewTerm() *Term {
 	t := new(Term)
-	//Hold enough for a really large terminal and a lot of escape sequences.
-	t.writer = bufio.NewWriterSize(os.Stdout, 16*1024)
+	t.out = make(chan []byte, 1)
+	t.done = make(chan struct{})
 	t.rows = int(C.term_rows())
 	t.cols = int(C.term_cols())
+	go t.writeOut()
 	return t
 }
 
+// Write flushed output to the terminal, so a slow terminal doesn't hold up
+// drawing of the next frame.
+func (t *Term) writeOut() {
+	//Hold enough for a really large terminal and a lot of escape sequences.
+	w := bufio.NewWriterSize(os.Stdout, 16*1024)
+	for bs := range t.out {
+		start := time.Now()
+		w.Write(bs)
+		w.Flush()
+		atomic.StoreInt64(&t.writeTime, int64(time.Since(start)))
+	}
+	close(t.done)
+}
+
 func (t *Term) Init() {
-	t.Write([]byte("\033[?1049h\033[?25l"))
+	if inline > 0 {
+		// Scroll what's on the screen up to make room.
+		t.Write([]byte(strings.Repeat("\n", Rows()) + "\033[?25l"))
+	} else {
+		t.Write([]byte("\033[?1049h\033[?25l"))
+	}
 	t.Flush()
 }
 
 func (t *Term) Finish() {
-	t.Write([]byte("\033[0m\033[?25h\033[?1049l"))
+	t.MouseOff()
+	t.FocusOff()
+	if inline > 0 {
+		t.MoveTo(0, 0)
+		t.Write([]byte("\033[0m\033[J\033[?25h"))
+	} else {
+		t.Write([]byte("\033[0m\033[?25h\033[?1049l"))
+	}
 	t.Flush()
+	close(t.out)
+	<-t.done
 	Restore()
 }
 
+func (t *Term) MouseOn() {
+	t.Write([]byte("\033[?1000h\033[?1006h"))
+}
+
+func (t *Term) MouseOff() {
+	t.Write([]byte("\033[?1006l\033[?1000l"))
+}
+
+// Report the terminal gaining and losing focus, as CSI I and CSI O.
+func (t *Term) FocusOn() {
+	t.Write([]byte("\033[?1004h"))
+}
+
+func (t *Term) FocusOff() {
+	t.Write([]byte("\033[?1004l"))
+}
+
+// Start an OSC 8 hyperlink to uri, or end it if uri is empty.
+func (t *Term) Hyperlink(uri string) {
+	t.Write([]byte("\033]8;;" + uri + "\033\\"))
+}
+
 func (t *Term) MoveTo(row int, col int) {
-	t.Write([]byte(fmt.Sprintf("\033[%d;%df", row+1, col+1)))
+	t.Write([]byte(fmt.Sprintf("\033[%d;%df", Top()+row+1, col+1)))
 }
 
 func (t *Term) AttrFgRGB(c *color.RGBA) {
@@ -163,14 +265,65 @@ func (t *Term) EraseEol() {
 }
 
 func (t *Term) EraseDisplay() {
+	if inline > 0 {
+		t.MoveTo(0, 0)
+		t.Write([]byte("\033[J"))
+		return
+	}
 	t.MoveTo(t.rows, t.cols)
 	t.Write([]byte("\033[1J"))
 }
 
 func (t *Term) Write(bs []byte) {
-	t.writer.Write(bs)
+	t.buf.Write(bs)
 }
 
+// Hand the output over to the writer. It is written in the background.
 func (t *Term) Flush() {
-	t.writer.Flush()
+	if t.buf.Len() == 0 {
+		return
+	}
+	t.out <- append([]byte(nil), t.buf.Bytes()...)
+	t.buf.Reset()
+}
+
+// Limit the frames to n per second, or don't if n is 0, see NextFrame.
+func (t *Term) SetMaxFPS(n int) {
+	t.maxFPS = n
+}
+
+// Time left until the next frame is due, or 0 if it can be drawn right away.
+func (t *Term) NextFrame() time.Duration {
+	if t.maxFPS <= 0 {
+		return 0
+	}
+	return max(0, time.Until(t.ended.Add(time.Second/time.Duration(t.maxFPS))))
+}
+
+// Start drawing a frame, which is ended by EndFrame.
+func (t *Term) BeginFrame() {
+	t.begin = time.Now()
+}
+
+// Flush the frame, unless it's the same as the last one, drawn for a terminal
+// of the same size.
+func (t *Term) EndFrame() {
+	t.ended = time.Now()
+	t.stats.Render = t.ended.Sub(t.begin)
+	rows, cols := int(C.term_rows()), int(C.term_cols())
+	if bytes.Equal(t.buf.Bytes(), t.last) && rows == t.lastRows && cols == t.lastCols {
+		t.stats.Skipped++
+		t.buf.Reset()
+		return
+	}
+	t.last = append(t.last[:0], t.buf.Bytes()...)
+	t.lastRows, t.lastCols = rows, cols
+	t.stats.Frames++
+	t.Flush()
+}
+
+func (t *Term) Stats() FrameStats {
+	stats := t.stats
+	stats.Write = time.Duration(atomic.LoadInt64(&t.writeTime))
+	return stats
 }
@@ -49,11 +49,40 @@ var solarizedTheme = Theme{
 	"dialogPrompt": Attribute{solarizedPalette["blue"], solarizedPalette["base3"]},
 	"error":        Attribute{solarizedPalette["red"], solarizedPalette["base3"]},
 	"selection":    Attribute{nil, solarizedPalette["base2"]},
+	"flash":        Attribute{solarizedPalette["base3"], solarizedPalette["yellow"]},
+	"preview":      Attribute{nil, solarizedPalette["base2"]},
+	"match":        Attribute{solarizedPalette["base3"], solarizedPalette["cyan"]},
+	"diagnostic":   Attribute{solarizedPalette["red"], solarizedPalette["base2"]},
+	"duplicate":    Attribute{solarizedPalette["base3"], solarizedPalette["magenta"]},
+	"indentGuide":  Attribute{solarizedPalette["base2"], nil},
+	"fold":         Attribute{solarizedPalette["base1"], solarizedPalette["base2"]},
+	"invisible":    Attribute{solarizedPalette["base3"], solarizedPalette["red"]},
+	"bookmark":     Attribute{solarizedPalette["blue"], nil},
+	// Popups.
+	"popup":         Attribute{solarizedPalette["base00"], solarizedPalette["base2"]},
+	"popupSelected": Attribute{solarizedPalette["base3"], solarizedPalette["blue"]},
+	// Outline sidebar.
+	"outlineCurrent": Attribute{solarizedPalette["base3"], solarizedPalette["blue"]},
 	// Language.
-	"comment": Attribute{solarizedPalette["base1"], nil},
-	"keyword": Attribute{solarizedPalette["green"], nil},
-	"string":  Attribute{solarizedPalette["red"], nil},
-	"char":    Attribute{solarizedPalette["orange"], nil},
+	"comment":  Attribute{solarizedPalette["base1"], nil},
+	"keyword":  Attribute{solarizedPalette["green"], nil},
+	"string":   Attribute{solarizedPalette["red"], nil},
+	"char":     Attribute{solarizedPalette["orange"], nil},
+	"variable": Attribute{solarizedPalette["blue"], nil},
+	"key":      Attribute{solarizedPalette["blue"], nil},
+	"number":   Attribute{solarizedPalette["magenta"], nil},
+	// Markdown.
+	"mdHeading":  Attribute{solarizedPalette["blue"], nil},
+	"mdEmphasis": Attribute{solarizedPalette["violet"], nil},
+	"mdCode":     Attribute{solarizedPalette["cyan"], nil},
+	"mdLink":     Attribute{solarizedPalette["green"], nil},
+	// Diffs.
+	"diffAdded":   Attribute{solarizedPalette["green"], nil},
+	"diffRemoved": Attribute{solarizedPalette["red"], nil},
+	"diffChanged": Attribute{solarizedPalette["yellow"], nil},
+	// Man pages.
+	"manBold":      Attribute{solarizedPalette["base01"], nil},
+	"manUnderline": Attribute{solarizedPalette["violet"], nil},
 }
 
 var theme = solarizedTheme
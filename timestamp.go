@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// Formats of the time inserted by insertTimestamp, as in time.Format,
+// separated by bars.
+func timestampFormats() (formats []string) {
+	for _, f := range strings.Split(timeFormats, "|") {
+		if f = strings.TrimSpace(f); f != "" {
+			formats = append(formats, f)
+		}
+	}
+	return
+}
+
+// Insert the current time. With more than one format in timeFormats, pick
+// it from a helm.
+func insertTimestamp(med *Med, file *File) {
+	now := time.Now()
+	var stamps []string
+	for _, f := range timestampFormats() {
+		stamps = append(stamps, now.Format(f))
+	}
+	if len(stamps) < 2 {
+		if len(stamps) == 1 {
+			file.Insert([]byte(stamps[0]))
+		}
+		return
+	}
+	update := func() {}
+	finish := func(cancel bool) {
+		if !cancel {
+			file.Insert(append([]byte(nil), med.dialog.file.text.Bytes()...))
+		}
+	}
+	complete := func() {
+		var data []string
+		for _, s := range stamps {
+			if strings.Contains(s, string(med.dialog.file.text.Bytes())) {
+				data = append(data, s)
+			}
+		}
+		med.dialog.helm.data = data
+	}
+	med.startDialog("time", update, finish, NewHelm(complete))
+}
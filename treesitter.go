@@ -0,0 +1,156 @@
+//go:build treesitter
+// +build treesitter
+
+package main
+
+// With the treesitter build tag, languages with a tree-sitter grammar are
+// highlighted from their syntax tree, and the word and block selections pick
+// its nodes:
+//
+//	go get github.com/smacker/go-tree-sitter
+//	go build -tags treesitter
+//
+// The tree is kept for the last text parsed per language, so redrawing the
+// same text doesn't parse it again.
+
+import (
+	"bytes"
+	"context"
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/bash"
+	"github.com/smacker/go-tree-sitter/c"
+	"github.com/smacker/go-tree-sitter/golang"
+	"github.com/smacker/go-tree-sitter/javascript"
+	"github.com/smacker/go-tree-sitter/python"
+	"github.com/smacker/go-tree-sitter/rust"
+	"strings"
+)
+
+func init() {
+	for ft, lang := range map[string]*sitter.Language{
+		"go":   golang.GetLanguage(),
+		"sh":   bash.GetLanguage(),
+		"bash": bash.GetLanguage(),
+		"c":    c.GetLanguage(),
+		"h":    c.GetLanguage(),
+		"js":   javascript.GetLanguage(),
+		"py":   python.GetLanguage(),
+		"rs":   rust.GetLanguage(),
+	} {
+		highlighters[ft] = &treeSitter{lang: lang}
+	}
+}
+
+type treeSitter struct {
+	lang *sitter.Language
+	// The last text parsed and its tree.
+	text []byte
+	tree *sitter.Tree
+}
+
+// Return the root of the syntax tree of text.
+func (ts *treeSitter) root(text []byte) *sitter.Node {
+	if ts.tree == nil || !bytes.Equal(ts.text, text) {
+		p := sitter.NewParser()
+		p.SetLanguage(ts.lang)
+		tree, err := p.ParseCtx(context.Background(), nil, text)
+		if err != nil {
+			return nil
+		}
+		ts.text = append(ts.text[:0], text...)
+		ts.tree = tree
+	}
+	return ts.tree.RootNode()
+}
+
+// Theme attribute of a node highlighted as a whole, or "" if the node isn't.
+func nodeAttr(n *sitter.Node) string {
+	t := n.Type()
+	switch {
+	case strings.Contains(t, "comment"):
+		return "comment"
+	case strings.Contains(t, "string") || t == "heredoc_body":
+		return "string"
+	case strings.Contains(t, "rune") || strings.Contains(t, "char"):
+		return "char"
+	case strings.Contains(t, "int") && strings.Contains(t, "literal") || strings.Contains(t, "float") || t == "number" || t == "integer":
+		return "number"
+	case t == "true" || t == "false" || t == "nil" || t == "null" || t == "None" || t == "True" || t == "False":
+		return "keyword"
+	case !n.IsNamed() && isKeyword(t):
+		return "keyword"
+	}
+	return ""
+}
+
+// Anonymous nodes made of letters are keywords.
+func isKeyword(t string) bool {
+	for _, r := range t {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+	return t != ""
+}
+
+func (ts *treeSitter) Highlight(text []byte, off, maxLines int) (res []Highlight) {
+	root := ts.root(text)
+	if root == nil {
+		return nil
+	}
+	end := off
+	for l := 0; l < maxLines && end < len(text); l++ {
+		if i := bytes.IndexByte(text[end:], '\n'); i >= 0 {
+			end += i + 1
+		} else {
+			end = len(text)
+		}
+	}
+	var walk func(n *sitter.Node)
+	walk = func(n *sitter.Node) {
+		s, e := int(n.StartByte()), int(n.EndByte())
+		if e <= off || s >= end {
+			return
+		}
+		if attr := nodeAttr(n); attr != "" {
+			res = append(res, Highlight{s, e, theme[attr]})
+			return
+		}
+		for i := 0; i < int(n.ChildCount()); i++ {
+			walk(n.Child(i))
+		}
+	}
+	walk(root)
+	return
+}
+
+func (ts *treeSitter) WordAt(text []byte, off int) (int, int, bool) {
+	root := ts.root(text)
+	if root == nil {
+		return 0, 0, false
+	}
+	n := root.NamedDescendantForByteRange(uint32(off), uint32(off))
+	if n == nil || n.ChildCount() > 0 {
+		return 0, 0, false
+	}
+	return int(n.StartByte()), int(n.EndByte()), true
+}
+
+func (ts *treeSitter) BlockAt(text []byte, off int) (int, int, bool) {
+	root := ts.root(text)
+	if root == nil {
+		return 0, 0, false
+	}
+	for n := root.DescendantForByteRange(uint32(off), uint32(off)); n != nil; n = n.Parent() {
+		k := int(n.ChildCount())
+		if k < 2 {
+			continue
+		}
+		first, last := n.Child(0), n.Child(k-1)
+		pair := first.Type() + last.Type()
+		if (pair == "{}" || pair == "()" || pair == "[]") && int(first.EndByte()) <= off && int(last.StartByte()) >= off {
+			return int(first.EndByte()), int(last.StartByte()), true
+		}
+	}
+	return 0, 0, false
+}
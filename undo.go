@@ -0,0 +1,289 @@
+package main
+
+// Undo record.
+//
+// After every insert/delete operation, an undo record is added to the current
+// undo block. Undo and redo always apply a whole block at once. The editor
+// closes the current block after every command, so a block corresponds to
+// what the user sees as a single action. Commands made of several steps, which
+// would otherwise close blocks in between, group them with BeginUndoGroup and
+// EndUndoGroup.
+//
+// Blocks form a tree. Undo moves to the parent of the current block, redo to
+// one of its children, the one it came from or the last one made. An edit
+// after an undo starts a new branch, instead of throwing away what was undone,
+// so other branches can still be reached with undoBranchNext and
+// undoBranchPrev, which pick the child redo moves to.
+//
+// When creating one, first the point should be moved, then the point offset
+// saved, then the operation performed and inserted/deleted text copied.
+//
+// Inserts right after the previous insert of the block and deletes next to the
+// previous delete are merged into its record, so typing doesn't make a record
+// per character. Once the records take more than undoBytes, the oldest blocks
+// are dropped.
+type Undo struct {
+	// Offset of the change. It is always at the beginning of the change.
+	off int
+	// Copy of the changed text.
+	text []byte
+	// True if text was inserted during the change, false if deleted.
+	isInsert bool
+}
+
+// Undo block, a node of the undo tree.
+type UndoNode struct {
+	parent *UndoNode
+	// Blocks made on top of this one, oldest first, and the one redo moves
+	// to.
+	children []*UndoNode
+	branch   int
+	// Records in the order they were made.
+	undos []Undo
+}
+
+// Undo history of a file. The text is the result of all blocks from the root
+// to the current one.
+type UndoTree struct {
+	root    *UndoNode
+	current *UndoNode
+	// Whether changes are still added to the current block.
+	open bool
+	// Depth of nested undo groups, see BeginUndoGroup.
+	groups int
+	// Bytes of text of all records.
+	size int
+}
+
+func NewUndoTree() *UndoTree {
+	root := new(UndoNode)
+	return &UndoTree{root: root, current: root}
+}
+
+// Bytes of text of the records of the block and all blocks made on top of it.
+func (b *UndoNode) size() int {
+	n := 0
+	for _, u := range b.undos {
+		n += len(u.text)
+	}
+	for _, c := range b.children {
+		n += c.size()
+	}
+	return n
+}
+
+// Drop the oldest blocks on the way to the current one, until the records
+// take at most limit bytes. Branches starting before them go along.
+func (t *UndoTree) trim(limit int) {
+	for limit > 0 && t.size > limit {
+		b := t.current
+		for b != t.root && b.parent != t.root {
+			b = b.parent
+		}
+		if b == t.root || b == t.current {
+			// Keep the current block, so it can be undone.
+			return
+		}
+		t.size -= t.root.size() - b.size()
+		for _, u := range b.undos {
+			t.size -= len(u.text)
+		}
+		b.parent, b.undos = nil, nil
+		t.root = b
+	}
+}
+
+func (file *File) pushUndo(what []byte, off int, isInsert bool) {
+	// Mini file (dialogs) doesn't use the undo tree.
+	t := file.undos
+	if t == nil {
+		return
+	}
+	if !t.open {
+		b := &UndoNode{parent: t.current}
+		t.current.children = append(t.current.children, b)
+		t.current.branch = len(t.current.children) - 1
+		t.current = b
+		t.open = true
+	}
+	t.size += len(what)
+	if n := len(t.current.undos); n > 0 {
+		last := &t.current.undos[n-1]
+		switch {
+		case isInsert && last.isInsert && off == last.off+len(last.text):
+			last.text = append(last.text, what...)
+			return
+		case !isInsert && !last.isInsert && off == last.off:
+			// Delete forward.
+			last.text = append(last.text, what...)
+			return
+		case !isInsert && !last.isInsert && off+len(what) == last.off:
+			// Backspace.
+			last.off = off
+			last.text = append(append([]byte(nil), what...), last.text...)
+			return
+		}
+	}
+	u := Undo{off, append([]byte(nil), what...), isInsert}
+	t.current.undos = append(t.current.undos, u)
+}
+
+// Close the current undo block. Following changes will be undone separately.
+// Inside an undo group, the block is left open until the group ends.
+func (file *File) UndoBlock() {
+	if file.undos != nil && file.undos.groups == 0 {
+		file.undos.open = false
+		file.undos.trim(undoBytes)
+	}
+}
+
+// Start an undo group. All changes until the matching EndUndoGroup make
+// a single undo block, separate from the changes before, even if commands
+// in between close blocks. Groups can be nested, only the outermost one
+// counts.
+func (file *File) BeginUndoGroup() {
+	if file.undos == nil {
+		return
+	}
+	if file.undos.groups == 0 {
+		file.UndoBlock()
+	}
+	file.undos.groups++
+}
+
+// End an undo group started by BeginUndoGroup.
+func (file *File) EndUndoGroup() {
+	if file.undos == nil || file.undos.groups == 0 {
+		return
+	}
+	file.undos.groups--
+	file.UndoBlock()
+}
+
+// Extend the range of text affected by an undo or redo by a single record.
+func (u Undo) extend(start, end int, restored bool) (int, int) {
+	e := u.off
+	if restored {
+		e += len(u.text)
+	}
+	if start < 0 {
+		return u.off, e
+	}
+	return min(start, u.off), max(end, e)
+}
+
+// Return the block Undo, or Redo if redo is set, would apply next, or nil.
+func (file *File) nextUndoBlock(redo bool) *UndoNode {
+	t := file.undos
+	switch {
+	case t == nil:
+		return nil
+	case redo && len(t.current.children) > 0:
+		return t.current.children[t.current.branch]
+	case !redo && t.current != t.root:
+		return t.current
+	}
+	return nil
+}
+
+// Undo the current undo block. Returns the range of the affected text,
+// which is empty if the text was only removed, or -1, -1 if there was nothing
+// to undo. The returned range is not exact if the block spans several places.
+func (file *File) Undo() (start, end int) {
+	start, end = -1, -1
+	t := file.undos
+	if t == nil || t.current == t.root {
+		return
+	}
+	b := t.current
+	for i := len(b.undos) - 1; i >= 0; i-- {
+		u := b.undos[i]
+		file.Goto(u.off)
+		if u.isInsert {
+			file.delete(u.off, u.off+len(u.text))
+		} else {
+			// Use insert() so the undo record is not recreated.
+			file.insert(u.text)
+		}
+		start, end = u.extend(start, end, !u.isInsert)
+	}
+	t.current, t.open = b.parent, false
+	for i, c := range t.current.children {
+		if c == b {
+			t.current.branch = i
+		}
+	}
+	return
+}
+
+// Redo the block on the current branch. Returns the range of the affected
+// text in the same way as Undo.
+func (file *File) Redo() (start, end int) {
+	start, end = -1, -1
+	t := file.undos
+	if t == nil || len(t.current.children) == 0 {
+		return
+	}
+	b := t.current.children[t.current.branch]
+	for _, u := range b.undos {
+		file.Goto(u.off)
+		if u.isInsert {
+			file.insert(u.text)
+		} else {
+			file.delete(u.off, u.off+len(u.text))
+		}
+		start, end = u.extend(start, end, u.isInsert)
+	}
+	t.current, t.open = b, false
+	return
+}
+
+// Undo the current undo block and forget it, as if it was never made.
+func (file *File) UndoDiscard() {
+	t := file.undos
+	if t == nil || t.current == t.root {
+		return
+	}
+	b := t.current
+	file.Undo()
+	for i, c := range t.current.children {
+		if c == b {
+			t.current.children = append(t.current.children[:i:i], t.current.children[i+1:]...)
+			break
+		}
+	}
+	t.current.branch = max(0, len(t.current.children)-1)
+	t.size -= b.size()
+}
+
+// Pick the n-th next branch redo moves to, or previous one if n is negative.
+// Returns the branch picked and the number of them.
+func (file *File) UndoBranch(n int) (int, int) {
+	if file.undos == nil {
+		return 0, 0
+	}
+	b := file.undos.current
+	count := len(b.children)
+	if count == 0 {
+		return 0, 0
+	}
+	b.branch = ((b.branch+n)%count + count) % count
+	return b.branch, count
+}
+
+func undoBranchNext(med *Med, file *File) {
+	med.undoBranch(file, 1)
+}
+
+func undoBranchPrev(med *Med, file *File) {
+	med.undoBranch(file, -1)
+}
+
+func (med *Med) undoBranch(file *File, n int) {
+	i, count := file.UndoBranch(n)
+	if count == 0 {
+		med.showMessage("nothing to redo")
+		return
+	}
+	med.showMessage("redo branch %d of %d", i+1, count)
+}
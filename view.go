@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
+	"github.com/jsynacek/med/buffer"
 	"github.com/jsynacek/med/term"
 	"unicode/utf8"
 )
@@ -11,31 +14,55 @@ type Visual struct {
 	tabChar rune
 	tabFill rune
 	eofChar rune
+	// Displayed after a line broken at a chunk boundary, see buffer.LineChunk.
+	chunkChar rune
+	// Indentation guide, see guides.go.
+	guideChar rune
+	// Displayed instead of invisible characters, see buffer.Invisible.
+	invisibleChar rune
+	// Sign of bookmarked lines, see bookmark.go.
+	bookmarkChar rune
 }
 
 // A view into the edited text.
 type View struct {
 	start  int
+	top    int // Screen row of the view.
+	left   int // Screen column of the view.
 	width  int
 	height int
 	visual Visual
 	end    int // Set after scan.
+	// Closed folds, sorted, see fold.go.
+	folds []Dot
+	// Hyperlinks, sorted, see links.go.
+	links []Link
+	// Starts of bookmarked lines, sorted, see bookmark.go.
+	bookmarks []int
 }
 
 func NewVisual(show bool) Visual {
 	if show {
 		return Visual{
-			tabStop: 8,
-			tabChar: '»',
-			tabFill: '·',
-			eofChar: '~',
+			tabStop:       8,
+			tabChar:       '»',
+			tabFill:       '·',
+			eofChar:       '~',
+			chunkChar:     '…',
+			guideChar:     '│',
+			invisibleChar: '¤',
+			bookmarkChar:  '◆',
 		}
 	}
 	return Visual{
-		tabStop: 8,
-		tabChar: ' ',
-		tabFill: ' ',
-		eofChar: '~',
+		tabStop:       8,
+		tabChar:       ' ',
+		tabFill:       ' ',
+		eofChar:       '~',
+		chunkChar:     '…',
+		guideChar:     '│',
+		invisibleChar: '¤',
+		bookmarkChar:  '◆',
 	}
 }
 
@@ -49,7 +76,7 @@ func (view *View) lineEnd(text []byte, off int) int {
 		if r == '\t' {
 			col += view.visual.tabStop - (col % view.visual.tabStop)
 		} else {
-			col++
+			col += buffer.RuneWidth(r)
 		}
 		if r == '\n' {
 			return off + 1
@@ -59,6 +86,16 @@ func (view *View) lineEnd(text []byte, off int) int {
 	return off
 }
 
+// Return the closed fold off is in.
+func (view *View) foldAt(off int) (Dot, bool) {
+	for _, f := range view.folds {
+		if off >= f.start && off < f.end {
+			return f, true
+		}
+	}
+	return Dot{}, false
+}
+
 // Adjust view so the point is visible. Assumes view.end is set correctly.
 func (view *View) AdjustToPoint(text []byte, point int) {
 	if point >= view.end {
@@ -121,9 +158,43 @@ func (view *View) DisplayText(t *term.Term, text []byte, point int, selections [
 
 	// Main display loop, starts at view.start. It does only one pass and only switches colors
 	// when actually needed. At the end, view.end is set according to what was displayed.
-	t.MoveTo(0, 0)
+	t.MoveTo(view.top, view.left)
+	_, bound := buffer.LineChunk(text, p)
 	drawPoint := false
+	// Current hyperlink and whether it's started.
+	k, linked := 0, false
 	for p < len(text) && l < view.height {
+		if f, ok := view.foldAt(p); ok && col == 0 {
+			lines := bytes.Count(text[f.start:f.end], NL)
+			if text[f.end-1] != '\n' {
+				lines++
+			}
+			theme["fold"].Out(t)
+			t.Write([]byte(fitString(fmt.Sprintf("⋯ %d lines", lines), width)))
+			theme["normal"].Out(t)
+			p = f.end
+			l++
+			t.MoveTo(view.top+l, view.left)
+			bound = buffer.ChunkBound(p)
+			// Skip what ended in the fold.
+			for sel.end <= p && j < len(selections) {
+				if j++; j < len(selections) {
+					sel = selections[j]
+				}
+			}
+			for hi.end <= p && i < len(highlights) {
+				if i++; i < len(highlights) {
+					hi = highlights[i]
+				}
+			}
+			if p > sel.start && p < sel.end {
+				sel.attr.Out(t)
+			} else if p > hi.start && p < hi.end {
+				hi.attr.Out(t)
+			}
+			drawPoint = false
+			continue
+		}
 		drawSelection := false
 		drawHighlight := false
 		endSelection := false
@@ -181,6 +252,18 @@ func (view *View) DisplayText(t *term.Term, text []byte, point int, selections [
 			hi.attr.Out(t)
 		}
 
+		for k < len(view.links) && view.links[k].end <= p {
+			if linked {
+				t.Hyperlink("")
+				linked = false
+			}
+			k++
+		}
+		if !linked && k < len(view.links) && p >= view.links[k].start {
+			t.Hyperlink(view.links[k].uri)
+			linked = true
+		}
+
 		// Draw character.
 		r, s := utf8.DecodeRune(text[p:])
 		if r == '\t' {
@@ -204,24 +287,58 @@ func (view *View) DisplayText(t *term.Term, text []byte, point int, selections [
 			}
 			col = 0
 			l++
-			t.MoveTo(l, 0)
+			t.MoveTo(view.top+l, view.left)
+			bound = buffer.ChunkBound(p + 1)
+		} else if buffer.Invisible(r) {
+			if drawPoint {
+				theme["point"].Out(t)
+			} else {
+				theme["invisible"].Out(t)
+			}
+			t.Write([]byte(string(view.visual.invisibleChar)))
+			col++
+			// Restore the attributes on the next character, as after the point.
+			drawPoint = true
 		} else {
 			if drawPoint {
 				theme["point"].Out(t)
 			}
 			t.Write(text[p : p+s])
-			col++
+			col += buffer.RuneWidth(r)
 		}
 
 		if col >= width {
 			col = 0
 			l++
-			t.MoveTo(l, 0)
+			t.MoveTo(view.top+l, view.left)
 		}
 		p += s
+		if p >= bound {
+			if col > 0 {
+				t.MoveTo(view.top+l, view.left+width)
+				theme["normal"].Out(t)
+				t.Write([]byte(string(view.visual.chunkChar)))
+				if p >= sel.start && p < sel.end {
+					sel.attr.Out(t)
+				} else if p >= hi.start && p < hi.end {
+					hi.attr.Out(t)
+				}
+				col = 0
+				l++
+				t.MoveTo(view.top+l, view.left)
+			}
+			bound += buffer.MaxLineScan
+		}
 	}
 	view.end = p
+	if linked {
+		t.Hyperlink("")
+	}
 	theme["normal"].Out(t)
+	if indentGuides {
+		view.displayGuides(t, text, point)
+	}
+	view.displayBookmarks(t, text)
 	if p == len(text) {
 		if point == p {
 			theme["point"].Out(t)
@@ -231,34 +348,144 @@ func (view *View) DisplayText(t *term.Term, text []byte, point int, selections [
 		// Display EOF characters the rest of the view's height.
 		l++
 		for ; l < view.height; l++ {
-			t.MoveTo(l, 0)
+			t.MoveTo(view.top+l, view.left)
 			t.Write([]byte(string(view.visual.eofChar)))
 		}
 	}
 }
 
+// Return the offset of the text displayed at row and col of the view. Positions
+// past the end of a line map to the end of that line, positions below the end
+// of the text map to the end of the text.
+func (view *View) PositionAt(text []byte, row, col int) int {
+	p := view.start
+	_, bound := buffer.LineChunk(text, p)
+	ts := view.visual.tabStop
+	last := p
+	l, c := 0, 0
+	for p < len(text) && l <= row {
+		if f, ok := view.foldAt(p); ok && c == 0 {
+			if l == row {
+				return f.start
+			}
+			last = p
+			p = f.end
+			l++
+			bound = buffer.ChunkBound(p)
+			continue
+		}
+		r, s := utf8.DecodeRune(text[p:])
+		w := buffer.RuneWidth(r)
+		if r == '\t' {
+			w = min(view.width, c+ts-c%ts) - c
+		}
+		if l == row && (col < c+w || r == '\n') {
+			return p
+		}
+		last = p
+		if r == '\n' {
+			c = 0
+			l++
+			bound = buffer.ChunkBound(p + 1)
+		} else if c += w; c >= view.width {
+			c = 0
+			l++
+		}
+		p += s
+		if p >= bound {
+			if c > 0 {
+				c = 0
+				l++
+			}
+			bound += buffer.MaxLineScan
+		}
+	}
+	if l > row {
+		return last
+	}
+	return p
+}
+
+// Return the row and column of the view where the text at off is displayed.
+// This is the inverse of PositionAt. If off is not visible, ok is false.
+func (view *View) LocateOffset(text []byte, off int) (row, col int, ok bool) {
+	if off < view.start || off > len(text) {
+		return 0, 0, false
+	}
+	p := view.start
+	_, bound := buffer.LineChunk(text, p)
+	ts := view.visual.tabStop
+	for p < off && row < view.height {
+		if f, ok := view.foldAt(p); ok && col == 0 {
+			if off < f.end {
+				return 0, 0, false
+			}
+			p = f.end
+			row++
+			bound = buffer.ChunkBound(p)
+			continue
+		}
+		r, s := utf8.DecodeRune(text[p:])
+		if r == '\n' {
+			col = 0
+			row++
+			bound = buffer.ChunkBound(p + 1)
+		} else {
+			if r == '\t' {
+				col = min(view.width, col+ts-col%ts)
+			} else {
+				col += buffer.RuneWidth(r)
+			}
+			if col >= view.width {
+				col = 0
+				row++
+			}
+		}
+		p += s
+		if p >= bound {
+			if col > 0 {
+				col = 0
+				row++
+			}
+			bound += buffer.MaxLineScan
+		}
+	}
+	return row, col, row < view.height
+}
+
 func (view *View) ScrollDown(text []byte) {
-	_, view.start = visualLineEnd(text, view.start, view.visual.tabStop, view.width)
+	_, view.start = buffer.VisualLineEnd(text, view.start, view.visual.tabStop, view.width)
 }
 
 func (view *View) ScrollUp(text []byte) {
-	view.start, _ = visualLineStart(text, view.start-1, view.visual.tabStop, view.width)
+	view.start, _ = buffer.VisualLineStart(text, view.start-1, view.visual.tabStop, view.width)
 }
 
-func (view *View) PageDown(text []byte) {
-	for i := 0; i < view.height-3; i++ {
+// Scroll by n lines, up if n is negative.
+func (view *View) Scroll(text []byte, n int) {
+	for ; n > 0; n-- {
 		view.ScrollDown(text)
 	}
+	for ; n < 0; n++ {
+		view.ScrollUp(text)
+	}
+}
+
+// Lines scrolled by a page, keeping pageOverlap lines of the previous page.
+func (view *View) pageLines() int {
+	return max(1, view.height-pageOverlap)
+}
+
+func (view *View) PageDown(text []byte) {
+	view.Scroll(text, view.pageLines())
 }
 
 func (view *View) PageUp(text []byte) {
-	for i := 0; i < view.height-3; i++ {
-		view.ScrollUp(text)
-	}
+	view.Scroll(text, -view.pageLines())
 }
 
 func (view *View) ToPoint(text []byte, point int, up int) {
-	view.start, _ = visualLineStart(text, point, view.visual.tabStop, view.width)
+	view.start, _ = buffer.VisualLineStart(text, point, view.visual.tabStop, view.width)
 	for i := 0; i < up; i++ {
 		view.ScrollUp(text)
 	}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"github.com/jsynacek/med/term"
+	"sort"
+	"strings"
+)
+
+// Windows split the screen into columns, each displaying a different buffer.
+// The active window always displays med.file. Commands that switch med.file
+// don't have to care about windows, as they are synced before every redraw.
+
+// Put med.file into the active window. If it's already displayed in another
+// window, the two windows swap their buffers.
+func (med *Med) syncWindows() {
+	old := med.windows[med.window]
+	if old == med.file {
+		return
+	}
+	for i, e := range med.windows {
+		if e == med.file {
+			med.windows[i] = old
+		}
+	}
+	med.windows[med.window] = med.file
+}
+
+// Remove a closed buffer from all windows. The active window displays med.file.
+func (med *Med) closeBufferWindows(removed *list.Element) {
+	var windows []*list.Element
+	active := 0
+	for i, e := range med.windows {
+		if i == med.window {
+			active = len(windows)
+			windows = append(windows, med.file)
+		} else if e != removed && e != med.file {
+			windows = append(windows, e)
+		}
+	}
+	med.windows, med.window = windows, active
+}
+
+// Default relative size of a window.
+const windowSize = 10
+
+// Set view dimensions of all windows according to the terminal size and
+// their relative sizes. Windows are placed side by side, or stacked if
+// med.stacked is set.
+func (med *Med) layout() {
+	// The last row is for dialogs and errors.
+	cols, rows := term.Cols(), term.Rows()-1
+	if med.preview != nil {
+		cols = med.previewLayout(cols, rows)
+	}
+	left := 0
+	if med.outline != nil {
+		left = med.outlineLayout(cols)
+		cols -= left
+	}
+	if med.follow != nil {
+		if len(med.windows) == 1 {
+			med.followLayout(left, cols, rows)
+			return
+		}
+		// Another window was opened in the meantime.
+		med.follow = nil
+	}
+	if len(med.sizes) != len(med.windows) {
+		balanceWindows(med, nil)
+	}
+	total := 0
+	for _, s := range med.sizes {
+		total += s
+	}
+	pos := 0
+	for i, e := range med.windows {
+		view := &e.Value.(*File).view
+		next := pos + med.sizes[i]
+		if med.stacked {
+			view.left, view.width = left, cols-1
+			view.top = pos * rows / total
+			// Leave one row for the status line.
+			view.height = max(1, next*rows/total-view.top-1)
+		} else {
+			view.top, view.height = 0, max(1, rows-1)
+			view.left = left + pos*cols/total
+			// Leave one column free, for the point at the end of line and as a separator.
+			view.width = max(1, left+next*cols/total-view.left-1)
+		}
+		pos = next
+	}
+}
+
+func (med *Med) displayWindow(t *term.Term, file *File, active bool) {
+	var highlights []Highlight
+	var selections []Highlight
+	point := -1
+	if active {
+		point = file.point.Off
+		if med.selection.active {
+			ss, se := med.selectionRange(file)
+			selections = append(selections, Highlight{ss, se, theme["selection"]})
+		}
+		if med.flash != nil {
+			selections = append(selections, Highlight{med.flash.start, med.flash.end, theme["flash"]})
+		}
+		selections = append(selections, med.matches...)
+	}
+	selections = append(selections, file.diagnosticHighlights()...)
+	if file.duplicates {
+		selections = append(selections, duplicateLines(file.text.Bytes())...)
+	}
+	sort.Slice(selections, func(i, j int) bool {
+		return selections[i].start < selections[j].start
+	})
+
+	if med.follow != nil {
+		med.followAdjust(file)
+	} else {
+		file.view.AdjustToPoint(file.text.Bytes(), file.point.Off)
+	}
+	file.view.folds = file.closedFolds(file.point.Off)
+	file.view.links = file.links(&file.view)
+	file.view.bookmarks = file.bookmarkLines()
+	highlights = med.highlights(file, &file.view)
+	// TODO: Redraw only when cursor moves off screen or on insert/delete.
+	file.view.DisplayText(t, file.text.Bytes(), point, selections, highlights)
+	displayStatus(t, &file.view, med.statusLine(file, active))
+
+	if med.follow != nil {
+		// The second view continues where the first one ends.
+		med.follow.start = file.view.end
+		med.follow.visual = file.view.visual
+		med.follow.folds = file.view.folds
+		med.follow.bookmarks = file.view.bookmarks
+		med.follow.links = file.links(med.follow)
+		med.follow.DisplayText(t, file.text.Bytes(), point, selections, med.highlights(file, med.follow))
+		displayStatus(t, med.follow, med.statusLine(file, false))
+	}
+}
+
+func (med *Med) highlights(file *File, view *View) []Highlight {
+	if med.diff != nil && med.diff.shows(file) {
+		return med.diff.highlights(file)
+	} else if file.highlights != nil {
+		return file.highlights
+	} else if showSyntax {
+		return file.syntax(view.start, view.height)
+	}
+	return nil
+}
+
+func displayStatus(t *term.Term, view *View, line string) {
+	t.AttrReset()
+	t.MoveTo(view.top+view.height, view.left)
+	theme["status"].Out(t)
+	status := []rune(line)
+	w := view.width + 1
+	if len(status) > w {
+		status = status[:w]
+	}
+	t.Write([]byte(string(status) + strings.Repeat(" ", w-len(status))))
+}
+
+// In follow mode, the active buffer is displayed in two columns. The second
+// column continues where the first one ends, as if the text was printed in
+// two columns on a page. Scrolling moves the text through both of them.
+
+// Split cols columns starting at left into two columns of the same width for
+// follow mode.
+func (med *Med) followLayout(left, cols, rows int) {
+	view := &med.windows[0].Value.(*File).view
+	w := cols / 2
+	view.top, view.height = 0, max(1, rows-1)
+	view.left, view.width = left, max(1, w-1)
+	med.follow.top, med.follow.height = view.top, view.height
+	med.follow.left, med.follow.width = left+w, view.width
+}
+
+// Adjust the view of file so the point is visible in one of the columns.
+func (med *Med) followAdjust(file *File) {
+	// Both columns have the same width, so they scroll as one view twice as high.
+	both := file.view
+	both.height *= 2
+	both.end = both.PositionAt(file.text.Bytes(), both.height, 0)
+	both.AdjustToPoint(file.text.Bytes(), file.point.Off)
+	file.view.start = both.start
+}
+
+// Toggle follow mode. It replaces all windows with one displaying the active
+// buffer.
+func toggleFollow(med *Med, file *File) {
+	if med.follow != nil {
+		med.follow = nil
+		return
+	}
+	med.follow = &View{}
+	med.windows, med.window = []*list.Element{med.file}, 0
+	med.sizes = nil
+}
+
+// Open a new window to the right of the active one, showing a buffer that is
+// not displayed yet.
+func splitWindow(med *Med, file *File) {
+	e := med.file
+	for {
+		if e = e.Next(); e == nil {
+			e = med.files.Front()
+		}
+		if e == med.file {
+			med.pushError(errors.New("no buffer to show in a new window"))
+			return
+		}
+		shown := false
+		for _, w := range med.windows {
+			shown = shown || w == e
+		}
+		if !shown {
+			break
+		}
+	}
+	i := med.window + 1
+	med.windows = append(med.windows[:i], append([]*list.Element{e}, med.windows[i:]...)...)
+}
+
+// Display buffer e in a window next to the active one, unless it's displayed
+// already.
+func (med *Med) showBuffer(e *list.Element) {
+	for _, w := range med.windows {
+		if w == e {
+			return
+		}
+	}
+	if len(med.windows) == 1 {
+		med.windows = append(med.windows, e)
+	} else {
+		med.windows[(med.window+1)%len(med.windows)] = e
+	}
+}
+
+// Make all windows the same size.
+func balanceWindows(med *Med, file *File) {
+	med.sizes = make([]int, len(med.windows))
+	for i := range med.sizes {
+		med.sizes[i] = windowSize
+	}
+}
+
+func (med *Med) resizeWindow(delta int) {
+	med.layout()
+	med.sizes[med.window] = max(windowSize/5, med.sizes[med.window]+delta)
+}
+func growWindow(med *Med, file *File) {
+	med.resizeWindow(windowSize / 5)
+}
+func shrinkWindow(med *Med, file *File) {
+	med.resizeWindow(-windowSize / 5)
+}
+
+// Switch between windows side by side and stacked windows.
+func toggleStacking(med *Med, file *File) {
+	med.stacked = !med.stacked
+}
+
+// Move every buffer to the next window. The active window follows its buffer.
+func rotateWindows(med *Med, file *File) {
+	n := len(med.windows)
+	med.layout()
+	med.windows = append([]*list.Element{med.windows[n-1]}, med.windows[:n-1]...)
+	med.sizes = append([]int{med.sizes[n-1]}, med.sizes[:n-1]...)
+	med.window = (med.window + 1) % n
+}
+
+func closeWindow(med *Med, file *File) {
+	if len(med.windows) == 1 {
+		med.pushError(errors.New("refusing to close last window"))
+		return
+	}
+	med.windows = append(med.windows[:med.window], med.windows[med.window+1:]...)
+	med.window = min(med.window, len(med.windows)-1)
+	med.file = med.windows[med.window]
+	commandMode(med, file)
+}
+
+func otherWindow(med *Med, file *File) {
+	med.window = (med.window + 1) % len(med.windows)
+	med.file = med.windows[med.window]
+	commandMode(med, file)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// YAML is highlighted line by line: comments, keys, quoted strings and the
+// plain values that are numbers, booleans or null. The lines of block scalars,
+// started by | or >, are strings.
+
+var (
+	yamlNumber  = regexp.MustCompile(`^[-+]?(\.inf|\.nan|0x[0-9a-fA-F]+|0o[0-7]+|[0-9][0-9_]*(\.[0-9]*)?([eE][-+]?[0-9]+)?)$`)
+	yamlKeyword = regexp.MustCompile(`^(true|false|True|False|TRUE|FALSE|yes|no|on|off|null|Null|NULL|~)$`)
+	yamlBlock   = regexp.MustCompile(`[|>][-+0-9]*$`)
+)
+
+// Return the end of the quoted string starting at p in line.
+func yamlString(line []byte, p int) int {
+	q := line[p]
+	for p++; p < len(line); p++ {
+		if q == '"' && line[p] == '\\' {
+			p++
+		} else if line[p] == q {
+			if q == '\'' && p+1 < len(line) && line[p+1] == '\'' {
+				// An escaped quote.
+				p++
+				continue
+			}
+			return p + 1
+		}
+	}
+	return len(line)
+}
+
+// Highlight a line of YAML starting at off in the text. Returns whether the
+// line starts a block scalar.
+func yamlLine(line []byte, off int) (res []Highlight, block bool) {
+	add := func(start, end int, attr string) {
+		res = append(res, Highlight{off + start, off + end, theme[attr]})
+	}
+	trimmed := bytes.TrimRight(line, " \t\r")
+	if bytes.Equal(trimmed, []byte("---")) || bytes.Equal(trimmed, []byte("...")) {
+		add(0, len(trimmed), "keyword")
+		return
+	}
+	p := 0
+	for p < len(line) && (line[p] == ' ' || line[p] == '\t' || line[p] == '-' && (p+1 == len(line) || line[p+1] == ' ')) {
+		p++
+	}
+	// Key, plain or quoted, if there is one.
+	if p < len(line) && line[p] != '#' {
+		e := p
+		if line[p] == '"' || line[p] == '\'' {
+			e = yamlString(line, p)
+		}
+		if i := bytes.Index(line[e:], []byte(": ")); i >= 0 && bytes.IndexByte(line[p:e+i], '#') < 0 {
+			add(p, e+i, "key")
+			p = e + i + 1
+		} else if bytes.HasSuffix(trimmed, []byte(":")) && bytes.IndexByte(trimmed[p:], '#') < 0 {
+			add(p, len(trimmed)-1, "key")
+			return
+		}
+	}
+	for p < len(line) && line[p] == ' ' {
+		p++
+	}
+	// Value and a comment after it.
+	value := line[p:]
+	comment := -1
+	if i := bytes.Index(value, []byte(" #")); i >= 0 {
+		comment = p + i + 1
+		value = value[:i]
+	} else if len(value) > 0 && value[0] == '#' {
+		add(p, len(line), "comment")
+		return
+	}
+	if comment >= 0 {
+		defer add(comment, len(line), "comment")
+	}
+	value = bytes.TrimRight(value, " \t\r")
+	switch {
+	case len(value) == 0:
+	case value[0] == '"' || value[0] == '\'':
+		add(p, p+yamlString(value, 0), "string")
+	case yamlNumber.Match(value):
+		add(p, p+len(value), "number")
+	case yamlKeyword.Match(value):
+		add(p, p+len(value), "keyword")
+	case yamlBlock.Match(value):
+		block = true
+	}
+	return
+}
+
+// Indentation of line and whether it's blank.
+func yamlIndent(line []byte) (int, bool) {
+	n := len(line) - len(bytes.TrimLeft(line, " "))
+	return n, len(bytes.TrimSpace(line)) == 0
+}
+
+// Highlight YAML.
+func yamlSyntax(text []byte, off int, maxLines int) (res []Highlight) {
+	// Always scan from the beginning of the text, as off might point into
+	// a block scalar.
+	block, indent := false, 0
+	for p, l := 0, 0; p < len(text) && l < maxLines; {
+		e := bytes.IndexByte(text[p:], '\n')
+		if e < 0 {
+			e = len(text)
+		} else {
+			e += p
+		}
+		line := text[p:e]
+		n, blank := yamlIndent(line)
+		if block && !blank && n <= indent {
+			block = false
+		}
+		var hs []Highlight
+		if block {
+			hs = []Highlight{{p, e, theme["string"]}}
+		} else {
+			var starts bool
+			hs, starts = yamlLine(line, p)
+			if starts {
+				block, indent = true, n
+			}
+		}
+		if e >= off {
+			res = append(res, hs...)
+			l++
+		}
+		p = e + 1
+	}
+	return
+}